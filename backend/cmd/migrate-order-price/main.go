@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/Talonmortem/SHM/db"
+)
+
+/*
+Перенос orders.price в корректировки доставки. Сначала без флагов — посмотреть список заказов,
+потом -apply, после проверки долгов — -drop-column.
+
+docker compose run --rm backend go run ./cmd/migrate-order-price
+docker compose run --rm backend go run ./cmd/migrate-order-price -apply
+docker compose run --rm backend go run ./cmd/migrate-order-price -drop-column
+*/
+
+func main() {
+	apply := flag.Bool("apply", false, "Create delivery adjustments and recalculate debts")
+	dropColumn := flag.Bool("drop-column", false, "Drop orders.price once every price is migrated")
+	flag.Parse()
+
+	db.ConnectDB()
+	defer db.CloseDB()
+	db.CreateTables()
+
+	if *dropColumn {
+		if err := db.DropOrderPriceColumn(); err != nil {
+			log.Fatalf("drop orders.price failed: %v", err)
+		}
+		log.Println("orders.price dropped")
+		return
+	}
+
+	result, err := db.MigrateOrderPrice(*apply)
+	if err != nil {
+		log.Fatalf("orders.price migration failed: %v", err)
+	}
+	log.Printf("orders.price: moved=%d same_as_products=%d locked=%v pending=%v", result.Moved, result.SameAsProducts, result.Locked, result.Pending)
+	if !*apply && len(result.Pending) > 0 {
+		log.Println("Dry run: nothing changed, rerun with -apply")
+	}
+}
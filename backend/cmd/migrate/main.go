@@ -314,7 +314,7 @@ func migratePayments(src *sql.DB, dst *sql.Tx) (int, error) {
 		}
 		if _, err := dst.Exec(`
 			INSERT INTO payments_monitoring (id, date, method, order_id, amount, comment)
-			VALUES ($1, $2, $3, $4, $5, $6)
+			VALUES ($1, shm_parse_timestamp($2), $3, $4, $5, $6)
 		`, id, date, method, orderID, amount, comment); err != nil {
 			return n, err
 		}
@@ -1,19 +1,42 @@
 package main
 
 import (
+	"log"
+
 	"github.com/gin-gonic/gin"
 
+	"github.com/Talonmortem/SHM/config"
 	"github.com/Talonmortem/SHM/db"
 	"github.com/Talonmortem/SHM/internal/handlers"
 	"github.com/Talonmortem/SHM/internal/middleware"
+	"github.com/Talonmortem/SHM/internal/storage"
 )
 
 func main() {
+	cfg := config.Load()
 
+	if err := db.SetBusinessTimeZone(cfg.BusinessTimeZone); err != nil {
+		log.Fatal(err)
+	}
+	store, err := storage.New(storage.Config{
+		Driver:      cfg.AttachmentsStorage,
+		Dir:         cfg.AttachmentsDir,
+		S3Endpoint:  cfg.S3Endpoint,
+		S3Region:    cfg.S3Region,
+		S3Bucket:    cfg.S3Bucket,
+		S3AccessKey: cfg.S3AccessKey,
+		S3SecretKey: cfg.S3SecretKey,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.SetAttachmentStorage(store, int64(cfg.AttachmentsMaxSizeMB)<<20)
 	db.ConnectDB()
 	defer db.CloseDB()
 	db.CreateTables()
 	middleware.LoadUsersRoles()
+	db.StartReservationExpiryWatcher(cfg.ReservationExpiryDays, cfg.ReservationExpiryAction)
+	db.StartIdempotencyKeyCleanup()
 
 	r := gin.Default()
 	r.Use(middleware.CORSMiddleware())
@@ -34,32 +57,114 @@ func main() {
 	{
 		protected.GET("/products/generate-name", handlers.GenerateProductNameHandler)
 		protected.GET("/products", db.GetProducts)
+		protected.GET("/products/export", db.ExportProducts)
 		protected.POST("/products", db.CreateProduct)
+		protected.GET("/products/:id", db.GetProduct)
 		protected.PUT("/products/:id", db.UpdateProduct)
 		protected.DELETE("/products/:id", db.DeleteProduct)
+		protected.GET("/products/:id/card", db.GetProductCard)
+		protected.GET("/product_card_templates", db.GetProductCardTemplates)
+		protected.POST("/product_card_templates", db.CreateProductCardTemplate)
+		protected.PUT("/product_card_templates/:id", db.UpdateProductCardTemplate)
+		protected.DELETE("/product_card_templates/:id", db.DeleteProductCardTemplate)
 		protected.GET("/orders", db.GetOrders)
-		protected.POST("/orders", db.CreateOrder)
+		protected.GET("/orders/export", db.ExportOrders)
+		protected.POST("/orders", middleware.Idempotency(), db.CreateOrder)
+		protected.GET("/orders/:id", db.GetOrder)
 		protected.PUT("/orders/:id", db.UpdateOrder)
 		protected.DELETE("/orders/:id", db.DeleteOrder)
+		protected.POST("/orders/:id/cancel", db.CancelOrder)
+		protected.POST("/orders/:id/return", db.ReturnOrder)
+		protected.POST("/orders/:id/split", db.SplitOrder)
+		protected.POST("/orders/merge", db.MergeOrders)
+		protected.GET("/orders/:id/payments", db.GetOrderPayments)
+		protected.POST("/orders/:id/payments", middleware.Idempotency(), db.CreateOrderPayment)
+		protected.PUT("/orders/:id/payments/:payment_id", db.UpdateOrderPayment)
+		protected.DELETE("/orders/:id/payments/:payment_id", db.DeleteOrderPayment)
+		protected.POST("/orders/:id/refunds", middleware.Idempotency(), db.CreateOrderRefund)
+		protected.PUT("/orders/:id/refunds/:payment_id", db.UpdateOrderRefund)
+		protected.DELETE("/orders/:id/refunds/:payment_id", db.DeleteOrderRefund)
+		protected.GET("/orders/:id/documents/:doc", handlers.OrderDocumentHandler)
+		protected.GET("/document_sequences", db.GetDocumentSequences)
+		protected.PUT("/document_sequences/:doc_type", db.UpdateDocumentSequence)
+		protected.GET("/returns", db.GetReturns)
+		protected.POST("/returns", db.CreateReturn)
+		protected.POST("/returns/:id/receive", db.ReceiveReturn)
+		protected.DELETE("/returns/:id", db.DeleteReturn)
+		protected.GET("/reservation_expiry_events", db.GetReservationExpiryEvents)
 		protected.GET("/payment_methods", db.GetPaymentMethods)
+		protected.POST("/payment_methods", db.CreatePaymentMethod)
+		protected.PUT("/payment_methods/:id", db.UpdatePaymentMethod)
+		protected.DELETE("/payment_methods/:id", db.DeletePaymentMethod)
+		protected.GET("/exchange_rates", db.GetExchangeRates)
+		protected.PUT("/exchange_rates", db.SaveExchangeRate)
+		protected.DELETE("/exchange_rates", db.DeleteExchangeRate)
 		protected.GET("/payments_monitoring", db.GetPaymentsMonitoring)
-		protected.POST("/payments", db.CreatePayment)
+		protected.GET("/payments_monitoring/export", db.ExportPaymentsMonitoring)
+		protected.GET("/accounting/export", db.ExportAccounting)
+		protected.GET("/payments_monitoring/summary", db.GetPaymentsMonitoringSummary)
+		protected.POST("/payments_monitoring/confirm", db.ConfirmPayments)
+		protected.POST("/payments", middleware.Idempotency(), db.CreatePayment)
 		protected.PUT("/payments/:id", db.UpdatePayment)
 		protected.DELETE("/payments/:id", db.DeletePayment)
+		protected.POST("/payments/:id/confirm", db.ConfirmPayment)
+		protected.POST("/payments/:id/reject", db.RejectPayment)
+		protected.GET("/period_lock", db.GetPeriodLock)
+		protected.PUT("/period_lock", db.SetPeriodLock)
+		protected.GET("/payments/:id/attachments", db.ListAttachments(db.AttachmentPayment))
+		protected.POST("/payments/:id/attachments", db.UploadAttachment(db.AttachmentPayment))
+		protected.GET("/payments/:id/attachments/:attachment_id", db.DownloadAttachment(db.AttachmentPayment))
+		protected.DELETE("/payments/:id/attachments/:attachment_id", db.DeleteAttachment(db.AttachmentPayment))
+		protected.GET("/orders/:id/attachments", db.ListAttachments(db.AttachmentOrder))
+		protected.POST("/orders/:id/attachments", db.UploadAttachment(db.AttachmentOrder))
+		protected.GET("/orders/:id/attachments/:attachment_id", db.DownloadAttachment(db.AttachmentOrder))
+		protected.DELETE("/orders/:id/attachments/:attachment_id", db.DeleteAttachment(db.AttachmentOrder))
+		protected.GET("/shipments/:id/attachments", db.ListAttachments(db.AttachmentShipment))
+		protected.POST("/shipments/:id/attachments", db.UploadAttachment(db.AttachmentShipment))
+		protected.GET("/shipments/:id/attachments/:attachment_id", db.DownloadAttachment(db.AttachmentShipment))
+		protected.DELETE("/shipments/:id/attachments/:attachment_id", db.DeleteAttachment(db.AttachmentShipment))
+		protected.GET("/products/:id/attachments", db.ListAttachments(db.AttachmentProduct))
+		protected.POST("/products/:id/attachments", db.UploadAttachment(db.AttachmentProduct))
+		protected.GET("/products/:id/attachments/:attachment_id", db.DownloadAttachment(db.AttachmentProduct))
+		protected.DELETE("/products/:id/attachments/:attachment_id", db.DeleteAttachment(db.AttachmentProduct))
+		protected.GET("/bank_statements", db.GetBankStatements)
+		protected.POST("/bank_statements/import", db.ImportBankStatement)
+		protected.GET("/bank_statements/:id", db.GetBankStatement)
+		protected.POST("/bank_statements/:id/confirm", db.ConfirmBankStatement)
+		protected.GET("/cash_book", db.GetCashBook)
+		protected.GET("/cash_book/reconciliation", db.GetCashReconciliation)
+		protected.PUT("/cash_book/counts", db.SaveCashCount)
+		protected.DELETE("/cash_book/counts", db.DeleteCashCount)
+		protected.GET("/cash_movements", db.GetCashMovements)
+		protected.POST("/cash_movements", db.CreateCashMovement)
+		protected.PUT("/cash_movements/:id", db.UpdateCashMovement)
+		protected.DELETE("/cash_movements/:id", db.DeleteCashMovement)
 		protected.GET("/users", db.GetUsers)
 		protected.POST("/users", db.CreateUser)
 		protected.PUT("/users/:id", db.UpdateUser)
 		protected.GET("/articles", db.GetArticles)
+		protected.GET("/articles/export", db.ExportArticles)
 		protected.POST("/articles", db.CreateArticle)
+		protected.GET("/articles/:id", db.GetArticle)
 		protected.PUT("/articles/:id", db.UpdateArticle)
 		protected.DELETE("/articles/:id", db.DeleteArticle)
 		protected.GET("/balance", db.GetBalance)
+		protected.GET("/balance/export", db.ExportBalance)
+		protected.GET("/reports/receivables", db.GetReceivablesReport)
 		protected.GET("/clients", db.GetClients)
+		protected.GET("/clients/export", db.ExportClients)
 		protected.POST("/clients", db.CreateClient)
+		protected.GET("/clients/:id", db.GetClient)
 		protected.PUT("/clients/:id", db.UpdateClient)
 		protected.DELETE("/clients/:id", db.DeleteClient)
+		protected.GET("/clients/:id/orders", db.GetClientOrders)
+		protected.GET("/clients/:id/balance", db.GetClientBalance)
+		protected.POST("/clients/:id/payments", middleware.Idempotency(), db.CreateClientPayment)
+		protected.POST("/clients/:id/payments/:payment_id/allocations", db.AllocateClientPayment)
+		protected.DELETE("/clients/:id/payments/:payment_id/allocations/:allocation_id", db.DeleteClientAllocation)
 		protected.GET("/shipments", db.GetShipments)
-		protected.POST("/shipments", db.CreateShipment)
+		protected.GET("/shipments/export", db.ExportShipments)
+		protected.POST("/shipments", middleware.Idempotency(), db.CreateShipment)
 		protected.PUT("/shipments/:id", db.UpdateShipment)
 		protected.DELETE("/shipments/:id", db.DeleteShipment)
 		protected.GET("/shipment_notes", db.GetShipmentNotes)
@@ -1,17 +1,75 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+)
 
 type Config struct {
 	JWTSecret string
 	DBDSN     string
 	APIKey    string
+
+	// Бронь неоплаченного заказа: через сколько дней истекает (0 — выключено)
+	// и что делать по истечении: warn (предупредить) или release (снять бронь).
+	ReservationExpiryDays   int
+	ReservationExpiryAction string
+
+	// Печатные документы заказа: TTF-шрифт с кириллицей и реквизиты продавца для шапки счёта.
+	PDFFontPath     string
+	DocumentsSeller string
+
+	// Часовой пояс бизнеса: в нём считаются дни оплат и отгрузок.
+	BusinessTimeZone string
+
+	// Вложения: хранилище (local, s3 или memory), каталог для local и предельный размер файла.
+	AttachmentsStorage   string
+	AttachmentsDir       string
+	AttachmentsMaxSizeMB int
+	S3Endpoint           string
+	S3Region             string
+	S3Bucket             string
+	S3AccessKey          string
+	S3SecretKey          string
 }
 
 func Load() Config {
 	return Config{
-		JWTSecret: os.Getenv("JWT_SECRET"),
-		DBDSN:     os.Getenv("DB_DSN"),
-		APIKey:    os.Getenv("API_KEY"),
+		JWTSecret:               os.Getenv("JWT_SECRET"),
+		DBDSN:                   os.Getenv("DB_DSN"),
+		APIKey:                  os.Getenv("API_KEY"),
+		ReservationExpiryDays:   envInt("RESERVATION_EXPIRY_DAYS", 0),
+		ReservationExpiryAction: strings.ToLower(strings.TrimSpace(os.Getenv("RESERVATION_EXPIRY_ACTION"))),
+		PDFFontPath:             envString("PDF_FONT_PATH", "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"),
+		DocumentsSeller:         strings.TrimSpace(os.Getenv("DOCUMENTS_SELLER")),
+		BusinessTimeZone:        envString("BUSINESS_TIMEZONE", "Europe/Moscow"),
+		AttachmentsStorage:      strings.ToLower(envString("ATTACHMENTS_STORAGE", "local")),
+		AttachmentsDir:          envString("ATTACHMENTS_DIR", "data/attachments"),
+		AttachmentsMaxSizeMB:    envInt("ATTACHMENTS_MAX_SIZE_MB", 10),
+		S3Endpoint:              strings.TrimSpace(os.Getenv("S3_ENDPOINT")),
+		S3Region:                strings.TrimSpace(os.Getenv("S3_REGION")),
+		S3Bucket:                strings.TrimSpace(os.Getenv("S3_BUCKET")),
+		S3AccessKey:             strings.TrimSpace(os.Getenv("S3_ACCESS_KEY")),
+		S3SecretKey:             strings.TrimSpace(os.Getenv("S3_SECRET_KEY")),
+	}
+}
+
+func envInt(key string, fallback int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func envString(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
 	}
+	return fallback
 }
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Talonmortem/SHM/internal/accounting"
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Выгрузка для бухгалтерии (1С): реализации — отгруженные заказы с днём отгрузки в периоде,
+// оплаты — подтверждённые поступления и возвраты за период. Номер реализации — номер
+// упаковочного листа заказа (выдаётся при первой выгрузке или печати и дальше не меняется),
+// номер оплаты строится из её id. Код клиента — client-<id> из справочника, а у заказа
+// без клиента — order-<id>.
+
+const accountingPaymentPrefix = "ПЛ-"
+
+var accountingServiceNames = map[string]string{
+	adjustmentDelivery:  "Доставка",
+	adjustmentPackaging: "Упаковка",
+}
+
+// ExportAccounting — GET /accounting/export?date_from=&date_to=&format=commerceml|csv.
+func ExportAccounting(c *gin.Context) {
+	format, err := accounting.ParseFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(c.Query("date_from")) == "" || strings.TrimSpace(c.Query("date_to")) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date_from and date_to are required"})
+		return
+	}
+	from, err := normalizeDateInput(c.Query("date_from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date_from"})
+		return
+	}
+	to, err := normalizeDateInput(c.Query("date_to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date_to"})
+		return
+	}
+	if from > to {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date_from is after date_to"})
+		return
+	}
+
+	e := accounting.Export{From: from, To: to, Created: businessNow()}
+	if e.Sales, err = loadAccountingSales(from, to); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load sales: " + err.Error()})
+		return
+	}
+	if e.Payments, err = loadAccountingPayments(from, to); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load payments: " + err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", accounting.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="1c-%s-%s.%s"`, from, to, accounting.Extension(format)))
+	c.Status(http.StatusOK)
+	if err := accounting.Write(c.Writer, format, e); err != nil {
+		log.Printf("Accounting export %s..%s failed: %v", from, to, err)
+	}
+}
+
+func accountingClientRef(clientID, orderID int) string {
+	if clientID > 0 {
+		return fmt.Sprintf("client-%d", clientID)
+	}
+	return fmt.Sprintf("order-%d", orderID)
+}
+
+func loadAccountingSales(from, to string) ([]accounting.Sale, error) {
+	// День реализации считается так же, как при закрытии периода: ship_date или день создания.
+	const saleDay = "COALESCE(o.ship_date, o.created_at::date)"
+	rows, err := DB.Query(`
+		SELECT o.id, to_char(`+saleDay+`, 'YYYY-MM-DD') FROM orders o
+		WHERE o.status = $1 AND `+saleDay+` BETWEEN $2 AND $3
+		ORDER BY `+saleDay+`, o.id
+	`, orderStatusShipped, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	dates := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var date string
+		if err := rows.Scan(&id, &date); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		dates[id] = date
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sales := make([]accounting.Sale, 0, len(ids))
+	for _, id := range ids {
+		order, err := LoadOrder(id)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := OrderDocumentNumber(id, DocumentPackingList)
+		if err != nil {
+			return nil, err
+		}
+		sales = append(sales, accountingSale(order, doc.Number, dates[id]))
+	}
+	return sales, nil
+}
+
+func accountingSale(order models.Order, number, date string) accounting.Sale {
+	sale := accounting.Sale{
+		Number:  number,
+		Date:    date,
+		OrderID: order.ID,
+		Order:   order.Name,
+		Client: accounting.Client{
+			Ref:   accountingClientRef(order.ClientID, order.ID),
+			Name:  order.FullName,
+			Phone: order.Phone,
+			INN:   order.PassportInn,
+			City:  order.City,
+		},
+		Total: roundMoney(order.Total),
+	}
+
+	components := append([]models.Product(nil), order.Components...)
+	sort.Slice(components, func(i, j int) bool { return components[i].ID < components[j].ID })
+	for _, p := range components {
+		amount, err := parseAmount(p.SummaRubSoSkidkoj)
+		if err != nil {
+			log.Printf("Accounting export: failed to parse price of product %d: %v", p.ID, err)
+		}
+		sale.Lines = append(sale.Lines, accounting.Line{
+			Ref:      fmt.Sprintf("product-%d", p.ID),
+			Name:     p.Name,
+			Quantity: 1,
+			Amount:   roundMoney(amount),
+		})
+	}
+
+	// Value корректировок посчитан при загрузке заказа. Скидки раскладываются по мешкам,
+	// доставка и упаковка идут отдельными услугами.
+	discount := 0.0
+	for _, adj := range order.Adjustments {
+		if adj.Value < 0 {
+			discount -= adj.Value
+			continue
+		}
+		if adj.Value == 0 {
+			continue
+		}
+		name := accountingServiceNames[adj.Kind]
+		if name == "" {
+			name = adj.Kind
+		}
+		if adj.Comment != "" {
+			name += " (" + adj.Comment + ")"
+		}
+		sale.Lines = append(sale.Lines, accounting.Line{
+			Ref:      "service-" + adj.Kind,
+			Name:     name,
+			Service:  true,
+			Quantity: 1,
+			Amount:   roundMoney(adj.Value),
+		})
+	}
+	accounting.SpreadDiscount(sale.Lines, roundMoney(discount))
+	return sale
+}
+
+func loadAccountingPayments(from, to string) ([]accounting.Payment, error) {
+	rows, err := DB.Query(`
+		SELECT pm.id, to_char(pm.date, 'YYYY-MM-DD HH24:MI:SS'), pm.method, COALESCE(pp.type, ''), pm.type,
+			COALESCE(pm.amount, 0), pm.currency, COALESCE(pm.original_amount, pm.amount, 0), COALESCE(pm.comment, ''),
+			COALESCE(pm.order_id, 0), COALESCE(pm.client_id, o.client_id, 0),
+			COALESCE(NULLIF(o.full_name, ''), cl.full_name, ''), COALESCE(NULLIF(o.phone, ''), cl.phone, ''),
+			COALESCE(NULLIF(o.passport_inn, ''), cl.passport_number, ''), COALESCE(NULLIF(o.city, ''), cl.city, '')
+		FROM payments_monitoring pm
+		LEFT JOIN payment_methods pp ON pp.method = pm.method
+		LEFT JOIN orders o ON o.id = pm.order_id
+		LEFT JOIN clients cl ON cl.id = COALESCE(pm.client_id, o.client_id)
+		WHERE pm.status = $1 AND pm.date::date BETWEEN $2 AND $3 AND COALESCE(pm.amount, 0) <> 0
+		ORDER BY pm.date, pm.id
+	`, paymentStatusConfirmed, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := make([]accounting.Payment, 0)
+	for rows.Next() {
+		var p accounting.Payment
+		var methodType, paymentType string
+		var clientID int
+		if err := rows.Scan(&p.ID, &p.Date, &p.Method, &methodType, &paymentType,
+			&p.Amount, &p.Currency, &p.OriginalAmount, &p.Comment,
+			&p.OrderID, &clientID, &p.Client.Name, &p.Client.Phone, &p.Client.INN, &p.Client.City); err != nil {
+			return nil, err
+		}
+		p.Number = fmt.Sprintf("%s%06d", accountingPaymentPrefix, p.ID)
+		p.Cash = methodType == "cash"
+		p.Refund = paymentType == paymentTypeRefund
+		p.Amount = roundMoney(math.Abs(p.Amount))
+		p.OriginalAmount = roundMoney(math.Abs(p.OriginalAmount))
+		if clientID > 0 || p.OrderID > 0 {
+			p.Client.Ref = accountingClientRef(clientID, p.OrderID)
+		} else {
+			p.Client.Ref = fmt.Sprintf("payment-%d", p.ID)
+		}
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
@@ -1,8 +1,10 @@
 package db
 
 import (
+	"database/sql"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/Talonmortem/SHM/internal/models"
 	"github.com/gin-gonic/gin"
@@ -145,18 +147,53 @@ func CreateArticle(c *gin.Context) {
 	err := DB.QueryRow(`
 		INSERT INTO articles (id, no, code, description, euro, colli, kg, value)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING service_id
-	`, article.ID, article.No, article.Code, article.Description, article.Euro, article.Colli, article.KG, article.Value).Scan(&article.ServiceID)
+		RETURNING service_id, version
+	`, article.ID, article.No, article.Code, article.Description, article.Euro, article.Colli, article.KG, article.Value).Scan(&article.ServiceID, &article.Version)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	setETag(c, article.Version)
+	c.JSON(http.StatusOK, article)
+}
+
+const articleColumns = "service_id, id, no, code, description, euro, colli, kg, value, version"
+
+func scanArticle(row interface{ Scan(...any) error }, article *models.Article) error {
+	return row.Scan(&article.ServiceID, &article.ID, &article.No, &article.Code, &article.Description, &article.Euro, &article.Colli, &article.KG, &article.Value, &article.Version)
+}
+
+func loadArticle(serviceID int) (models.Article, error) {
+	var article models.Article
+	err := scanArticle(DB.QueryRow("SELECT "+articleColumns+" FROM articles WHERE service_id = $1", serviceID), &article)
+	return article, err
+}
+
+// GetArticle отдаёт один артикул; ETag — его версия для If-Match при сохранении.
+func GetArticle(c *gin.Context) {
+	serviceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	article, err := loadArticle(serviceID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setETag(c, article.Version)
 	c.JSON(http.StatusOK, article)
 }
 
 func GetArticles(c *gin.Context) {
-	rows, err := DB.Query(`SELECT service_id, id, no, code, description, euro, colli, kg, value FROM articles ORDER BY id`)
+	rows, err := DB.Query("SELECT " + articleColumns + " FROM articles ORDER BY id")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -166,7 +203,7 @@ func GetArticles(c *gin.Context) {
 	var articles []models.Article
 	for rows.Next() {
 		var article models.Article
-		if err := rows.Scan(&article.ServiceID, &article.ID, &article.No, &article.Code, &article.Description, &article.Euro, &article.Colli, &article.KG, &article.Value); err != nil {
+		if err := scanArticle(rows, &article); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -177,24 +214,48 @@ func GetArticles(c *gin.Context) {
 }
 
 func UpdateArticle(c *gin.Context) {
-	serviceID := c.Param("id")
+	serviceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	expectedVersion, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
 	var article models.Article
 	if err := c.ShouldBindJSON(&article); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err := DB.Exec(`
+	err = DB.QueryRow(`
 		UPDATE articles
-		SET id = $1, no = $2, code = $3, description = $4, euro = $5, colli = $6, kg = $7, value = $8
-		WHERE service_id = $9
-	`, article.ID, article.No, article.Code, article.Description, article.Euro, article.Colli, article.KG, article.Value, serviceID)
+		SET id = $1, no = $2, code = $3, description = $4, euro = $5, colli = $6, kg = $7, value = $8, version = version + 1
+		WHERE service_id = $9 AND ($10::BIGINT = -1 OR version = $10)
+		RETURNING version
+	`, article.ID, article.No, article.Code, article.Description, article.Euro, article.Colli, article.KG, article.Value, serviceID, expectedVersion).Scan(&article.Version)
+	if err == sql.ErrNoRows {
+		current, loadErr := loadArticle(serviceID)
+		if loadErr == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Article not found"})
+			return
+		}
+		if loadErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": loadErr.Error()})
+			return
+		}
+		writeVersionConflict(c, current.Version, current)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Article updated successfully"})
+	setETag(c, article.Version)
+	c.JSON(http.StatusOK, gin.H{"message": "Article updated successfully", "version": article.Version})
 }
 
 func DeleteArticle(c *gin.Context) {
@@ -212,57 +273,74 @@ func DeleteArticle(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Article deleted successfully"})
 }
 
-func GetBalance(c *gin.Context) {
-	rows, err := DB.Query(`
-		WITH income AS (
-			SELECT
-				a.id::BIGINT AS article_id,
-				SUM(COALESCE(a.no, 0))::INT AS no,
-				MIN(a.code) AS code,
-				MIN(a.description) AS description,
-				SUM(COALESCE(a.kg, 0)) AS income_kg
-			FROM articles a
-			GROUP BY a.id
-		),
-		sent AS (
-			SELECT
-				a.id::BIGINT AS article_id,
-				SUM(
-					COALESCE(NULLIF(REPLACE(aip.weight, ',', '.'), '')::DOUBLE PRECISION, 0)
-				) AS sent_kg
-			FROM article_in_product aip
-			INNER JOIN articles a ON a.service_id = aip.article::BIGINT
-			INNER JOIN products p ON p.id = aip.product_id
-			WHERE p.status = 3
-			GROUP BY a.id
-		),
-		reserved AS (
-			SELECT
-				a.id::BIGINT AS article_id,
-				SUM(
-					COALESCE(NULLIF(REPLACE(aip.weight, ',', '.'), '')::DOUBLE PRECISION, 0)
-				) AS reserved_kg
-			FROM article_in_product aip
-			INNER JOIN articles a ON a.service_id = aip.article::BIGINT
-			INNER JOIN products p ON p.id = aip.product_id
-			WHERE p.status = 2
-			GROUP BY a.id
-		)
+// balanceQuery — остатки по артикулам: приход, отгружено (проданные мешки) и в брони.
+const balanceQuery = `
+	WITH income AS (
 		SELECT
-			i.article_id::INT AS id,
-			i.no,
-			i.code,
-			i.description,
-			ROUND(COALESCE(i.income_kg, 0)::NUMERIC, 2)::DOUBLE PRECISION AS income_kg,
-			ROUND(COALESCE(sent.sent_kg, 0)::NUMERIC, 2)::DOUBLE PRECISION AS sent_kg,
-			ROUND((COALESCE(i.income_kg, 0) - COALESCE(sent.sent_kg, 0))::NUMERIC, 2)::DOUBLE PRECISION AS balance_kg,
-			ROUND(COALESCE(reserved.reserved_kg, 0)::NUMERIC, 2)::DOUBLE PRECISION AS reserved_kg,
-			ROUND((COALESCE(i.income_kg, 0) - COALESCE(sent.sent_kg, 0) - COALESCE(reserved.reserved_kg, 0))::NUMERIC, 2)::DOUBLE PRECISION AS free_kg
-		FROM income i
-		LEFT JOIN sent ON sent.article_id = i.article_id
-		LEFT JOIN reserved ON reserved.article_id = i.article_id
-		ORDER BY i.article_id
-	`)
+			a.id::BIGINT AS article_id,
+			SUM(COALESCE(a.no, 0))::INT AS no,
+			MIN(a.code) AS code,
+			MIN(a.description) AS description,
+			SUM(COALESCE(a.kg, 0)) AS income_kg
+		FROM articles a
+		GROUP BY a.id
+	),
+	sent AS (
+		SELECT
+			a.id::BIGINT AS article_id,
+			SUM(
+				COALESCE(NULLIF(REPLACE(aip.weight, ',', '.'), '')::DOUBLE PRECISION, 0)
+			) AS sent_kg
+		FROM article_in_product aip
+		INNER JOIN articles a ON a.service_id = aip.article::BIGINT
+		INNER JOIN products p ON p.id = aip.product_id
+		WHERE p.status = 3
+		GROUP BY a.id
+	),
+	reserved AS (
+		SELECT
+			a.id::BIGINT AS article_id,
+			SUM(
+				COALESCE(NULLIF(REPLACE(aip.weight, ',', '.'), '')::DOUBLE PRECISION, 0)
+			) AS reserved_kg
+		FROM article_in_product aip
+		INNER JOIN articles a ON a.service_id = aip.article::BIGINT
+		INNER JOIN products p ON p.id = aip.product_id
+		WHERE p.status = 2
+		GROUP BY a.id
+	)
+	SELECT
+		i.article_id::INT AS id,
+		i.no,
+		i.code,
+		i.description,
+		ROUND(COALESCE(i.income_kg, 0)::NUMERIC, 2)::DOUBLE PRECISION AS income_kg,
+		ROUND(COALESCE(sent.sent_kg, 0)::NUMERIC, 2)::DOUBLE PRECISION AS sent_kg,
+		ROUND((COALESCE(i.income_kg, 0) - COALESCE(sent.sent_kg, 0))::NUMERIC, 2)::DOUBLE PRECISION AS balance_kg,
+		ROUND(COALESCE(reserved.reserved_kg, 0)::NUMERIC, 2)::DOUBLE PRECISION AS reserved_kg,
+		ROUND((COALESCE(i.income_kg, 0) - COALESCE(sent.sent_kg, 0) - COALESCE(reserved.reserved_kg, 0))::NUMERIC, 2)::DOUBLE PRECISION AS free_kg
+	FROM income i
+	LEFT JOIN sent ON sent.article_id = i.article_id
+	LEFT JOIN reserved ON reserved.article_id = i.article_id
+	ORDER BY i.article_id
+`
+
+func scanBalanceRow(row interface{ Scan(...any) error }, balance *models.BalanceRow) error {
+	return row.Scan(
+		&balance.ID,
+		&balance.No,
+		&balance.Code,
+		&balance.Description,
+		&balance.IncomeKG,
+		&balance.SentKG,
+		&balance.BalanceKG,
+		&balance.ReservedKG,
+		&balance.FreeKG,
+	)
+}
+
+func GetBalance(c *gin.Context) {
+	rows, err := DB.Query(balanceQuery)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -272,17 +350,7 @@ func GetBalance(c *gin.Context) {
 	var balance []models.BalanceRow
 	for rows.Next() {
 		var row models.BalanceRow
-		if err := rows.Scan(
-			&row.ID,
-			&row.No,
-			&row.Code,
-			&row.Description,
-			&row.IncomeKG,
-			&row.SentKG,
-			&row.BalanceKG,
-			&row.ReservedKG,
-			&row.FreeKG,
-		); err != nil {
+		if err := scanBalanceRow(rows, &row); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
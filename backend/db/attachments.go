@@ -0,0 +1,373 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/Talonmortem/SHM/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Вложения: скриншоты переводов, сканы и фото к оплатам, заказам, отправкам и товарам.
+// Файлы лежат в storage, в таблице attachments — их описание. Маршруты вложены в маршрут
+// записи (/payments/:id/attachments …), поэтому доступ ролей задаётся в role_permissions
+// отдельно для каждого вида записей.
+
+const (
+	AttachmentPayment  = "payment"
+	AttachmentOrder    = "order"
+	AttachmentShipment = "shipment"
+	AttachmentProduct  = "product"
+
+	defaultAttachmentMaxSize = 10 << 20
+)
+
+// attachmentTables — таблица записей каждого вида; имя подставляется в запрос только отсюда.
+var attachmentTables = map[string]string{
+	AttachmentPayment:  "payments_monitoring",
+	AttachmentOrder:    "orders",
+	AttachmentShipment: "shipments",
+	AttachmentProduct:  "products",
+}
+
+// attachmentTypes — допустимые типы файлов. Тип определяется по содержимому, а не по имени.
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/heic":      true,
+	"application/pdf": true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":       true,
+}
+
+// Office-документы — это zip; различаем их по расширению.
+var attachmentZipTypes = map[string]string{
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+var (
+	attachmentStore   storage.Storage = storage.NewMemory()
+	attachmentMaxSize int64           = defaultAttachmentMaxSize
+)
+
+const attachmentColumns = `id, entity_type, entity_id, file_name, content_type, size, uploaded_by,
+	to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')`
+
+// SetAttachmentStorage задаёт хранилище файлов и предельный размер файла в байтах (0 — по умолчанию).
+func SetAttachmentStorage(store storage.Storage, maxSize int64) {
+	attachmentStore = store
+	if maxSize <= 0 {
+		maxSize = defaultAttachmentMaxSize
+	}
+	attachmentMaxSize = maxSize
+}
+
+func CreateTablesAttachments() {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS attachments (
+			id BIGSERIAL PRIMARY KEY,
+			entity_type TEXT NOT NULL CHECK (entity_type IN ('payment', 'order', 'shipment', 'product')),
+			entity_id BIGINT NOT NULL,
+			file_name TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			storage_key TEXT NOT NULL UNIQUE,
+			uploaded_by TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_attachments_entity ON attachments(entity_type, entity_id);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create attachments table:", err)
+	}
+	log.Println("Attachments table is ready")
+}
+
+func scanAttachment(row interface{ Scan(...any) error }, a *models.Attachment) error {
+	return row.Scan(&a.ID, &a.EntityType, &a.EntityID, &a.FileName, &a.ContentType, &a.Size, &a.UploadedBy, &a.CreatedAt)
+}
+
+// attachmentEntityParam читает :id записи и проверяет, что она есть. Ответ при ошибке уже записан.
+func attachmentEntityParam(c *gin.Context, entity string) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + entity + " ID"})
+		return 0, false
+	}
+	var exists bool
+	if err := DB.QueryRow("SELECT EXISTS(SELECT 1 FROM "+attachmentTables[entity]+" WHERE id = $1)", id).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": strings.ToUpper(entity[:1]) + entity[1:] + " not found"})
+		return 0, false
+	}
+	return id, true
+}
+
+// loadEntityAttachment находит вложение :attachment_id записи :id.
+func loadEntityAttachment(c *gin.Context, entity string) (models.Attachment, string, bool) {
+	var a models.Attachment
+	var key string
+	entityID, err1 := strconv.Atoi(c.Param("id"))
+	attachmentID, err2 := strconv.Atoi(c.Param("attachment_id"))
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment ID"})
+		return a, "", false
+	}
+	row := DB.QueryRow(`
+		SELECT `+attachmentColumns+`, storage_key FROM attachments
+		WHERE id = $1 AND entity_type = $2 AND entity_id = $3
+	`, attachmentID, entity, entityID)
+	err := row.Scan(&a.ID, &a.EntityType, &a.EntityID, &a.FileName, &a.ContentType, &a.Size, &a.UploadedBy, &a.CreatedAt, &key)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return a, "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return a, "", false
+	}
+	return a, key, true
+}
+
+// detectAttachmentType определяет тип файла по содержимому; пустая строка — тип не разрешён.
+func detectAttachmentType(data []byte, fileName string) string {
+	contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if contentType == "application/zip" {
+		contentType = attachmentZipTypes[strings.ToLower(filepath.Ext(fileName))]
+	}
+	// HEIC-фото с iPhone http.DetectContentType не распознаёт.
+	if contentType == "application/octet-stream" && len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		switch string(data[8:12]) {
+		case "heic", "heix", "mif1", "msf1":
+			contentType = "image/heic"
+		}
+	}
+	if !attachmentTypes[contentType] {
+		return ""
+	}
+	return contentType
+}
+
+// readAttachmentUpload читает файл из multipart-поля file и проверяет размер и тип.
+// Ответ при ошибке уже записан.
+func readAttachmentUpload(c *gin.Context) ([]byte, string, string, bool) {
+	// Запас на заголовки multipart; сам файл проверяется ниже.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, attachmentMaxSize+1<<20)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d MB", attachmentMaxSize>>20)})
+			return nil, "", "", false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		return nil, "", "", false
+	}
+	if header.Size > attachmentMaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d MB", attachmentMaxSize>>20)})
+		return nil, "", "", false
+	}
+	if header.Size == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is empty"})
+		return nil, "", "", false
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, "", "", false
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, "", "", false
+	}
+	contentType := detectAttachmentType(data, header.Filename)
+	if contentType == "" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported file type: allowed images (JPEG, PNG, GIF, WebP, HEIC), PDF, DOCX and XLSX"})
+		return nil, "", "", false
+	}
+	fileName := strings.TrimSpace(filepath.Base(strings.ReplaceAll(header.Filename, "\\", "/")))
+	if fileName == "" || fileName == "." || fileName == "/" {
+		fileName = "file"
+	}
+	return data, fileName, contentType, true
+}
+
+func newAttachmentKey(entity string, entityID int) (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%d/%s", entity, entityID, hex.EncodeToString(buf[:])), nil
+}
+
+// ListAttachments — GET /<записи>/:id/attachments.
+func ListAttachments(entity string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entityID, ok := attachmentEntityParam(c, entity)
+		if !ok {
+			return
+		}
+		rows, err := DB.Query(`
+			SELECT `+attachmentColumns+` FROM attachments
+			WHERE entity_type = $1 AND entity_id = $2
+			ORDER BY id
+		`, entity, entityID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer rows.Close()
+
+		attachments := make([]models.Attachment, 0)
+		for rows.Next() {
+			var a models.Attachment
+			if err := scanAttachment(rows, &a); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			attachments = append(attachments, a)
+		}
+		if err := rows.Err(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, attachments)
+	}
+}
+
+// UploadAttachment — POST /<записи>/:id/attachments, multipart: file.
+func UploadAttachment(entity string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entityID, ok := attachmentEntityParam(c, entity)
+		if !ok {
+			return
+		}
+		data, fileName, contentType, ok := readAttachmentUpload(c)
+		if !ok {
+			return
+		}
+
+		key, err := newAttachmentKey(entity, entityID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := attachmentStore.Put(c.Request.Context(), key, data, contentType); err != nil {
+			log.Printf("Failed to store attachment %s: %v", key, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store file"})
+			return
+		}
+
+		var a models.Attachment
+		err = scanAttachment(DB.QueryRow(`
+			INSERT INTO attachments (entity_type, entity_id, file_name, content_type, size, storage_key, uploaded_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING `+attachmentColumns,
+			entity, entityID, fileName, contentType, len(data), key, c.GetString("username"),
+		), &a)
+		if err != nil {
+			// Файл без записи никто не увидит — убираем его.
+			if delErr := attachmentStore.Delete(context.Background(), key); delErr != nil {
+				log.Printf("Failed to remove orphan attachment %s: %v", key, delErr)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save attachment: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, a)
+	}
+}
+
+// DownloadAttachment — GET /<записи>/:id/attachments/:attachment_id. Картинки и PDF открываются
+// в браузере, остальное скачивается.
+func DownloadAttachment(entity string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a, key, ok := loadEntityAttachment(c, entity)
+		if !ok {
+			return
+		}
+		file, err := attachmentStore.Open(c.Request.Context(), key)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment file is missing"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to open attachment %s: %v", key, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+		defer file.Close()
+
+		disposition := "attachment"
+		if strings.HasPrefix(a.ContentType, "image/") || a.ContentType == "application/pdf" {
+			disposition = "inline"
+		}
+		c.DataFromReader(http.StatusOK, a.Size, a.ContentType, file, map[string]string{
+			"Content-Disposition":    mime.FormatMediaType(disposition, map[string]string{"filename": a.FileName}),
+			"X-Content-Type-Options": "nosniff",
+		})
+	}
+}
+
+// DeleteAttachment — DELETE /<записи>/:id/attachments/:attachment_id.
+func DeleteAttachment(entity string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a, key, ok := loadEntityAttachment(c, entity)
+		if !ok {
+			return
+		}
+		if _, err := DB.Exec("DELETE FROM attachments WHERE id = $1", a.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		removeAttachmentFiles([]string{key})
+		c.JSON(http.StatusOK, gin.H{"message": "Attachment deleted"})
+	}
+}
+
+// purgeEntityAttachments удаляет вложения уже удалённой записи; вызывается после коммита.
+// Ошибки только логируются: сама запись удалена, а оставшийся файл ничего не ломает.
+func purgeEntityAttachments(entity string, entityID int) {
+	rows, err := DB.Query("DELETE FROM attachments WHERE entity_type = $1 AND entity_id = $2 RETURNING storage_key", entity, entityID)
+	if err != nil {
+		log.Printf("Failed to delete attachments of %s %d: %v", entity, entityID, err)
+		return
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	rows.Close()
+	removeAttachmentFiles(keys)
+}
+
+func removeAttachmentFiles(keys []string) {
+	for _, key := range keys {
+		if err := attachmentStore.Delete(context.Background(), key); err != nil {
+			log.Printf("Failed to remove attachment file %s: %v", key, err)
+		}
+	}
+}
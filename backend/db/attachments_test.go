@@ -0,0 +1,155 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Talonmortem/SHM/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	pngHeader  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	jpegHeader = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+	zipHeader  = []byte("PK\x03\x04\x14\x00\x06\x00")
+)
+
+func TestDetectAttachmentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		fileName string
+		want     string
+	}{
+		{"png", pngHeader, "screen.png", "image/png"},
+		{"jpeg named as pdf", jpegHeader, "check.pdf", "image/jpeg"},
+		{"pdf", []byte("%PDF-1.7\n"), "scan", "application/pdf"},
+		{"gif", []byte("GIF89a"), "a.gif", "image/gif"},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "a.webp", "image/webp"},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), "IMG_0001.HEIC", "image/heic"},
+		{"heic mif1", []byte("\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00"), "photo", "image/heic"},
+		{"docx", zipHeader, "Договор.DOCX", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"xlsx", zipHeader, "act.xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{"plain zip", zipHeader, "archive.zip", ""},
+		{"zip renamed to png", zipHeader, "screen.png", ""},
+		{"html named as png", []byte("<html><script>alert(1)</script>"), "screen.png", ""},
+		{"text", []byte("hello"), "note.txt", ""},
+		{"mp4 is not heic", []byte("\x00\x00\x00\x18ftypisom\x00\x00\x00\x00"), "video.heic", ""},
+		{"binary", []byte{0x00, 0x01, 0x02}, "data.bin", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectAttachmentType(tt.data, tt.fileName); got != tt.want {
+				t.Errorf("detectAttachmentType = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// uploadContext собирает запрос multipart с файлом в поле file.
+func uploadContext(t *testing.T, field, fileName string, data []byte) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(field, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/payments/1/attachments", &body)
+	c.Request.Header.Set("Content-Type", form.FormDataContentType())
+	return c, w
+}
+
+func TestReadAttachmentUpload(t *testing.T) {
+	store := storage.NewMemory()
+	SetAttachmentStorage(store, 1<<20)
+	t.Cleanup(func() { SetAttachmentStorage(storage.NewMemory(), 0) })
+
+	padded := func(header []byte, size int) []byte {
+		return append(append([]byte{}, header...), bytes.Repeat([]byte{0}, size-len(header))...)
+	}
+	tests := []struct {
+		name       string
+		field      string
+		fileName   string
+		data       []byte
+		wantStatus int
+		wantName   string
+		wantType   string
+	}{
+		{"png", "file", "screen.png", pngHeader, http.StatusOK, "screen.png", "image/png"},
+		{"exactly the limit", "file", "scan.png", padded(pngHeader, 1<<20), http.StatusOK, "scan.png", "image/png"},
+		{"windows path is cut to the name", "file", `C:\Users\anna\чек.pdf`, []byte("%PDF-1.4\n"), http.StatusOK, "чек.pdf", "application/pdf"},
+		{"over the limit within the multipart allowance", "file", "big.png", padded(pngHeader, 1<<20+1), http.StatusRequestEntityTooLarge, "", ""},
+		{"body over the MaxBytesReader limit", "file", "huge.png", padded(pngHeader, 3<<20), http.StatusRequestEntityTooLarge, "", ""},
+		{"empty file", "file", "empty.png", nil, http.StatusBadRequest, "", ""},
+		{"no file field", "photo", "screen.png", pngHeader, http.StatusBadRequest, "", ""},
+		{"unsupported type", "file", "page.html", []byte("<html></html>"), http.StatusUnsupportedMediaType, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := uploadContext(t, tt.field, tt.fileName, tt.data)
+			data, fileName, contentType, ok := readAttachmentUpload(c)
+			if tt.wantStatus != http.StatusOK {
+				if ok || w.Code != tt.wantStatus {
+					t.Fatalf("ok = %v, status = %d, want %d: %s", ok, w.Code, tt.wantStatus, w.Body.String())
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("rejected with %d: %s", w.Code, w.Body.String())
+			}
+			if fileName != tt.wantName || contentType != tt.wantType || !bytes.Equal(data, tt.data) {
+				t.Errorf("got %q %q (%d bytes), want %q %q (%d bytes)", fileName, contentType, len(data), tt.wantName, tt.wantType, len(tt.data))
+			}
+
+			// Принятый файл кладётся в хранилище под новым ключом и читается обратно.
+			key, err := newAttachmentKey(AttachmentPayment, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := attachmentStore.Put(context.Background(), key, data, contentType); err != nil {
+				t.Fatalf("Put(%q): %v", key, err)
+			}
+			f, err := store.Open(context.Background(), key)
+			if err != nil {
+				t.Fatalf("Open(%q): %v", key, err)
+			}
+			stored, _ := io.ReadAll(f)
+			f.Close()
+			if !bytes.Equal(stored, tt.data) {
+				t.Errorf("stored %d bytes, want %d", len(stored), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestNewAttachmentKey(t *testing.T) {
+	first, err := newAttachmentKey(AttachmentShipment, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := newAttachmentKey(AttachmentShipment, 15)
+	if first == second {
+		t.Error("keys must be unique")
+	}
+	if !strings.HasPrefix(first, "shipment/15/") || len(first) != len("shipment/15/")+32 {
+		t.Errorf("key = %q, want shipment/15/<32 hex>", first)
+	}
+	// Ключ должен проходить проверку хранилища.
+	if err := storage.NewMemory().Put(context.Background(), first, []byte("x"), ""); err != nil {
+		t.Errorf("key %q rejected by storage: %v", first, err)
+	}
+}
@@ -0,0 +1,640 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Talonmortem/SHM/internal/bankstatement"
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Импорт банковских выписок. Загруженные поступления хранятся в bank_statement_lines и ждут
+// подтверждения: пользователь выбирает заказ (или клиента — тогда это предоплата), и строка
+// превращается в оплату. Повторная загрузка той же выписки не создаёт новых строк.
+
+const (
+	bankLineNew     = "new"
+	bankLineMatched = "matched"
+	bankLineIgnored = "ignored"
+
+	maxStatementSize = 10 << 20
+	maxBankMatches   = 3
+	minBankMatch     = 20
+)
+
+type bankConfirmItem struct {
+	LineID   int  `json:"line_id"`
+	OrderID  int  `json:"order_id"`
+	ClientID int  `json:"client_id"` // без order_id — оплата на счёт клиента
+	Ignore   bool `json:"ignore"`
+}
+
+type bankConfirmRequest struct {
+	Items []bankConfirmItem `json:"items"`
+}
+
+func CreateTablesBankImport() {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS bank_statements (
+			id BIGSERIAL PRIMARY KEY,
+			method TEXT NOT NULL REFERENCES payment_methods(method) ON UPDATE CASCADE,
+			file_name TEXT NOT NULL DEFAULT '',
+			format TEXT NOT NULL,
+			imported_by TEXT NOT NULL DEFAULT '',
+			imported_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS bank_statement_lines (
+			id BIGSERIAL PRIMARY KEY,
+			statement_id BIGINT NOT NULL REFERENCES bank_statements(id) ON DELETE CASCADE,
+			fingerprint TEXT NOT NULL UNIQUE,
+			doc_number TEXT NOT NULL DEFAULT '',
+			date TIMESTAMPTZ NOT NULL,
+			amount DOUBLE PRECISION NOT NULL,
+			payer_name TEXT NOT NULL DEFAULT '',
+			payer_inn TEXT NOT NULL DEFAULT '',
+			payer_account TEXT NOT NULL DEFAULT '',
+			purpose TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'new',
+			payment_id BIGINT REFERENCES payments_monitoring(id) ON DELETE SET NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_statement_id ON bank_statement_lines(statement_id);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create bank import tables:", err)
+	}
+	log.Println("Bank import tables are ready")
+}
+
+// bankLineFingerprint определяет поступление независимо от файла, в котором оно пришло.
+// В CSV без номера документа два одинаковых платежа за день неразличимы, поэтому occurrence —
+// порядковый номер такой же строки в файле: первая (0) даёт прежний отпечаток, следующие — свои.
+// При повторной загрузке той же выписки номера совпадают, и строки не дублируются.
+func bankLineFingerprint(method string, t bankstatement.Transaction, occurrence int) string {
+	fields := []string{
+		method,
+		t.Date.Format("2006-01-02"),
+		strconv.FormatFloat(t.Amount, 'f', 2, 64),
+		t.Number,
+		t.PayerINN,
+		t.PayerAccount,
+		t.PayerName,
+		t.Purpose,
+	}
+	if occurrence > 0 {
+		fields = append(fields, strconv.Itoa(occurrence))
+	}
+	hash := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(hash[:])
+}
+
+// ImportBankStatement — POST /bank_statements/import, multipart: file, method, format (1c|csv, по умолчанию определяется).
+func ImportBankStatement(c *gin.Context) {
+	method := strings.TrimSpace(c.PostForm("method"))
+	if usable, err := paymentMethodUsable(DB, method); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !usable {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or disabled payment method: " + method})
+		return
+	}
+	format, err := bankstatement.ParseFormat(c.PostForm("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Statement file is required"})
+		return
+	}
+	if header.Size > maxStatementSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Statement file is too large"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transactions, format, err := bankstatement.Parse(data, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse statement: " + err.Error()})
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var statementID int
+	if err := tx.QueryRow(
+		"INSERT INTO bank_statements (method, file_name, format, imported_by) VALUES ($1, $2, $3, $4) RETURNING id",
+		method, header.Filename, format, c.GetString("username"),
+	).Scan(&statementID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save statement: " + err.Error()})
+		return
+	}
+
+	imported, skipped := 0, 0
+	occurrences := make(map[string]int)
+	for _, t := range transactions {
+		base := bankLineFingerprint(method, t, 0)
+		occurrence := occurrences[base]
+		occurrences[base]++
+		result, err := tx.Exec(`
+			INSERT INTO bank_statement_lines (statement_id, fingerprint, doc_number, date, amount, payer_name, payer_inn, payer_account, purpose)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (fingerprint) DO NOTHING
+		`, statementID, bankLineFingerprint(method, t, occurrence), t.Number, t.Date.Format(paymentDateTimeLayout), roundMoney(t.Amount),
+			t.PayerName, t.PayerINN, t.PayerAccount, t.Purpose)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save statement line: " + err.Error()})
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			skipped++
+		} else {
+			imported++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+
+	statement, err := loadBankStatement(statementID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	statement.Imported, statement.Skipped = imported, skipped
+	c.JSON(http.StatusCreated, statement)
+}
+
+// GetBankStatements — список загрузок без строк.
+func GetBankStatements(c *gin.Context) {
+	rows, err := DB.Query(`
+		SELECT id, method, file_name, format, imported_by, imported_at FROM bank_statements ORDER BY id DESC
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	statements := make([]models.BankStatement, 0)
+	for rows.Next() {
+		s, err := scanBankStatement(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		statements = append(statements, s)
+	}
+	c.JSON(http.StatusOK, statements)
+}
+
+// GetBankStatement отдаёт строки выписки; для неподтверждённых — предложенные заказы и возможные дубли.
+func GetBankStatement(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid statement ID"})
+		return
+	}
+	statement, err := loadBankStatement(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Statement not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, statement)
+}
+
+func scanBankStatement(row interface{ Scan(...any) error }) (models.BankStatement, error) {
+	var s models.BankStatement
+	var importedAt sql.NullTime
+	if err := row.Scan(&s.ID, &s.Method, &s.FileName, &s.Format, &s.ImportedBy, &importedAt); err != nil {
+		return s, err
+	}
+	if importedAt.Valid {
+		s.ImportedAt = importedAt.Time.Format(paymentDateTimeLayout)
+	}
+	s.Lines = make([]models.BankStatementLine, 0)
+	return s, nil
+}
+
+func loadBankStatement(id int) (models.BankStatement, error) {
+	statement, err := scanBankStatement(DB.QueryRow(
+		"SELECT id, method, file_name, format, imported_by, imported_at FROM bank_statements WHERE id = $1", id,
+	))
+	if err != nil {
+		return statement, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, statement_id, doc_number, to_char(date, 'YYYY-MM-DD HH24:MI:SS'), amount, payer_name, payer_inn, payer_account, purpose, status, payment_id
+		FROM bank_statement_lines
+		WHERE statement_id = $1
+		ORDER BY date, id
+	`, id)
+	if err != nil {
+		return statement, err
+	}
+	for rows.Next() {
+		var l models.BankStatementLine
+		var paymentID sql.NullInt64
+		if err := rows.Scan(&l.ID, &l.StatementID, &l.DocNumber, &l.Date, &l.Amount, &l.PayerName, &l.PayerINN,
+			&l.PayerAccount, &l.Purpose, &l.Status, &paymentID); err != nil {
+			rows.Close()
+			return statement, err
+		}
+		if paymentID.Valid {
+			pid := int(paymentID.Int64)
+			l.PaymentID = &pid
+		}
+		l.Matches = make([]models.BankMatch, 0)
+		l.PossibleDuplicates = make([]int, 0)
+		statement.Lines = append(statement.Lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return statement, err
+	}
+
+	candidates, err := loadBankMatchCandidates()
+	if err != nil {
+		return statement, err
+	}
+	for i := range statement.Lines {
+		line := &statement.Lines[i]
+		if line.Status != bankLineNew {
+			continue
+		}
+		line.Matches = matchBankLine(*line, candidates)
+		if line.PossibleDuplicates, err = findPossibleDuplicates(statement.Method, *line); err != nil {
+			return statement, err
+		}
+	}
+	return statement, nil
+}
+
+// findPossibleDuplicates ищет оплаты того же дня, способа и суммы, которые не пришли из выписки:
+// скорее всего, это тот же платёж, введённый вручную.
+func findPossibleDuplicates(method string, line models.BankStatementLine) ([]int, error) {
+	rows, err := DB.Query(`
+		SELECT pm.id FROM payments_monitoring pm
+		JOIN bank_statement_lines line ON line.id = $3
+		WHERE pm.method = $1 AND pm.type = 'payment' AND ABS(COALESCE(pm.original_amount, pm.amount) - $2) < 0.005 AND pm.date::date = line.date::date
+			AND NOT EXISTS (SELECT 1 FROM bank_statement_lines l WHERE l.payment_id = pm.id)
+		ORDER BY pm.id
+	`, method, line.Amount, line.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+type bankMatchCandidate struct {
+	models.BankMatch
+	names  []string
+	phones []string
+}
+
+// loadBankMatchCandidates — открытые заказы с долгом и данные их клиентов.
+func loadBankMatchCandidates() ([]bankMatchCandidate, error) {
+	rows, err := DB.Query(`
+		SELECT o.id, COALESCE(o.name, ''), COALESCE(o.debt, 0), COALESCE(o.client_id, 0),
+			COALESCE(o.full_name, ''), COALESCE(o.phone, ''), COALESCE(cl.full_name, ''), COALESCE(cl.phone, '')
+		FROM orders o
+		LEFT JOIN clients cl ON cl.id = o.client_id
+		WHERE o.status NOT IN ($1, $2) AND o.debt > 0.005
+		ORDER BY o.id
+	`, orderStatusCancelled, orderStatusReturned)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := make([]bankMatchCandidate, 0)
+	for rows.Next() {
+		var m bankMatchCandidate
+		var orderName, orderPhone, clientName, clientPhone string
+		if err := rows.Scan(&m.OrderID, &m.OrderName, &m.Debt, &m.ClientID, &orderName, &orderPhone, &clientName, &clientPhone); err != nil {
+			return nil, err
+		}
+		m.ClientName = clientName
+		if m.ClientName == "" {
+			m.ClientName = orderName
+		}
+		for _, name := range []string{orderName, clientName} {
+			if name = normalizeClientName(name); name != "" {
+				m.names = append(m.names, name)
+			}
+		}
+		for _, phone := range []string{orderPhone, clientPhone} {
+			if phone = normalizePhone(phone); len(phone) == 10 {
+				m.phones = append(m.phones, phone)
+			}
+		}
+		candidates = append(candidates, m)
+	}
+	return candidates, rows.Err()
+}
+
+var (
+	bankPhonePattern = regexp.MustCompile(`\+?\d[\d\s()-]{8,}\d`)
+	bankOrderPattern = regexp.MustCompile(`(?i)(?:заказ[а-я]*|№|#)\s*№?\s*(\d+)`)
+)
+
+// nameTokensMatched считает слова ФИО клиента, найденные у плательщика. Банки часто пишут
+// «Иван Иванович И.», поэтому инициал совпадает с первой буквой слова.
+func nameTokensMatched(name string, payer []string) int {
+	matched := 0
+	for _, token := range strings.Fields(name) {
+		for _, p := range payer {
+			initial := strings.TrimSuffix(p, ".")
+			if p == token || (p != initial && strings.HasPrefix(token, initial)) {
+				matched++
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// matchBankLine оценивает заказы: сумма равна долгу — 50, телефон — 40, номер заказа в назначении — 40,
+// ФИО плательщика — до 30. Возвращаются лучшие предложения.
+func matchBankLine(line models.BankStatementLine, candidates []bankMatchCandidate) []models.BankMatch {
+	payer := strings.Fields(normalizeClientName(line.PayerName))
+	text := line.PayerName + " " + line.Purpose
+
+	phones := make(map[string]bool)
+	for _, raw := range bankPhonePattern.FindAllString(text, -1) {
+		if phone := normalizePhone(raw); len(phone) == 10 {
+			phones[phone] = true
+		}
+	}
+	orderRefs := make(map[string]bool)
+	for _, m := range bankOrderPattern.FindAllStringSubmatch(line.Purpose, -1) {
+		orderRefs[m[1]] = true
+	}
+	purpose := strings.ToLower(line.Purpose)
+
+	matches := make([]models.BankMatch, 0)
+	for _, candidate := range candidates {
+		m := candidate.BankMatch
+		m.Reasons = make([]string, 0)
+
+		if diff := line.Amount - m.Debt; diff > -0.005 && diff < 0.005 {
+			m.Score += 50
+			m.Reasons = append(m.Reasons, "amount")
+		} else if line.Amount < m.Debt {
+			m.Score += 10
+			m.Reasons = append(m.Reasons, "partial_amount")
+		}
+
+		for _, phone := range candidate.phones {
+			if phones[phone] {
+				m.Score += 40
+				m.Reasons = append(m.Reasons, "phone")
+				break
+			}
+		}
+
+		name := strings.ToLower(strings.TrimSpace(m.OrderName))
+		if orderRefs[strconv.Itoa(m.OrderID)] || orderRefs[name] || (len([]rune(name)) >= 3 && strings.Contains(purpose, name)) {
+			m.Score += 40
+			m.Reasons = append(m.Reasons, "order_number")
+		}
+
+		best := 0
+		for _, n := range candidate.names {
+			best = max(best, nameTokensMatched(n, payer))
+		}
+		switch {
+		case best >= 2:
+			m.Score += 30
+			m.Reasons = append(m.Reasons, "payer_name")
+		case best == 1:
+			m.Score += 10
+			m.Reasons = append(m.Reasons, "payer_name")
+		}
+
+		if m.Score >= minBankMatch {
+			matches = append(matches, m)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > maxBankMatches {
+		matches = matches[:maxBankMatches]
+	}
+	return matches
+}
+
+func bankPaymentComment(line models.BankStatementLine) string {
+	parts := make([]string, 0, 3)
+	if line.DocNumber != "" {
+		parts = append(parts, "п/п №"+line.DocNumber)
+	}
+	if line.PayerName != "" {
+		parts = append(parts, line.PayerName)
+	}
+	if line.Purpose != "" {
+		parts = append(parts, line.Purpose)
+	}
+	comment := "Выписка: " + strings.Join(parts, ", ")
+	if runes := []rune(comment); len(runes) > 500 {
+		comment = string(runes[:500])
+	}
+	return comment
+}
+
+// confirmBankLine создаёт оплату из строки выписки. Строка блокируется и должна быть новой,
+// поэтому одно поступление не превратится в две оплаты. Оплата из выписки уже сверена с банком
+// и подтверждена тем, кто подтвердил выписку: как и подтверждение оплат, это право manager/admin.
+func confirmBankLine(tx *sql.Tx, statementID int, method, username string, item bankConfirmItem) error {
+	var line models.BankStatementLine
+	err := tx.QueryRow(`
+		SELECT id, doc_number, to_char(date, 'YYYY-MM-DD HH24:MI:SS'), amount, payer_name, purpose, status
+		FROM bank_statement_lines
+		WHERE id = $1 AND statement_id = $2
+		FOR UPDATE
+	`, item.LineID, statementID).Scan(&line.ID, &line.DocNumber, &line.Date, &line.Amount, &line.PayerName, &line.Purpose, &line.Status)
+	if err == sql.ErrNoRows {
+		return newBadRequestError(fmt.Sprintf("Line %d not found in statement %d", item.LineID, statementID))
+	}
+	if err != nil {
+		return err
+	}
+	if line.Status != bankLineNew {
+		return newBadRequestError(fmt.Sprintf("Line %d is already %s", item.LineID, line.Status))
+	}
+
+	if item.Ignore {
+		_, err := tx.Exec("UPDATE bank_statement_lines SET status = $1 WHERE id = $2", bankLineIgnored, line.ID)
+		return err
+	}
+	if err := checkPeriodOpen(tx, line.Date); err != nil {
+		return err
+	}
+
+	var orderID, clientID sql.NullInt64
+	switch {
+	case item.OrderID > 0:
+		var status int
+		err := tx.QueryRow("SELECT status FROM orders WHERE id = $1 FOR UPDATE", item.OrderID).Scan(&status)
+		if err == sql.ErrNoRows {
+			return newBadRequestError(fmt.Sprintf("Order %d not found", item.OrderID))
+		}
+		if err != nil {
+			return err
+		}
+		if isClosedOrderStatus(status) {
+			return newBadRequestError(fmt.Sprintf("Order %d is closed", item.OrderID))
+		}
+		orderID = sql.NullInt64{Int64: int64(item.OrderID), Valid: true}
+	case item.ClientID > 0:
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM clients WHERE id = $1)", item.ClientID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return newBadRequestError(fmt.Sprintf("Client %d not found", item.ClientID))
+		}
+		clientID = sql.NullInt64{Int64: int64(item.ClientID), Valid: true}
+	default:
+		return newBadRequestError(fmt.Sprintf("Line %d: order_id, client_id or ignore is required", item.LineID))
+	}
+
+	// Сумма в выписке — в валюте счёта, то есть способа оплаты.
+	payment := models.Payment{Date: line.Date, Method: method, Amount: line.Amount}
+	if err := applyPaymentCurrency(tx, &payment); err != nil {
+		return err
+	}
+	var paymentID int
+	if err := tx.QueryRow(
+		`INSERT INTO payments_monitoring (date, method, amount, comment, order_id, client_id, currency, original_amount, rate)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		line.Date, method, payment.Amount, bankPaymentComment(line), orderID, clientID, payment.Currency, payment.OriginalAmount, payment.Rate,
+	).Scan(&paymentID); err != nil {
+		return err
+	}
+	if err := markPaymentConfirmed(tx, paymentID, username); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE bank_statement_lines SET status = $1, payment_id = $2 WHERE id = $3", bankLineMatched, paymentID, line.ID); err != nil {
+		return err
+	}
+	if orderID.Valid {
+		return recalculateOrderDebt(tx, item.OrderID)
+	}
+	return nil
+}
+
+// ConfirmBankStatement — POST /bank_statements/:id/confirm: подтверждение пачкой, всё или ничего.
+func ConfirmBankStatement(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid statement ID"})
+		return
+	}
+	var req bankConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "items must not be empty"})
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var method string
+	if err := tx.QueryRow("SELECT method FROM bank_statements WHERE id = $1", id).Scan(&method); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Statement not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if usable, err := paymentMethodUsable(tx, method); err != nil || !usable {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or disabled payment method: " + method})
+		return
+	}
+
+	for _, item := range req.Items {
+		if err := confirmBankLine(tx, id, method, c.GetString("username"), item); err != nil {
+			var badReq *badRequestError
+			var closed *periodClosedError
+			switch {
+			case errors.As(err, &badReq):
+				c.JSON(http.StatusBadRequest, gin.H{"error": badReq.message})
+			case errors.As(err, &closed):
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Line %d: %s", item.LineID, closed.Error())})
+			case isUniqueViolation(err):
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Line %d: order already has the same payment", item.LineID)})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+
+	statement, err := loadBankStatement(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, statement)
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Talonmortem/SHM/internal/bankstatement"
+	"github.com/Talonmortem/SHM/internal/models"
+)
+
+func bankCandidate(orderID int, orderName string, debt float64, names, phones []string) bankMatchCandidate {
+	return bankMatchCandidate{
+		BankMatch: models.BankMatch{OrderID: orderID, OrderName: orderName, Debt: debt},
+		names:     names,
+		phones:    phones,
+	}
+}
+
+func TestMatchBankLine(t *testing.T) {
+	ivanov := bankCandidate(42, "Весна", 1500, []string{"иванов иван иванович"}, []string{"9161234567"})
+	petrova := bankCandidate(43, "", 800, []string{"петрова анна"}, nil)
+	bigDebt := bankCandidate(44, "", 5000, nil, nil)
+	candidates := []bankMatchCandidate{ivanov, petrova, bigDebt}
+
+	type match struct {
+		OrderID int
+		Score   int
+		Reasons []string
+	}
+	tests := []struct {
+		name string
+		line models.BankStatementLine
+		want []match
+	}{
+		{
+			name: "exact amount, order number and full name",
+			line: models.BankStatementLine{Amount: 1500, PayerName: "ИВАНОВ ИВАН ИВАНОВИЧ", Purpose: "Оплата заказа №42"},
+			want: []match{
+				{42, 120, []string{"amount", "order_number", "payer_name"}},
+			},
+		},
+		{
+			name: "phone in purpose and initials",
+			line: models.BankStatementLine{Amount: 500, PayerName: "Иван Иванович И.", Purpose: "за мешок, тел. +7 (916) 123-45-67"},
+			want: []match{
+				{42, 80, []string{"partial_amount", "phone", "payer_name"}},
+			},
+		},
+		{
+			name: "order name in purpose",
+			line: models.BankStatementLine{Amount: 10000, Purpose: "предоплата весна"},
+			want: []match{
+				{42, 40, []string{"order_number"}},
+			},
+		},
+		{
+			name: "partial amount alone is below the threshold",
+			line: models.BankStatementLine{Amount: 100, PayerName: "Сидоров"},
+			want: []match{},
+		},
+		{
+			name: "one name token and exact amount",
+			line: models.BankStatementLine{Amount: 800, PayerName: "Анна Смирнова"},
+			want: []match{
+				{43, 60, []string{"amount", "payer_name"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchBankLine(tt.line, candidates)
+			simplified := make([]match, 0, len(got))
+			for _, m := range got {
+				simplified = append(simplified, match{m.OrderID, m.Score, m.Reasons})
+			}
+			if !reflect.DeepEqual(simplified, tt.want) {
+				t.Errorf("matches:\n got %+v\nwant %+v", simplified, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBankLineLimitsAndOrders(t *testing.T) {
+	candidates := make([]bankMatchCandidate, 0)
+	for id := 1; id <= 5; id++ {
+		candidates = append(candidates, bankCandidate(id, "", 1000, []string{"орлов пётр"}, nil))
+	}
+	// Долг заказа 3 равен сумме — он первый; остальные — частичная оплата того же плательщика.
+	candidates[2].Debt = 300
+
+	got := matchBankLine(models.BankStatementLine{Amount: 300, PayerName: "Орлов Пётр"}, candidates)
+	if len(got) != maxBankMatches {
+		t.Fatalf("got %d matches, want %d", len(got), maxBankMatches)
+	}
+	want := []struct{ orderID, score int }{{3, 80}, {1, 40}, {2, 40}}
+	for i, w := range want {
+		if got[i].OrderID != w.orderID || got[i].Score != w.score {
+			t.Errorf("match %d = order %d score %d, want order %d score %d", i, got[i].OrderID, got[i].Score, w.orderID, w.score)
+		}
+	}
+}
+
+func TestBankLineFingerprint(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	line := bankstatement.Transaction{Date: day, Amount: 500, PayerName: "Иванов", Purpose: "Оплата"}
+
+	first := bankLineFingerprint("bank", line, 0)
+	if first != bankLineFingerprint("bank", line, 0) {
+		t.Fatal("fingerprint is not stable")
+	}
+	if first == bankLineFingerprint("bank", line, 1) {
+		t.Error("a repeated identical line must get its own fingerprint")
+	}
+	if first == bankLineFingerprint("cash", line, 0) {
+		t.Error("fingerprint must depend on the payment method")
+	}
+	later := line
+	later.Date = day.Add(time.Hour)
+	if first != bankLineFingerprint("bank", later, 0) {
+		t.Error("time of day must not change the fingerprint")
+	}
+	numbered := line
+	numbered.Number = "15"
+	if first == bankLineFingerprint("bank", numbered, 0) {
+		t.Error("fingerprint must depend on the document number")
+	}
+}
@@ -0,0 +1,238 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	_ "time/tzdata" // часовые пояса внутри бинарника: в контейнере может не быть tzdata
+)
+
+// Время оплат хранится в timestamptz, даты отгрузок — в date. «День» считается в часовом поясе
+// бизнеса (BUSINESS_TIMEZONE, по умолчанию Europe/Moscow): в нём работают сессии базы,
+// в нём понимается ввод без пояса и форматируется вывод.
+
+const (
+	paymentDateTimeLayout   = "2006-01-02 15:04:05"
+	dateLayout              = "2006-01-02"
+	defaultBusinessTimeZone = "Europe/Moscow"
+)
+
+var businessLocation = mustLoadLocation(defaultBusinessTimeZone)
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}
+
+// SetBusinessTimeZone задаёт часовой пояс бизнеса; вызывается до ConnectDB.
+func SetBusinessTimeZone(name string) error {
+	if strings.TrimSpace(name) == "" {
+		name = defaultBusinessTimeZone
+	}
+	location, err := time.LoadLocation(strings.TrimSpace(name))
+	if err != nil {
+		return fmt.Errorf("invalid business time zone %q: %w", name, err)
+	}
+	businessLocation = location
+	return nil
+}
+
+func businessNow() time.Time {
+	return time.Now().In(businessLocation)
+}
+
+func currentPaymentDateTime() string {
+	return businessNow().Format(paymentDateTimeLayout)
+}
+
+var dateTimeInputLayouts = []string{
+	paymentDateTimeLayout,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"02-01-2006 15:04:05",
+	"02-01-2006",
+	"02.01.2006 15:04:05",
+	"02.01.2006",
+}
+
+// parseDateTimeInput разбирает дату или дату со временем от клиента. Строка с поясом (RFC 3339,
+// например из toISOString) переводится в пояс бизнеса, без пояса — считается временем бизнеса.
+func parseDateTimeInput(raw string) (time.Time, error) {
+	value := strings.TrimSpace(raw)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(businessLocation), nil
+	}
+	for _, layout := range dateTimeInputLayouts {
+		if t, err := time.ParseInLocation(layout, value, businessLocation); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported date format: %q", raw)
+}
+
+// normalizePaymentDateInput приводит время оплаты к paymentDateTimeLayout; пустое — «сейчас».
+func normalizePaymentDateInput(raw string) (string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return currentPaymentDateTime(), nil
+	}
+	t, err := parseDateTimeInput(raw)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(paymentDateTimeLayout), nil
+}
+
+// normalizeDateInput приводит календарную дату (отгрузки, заметки) к YYYY-MM-DD;
+// время, если передано, отбрасывается.
+func normalizeDateInput(raw string) (string, error) {
+	t, err := parseDateTimeInput(raw)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(dateLayout), nil
+}
+
+// normalizeOptionalDateInput — то же для необязательной даты: пустая остаётся пустой.
+func normalizeOptionalDateInput(raw string) (string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return "", nil
+	}
+	return normalizeDateInput(raw)
+}
+
+// nullableDate — пустая дата пишется в базу как NULL.
+func nullableDate(date string) any {
+	if date == "" {
+		return nil
+	}
+	return date
+}
+
+// dateColumn — колонка, которая раньше хранилась строкой. required — NOT NULL или ключ:
+// непонятное значение в ней нельзя заменить на NULL.
+type dateColumn struct {
+	table, column, key, sqlType string
+	required                    bool
+}
+
+var legacyDateColumns = []dateColumn{
+	{"payments_monitoring", "date", "id", "TIMESTAMPTZ", false},
+	{"orders", "ship_date", "id", "DATE", false},
+	{"shipments", "ship_date", "id", "DATE", true},
+	{"shipment_notes", "ship_date", "id", "DATE", true},
+	{"courier_daily_payments", "ship_date", "ship_date", "DATE", true},
+	{"bank_statement_lines", "date", "id", "TIMESTAMPTZ", true},
+}
+
+// CreateTablesDateTypes переводит текстовые даты в timestamptz/date. Строки разбираются в поясе
+// сессии, то есть бизнеса. Нераспознанные значения необязательных колонок сохраняются
+// в legacy_date_values и становятся NULL; в обязательных — останавливают запуск до ручной правки.
+func CreateTablesDateTypes() {
+	_, err := DB.Exec(`
+		CREATE OR REPLACE FUNCTION shm_parse_timestamp(value TEXT) RETURNS TIMESTAMP AS $$
+		DECLARE
+			v TEXT := btrim(value);
+		BEGIN
+			IF v ~ '^\d{4}-\d{2}-\d{2}([ T]\d{2}:\d{2}(:\d{2}(\.\d+)?)?)?$' THEN
+				RETURN replace(v, 'T', ' ')::timestamp;
+			END IF;
+			IF v ~ '^\d{2}[.-]\d{2}[.-]\d{4}$' THEN
+				RETURN to_date(translate(v, '.', '-'), 'DD-MM-YYYY')::timestamp;
+			END IF;
+			IF v ~ '^\d{2}[.-]\d{2}[.-]\d{4}\s+\d{2}:\d{2}(:\d{2})?$' THEN
+				RETURN to_timestamp(translate(v, '.', '-'), 'DD-MM-YYYY HH24:MI:SS')::timestamp;
+			END IF;
+			RETURN NULL;
+		EXCEPTION WHEN others THEN
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql STABLE;
+
+		CREATE TABLE IF NOT EXISTS legacy_date_values (
+			table_name TEXT NOT NULL,
+			column_name TEXT NOT NULL,
+			row_key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (table_name, column_name, row_key)
+		);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create date parsing function:", err)
+	}
+
+	for _, col := range legacyDateColumns {
+		if err := migrateDateColumn(col); err != nil {
+			log.Fatalf("Failed to migrate %s.%s to %s: %v", col.table, col.column, col.sqlType, err)
+		}
+	}
+	log.Println("Date columns are ready")
+}
+
+func migrateDateColumn(col dateColumn) error {
+	var dataType string
+	err := DB.QueryRow(
+		"SELECT data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2",
+		col.table, col.column,
+	).Scan(&dataType)
+	if err == sql.ErrNoRows || (err == nil && dataType != "text") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	unparsed := fmt.Sprintf(
+		"btrim(COALESCE(%[1]s, '')) <> '' AND shm_parse_timestamp(%[1]s) IS NULL", col.column,
+	)
+	if col.required {
+		rows, err := DB.Query(fmt.Sprintf("SELECT %s::text, %s FROM %s WHERE %s OR btrim(COALESCE(%s, '')) = '' LIMIT 5",
+			col.key, col.column, col.table, unparsed, col.column))
+		if err != nil {
+			return err
+		}
+		var bad []string
+		for rows.Next() {
+			var key, value sql.NullString
+			if err := rows.Scan(&key, &value); err != nil {
+				rows.Close()
+				return err
+			}
+			bad = append(bad, fmt.Sprintf("%s=%q", key.String, value.String))
+		}
+		rows.Close()
+		if len(bad) > 0 {
+			return fmt.Errorf("values are not dates, fix them and restart: %s", strings.Join(bad, ", "))
+		}
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf(`
+		INSERT INTO legacy_date_values (table_name, column_name, row_key, value)
+		SELECT '%[1]s', '%[2]s', %[3]s::text, %[2]s FROM %[1]s WHERE %[4]s
+		ON CONFLICT DO NOTHING
+	`, col.table, col.column, col.key, unparsed)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(
+		"ALTER TABLE %[1]s ALTER COLUMN %[2]s TYPE %[3]s USING shm_parse_timestamp(%[2]s)::%[3]s",
+		col.table, col.column, col.sqlType,
+	)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Migrated %s.%s to %s", col.table, col.column, col.sqlType)
+	return nil
+}
@@ -0,0 +1,636 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Talonmortem/SHM/internal/export"
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Кассовая книга по способу оплаты: сколько денег у «саши», в кассе «нал» и т.д.
+// Приход — оплаты из payments_monitoring и движения cash_movements (остатки, поступления,
+// переводы сюда), расход — возвраты клиентам, траты, сдача денег и переводы на другой способ.
+
+const (
+	cashKindOpening  = "opening"
+	cashKindIncome   = "income"
+	cashKindExpense  = "expense"
+	cashKindHandover = "handover"
+	cashKindTransfer = "transfer"
+
+	cashDayLayout  = "2006-01-02"
+	maxCashBookDay = 366
+)
+
+var cashMovementKinds = map[string]bool{
+	cashKindOpening:  true,
+	cashKindIncome:   true,
+	cashKindExpense:  true,
+	cashKindHandover: true,
+	cashKindTransfer: true,
+}
+
+type cashCountRequest struct {
+	Method  string  `json:"method"`
+	Date    string  `json:"date"`
+	Counted float64 `json:"counted"`
+	Comment string  `json:"comment"`
+}
+
+func CreateTablesCashBook() {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS cash_movements (
+			id BIGSERIAL PRIMARY KEY,
+			date DATE NOT NULL,
+			method TEXT NOT NULL REFERENCES payment_methods(method) ON UPDATE CASCADE,
+			to_method TEXT REFERENCES payment_methods(method) ON UPDATE CASCADE,
+			kind TEXT NOT NULL,
+			amount DOUBLE PRECISION NOT NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_cash_movements_method_date ON cash_movements(method, date);
+		CREATE INDEX IF NOT EXISTS idx_cash_movements_to_method_date ON cash_movements(to_method, date);
+
+		CREATE TABLE IF NOT EXISTS cash_counts (
+			method TEXT NOT NULL REFERENCES payment_methods(method) ON UPDATE CASCADE,
+			date DATE NOT NULL,
+			counted DOUBLE PRECISION NOT NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			counted_by TEXT NOT NULL DEFAULT '',
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (method, date)
+		);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create cash book tables:", err)
+	}
+	log.Println("Cash book tables are ready")
+}
+
+// cashMovementSigned — сумма движения со знаком для способа $1.
+const cashMovementSigned = `CASE
+	WHEN m.kind = 'transfer' AND m.to_method = $1 THEN m.amount
+	WHEN m.kind IN ('opening', 'income') THEN m.amount
+	ELSE -m.amount
+END`
+
+// cashPaymentAmount — сумма оплаты в валюте способа: касса в юанях считается в юанях.
+const cashPaymentAmount = "COALESCE(original_amount, amount, 0)"
+
+// cashPeriod читает ?date_from=&date_to=; по умолчанию — с начала месяца по сегодня.
+func cashPeriod(c *gin.Context) (time.Time, time.Time, error) {
+	now := businessNow()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if raw := strings.TrimSpace(c.Query("date_from")); raw != "" {
+		t, err := time.Parse(cashDayLayout, raw)
+		if err != nil {
+			return from, to, newBadRequestError("Invalid date_from: expected YYYY-MM-DD")
+		}
+		from = t
+	}
+	if raw := strings.TrimSpace(c.Query("date_to")); raw != "" {
+		t, err := time.Parse(cashDayLayout, raw)
+		if err != nil {
+			return from, to, newBadRequestError("Invalid date_to: expected YYYY-MM-DD")
+		}
+		to = t
+	}
+	if to.Before(from) {
+		return from, to, newBadRequestError("date_to is before date_from")
+	}
+	if to.Sub(from) > maxCashBookDay*24*time.Hour {
+		return from, to, newBadRequestError(fmt.Sprintf("Period is longer than %d days", maxCashBookDay))
+	}
+	return from, to, nil
+}
+
+func writeCashError(c *gin.Context, err error) {
+	var badReq *badRequestError
+	switch {
+	case errors.As(err, &badReq):
+		c.JSON(http.StatusBadRequest, gin.H{"error": badReq.message})
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cash movement not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func loadCashCounts(method string, from, to time.Time) (map[string]models.CashCount, error) {
+	rows, err := DB.Query(`
+		SELECT to_char(date, 'YYYY-MM-DD'), counted, comment, counted_by
+		FROM cash_counts
+		WHERE method = $1 AND date BETWEEN $2 AND $3
+	`, method, from.Format(cashDayLayout), to.Format(cashDayLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]models.CashCount)
+	for rows.Next() {
+		count := models.CashCount{Method: method}
+		if err := rows.Scan(&count.Date, &count.Counted, &count.Comment, &count.CountedBy); err != nil {
+			return nil, err
+		}
+		counts[count.Date] = count
+	}
+	return counts, rows.Err()
+}
+
+// BuildCashBook считает остаток на начало периода, все движения за период и остаток на конец каждого дня.
+func BuildCashBook(method string, from, to time.Time) (models.CashBook, error) {
+	fromDay, toDay := from.Format(cashDayLayout), to.Format(cashDayLayout)
+	book := models.CashBook{
+		Method:   method,
+		DateFrom: fromDay,
+		DateTo:   toDay,
+		Days:     make([]models.CashBookDay, 0),
+		Entries:  make([]models.CashBookEntry, 0),
+	}
+	var err error
+	if book.Currency, err = paymentMethodCurrency(DB, method); err != nil {
+		return book, err
+	}
+
+	if err := DB.QueryRow(`
+		SELECT
+			COALESCE((SELECT SUM(`+cashPaymentAmount+`) FROM payments_monitoring WHERE method = $1 AND date < $2::date AND `+paymentCountsAsPaid+`), 0)
+			+ COALESCE((SELECT SUM(`+cashMovementSigned+`) FROM cash_movements m
+				WHERE (m.method = $1 OR m.to_method = $1) AND m.date < $2::date), 0)
+	`, method, fromDay).Scan(&book.Opening); err != nil {
+		return book, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, to_char(date, 'YYYY-MM-DD'), type, `+cashPaymentAmount+`, order_id, COALESCE(NULLIF(reason, ''), comment, '')
+		FROM payments_monitoring
+		WHERE method = $1 AND date >= $2::date AND date < $3::date + 1 AND `+paymentCountsAsPaid+`
+		ORDER BY date, id
+	`, method, fromDay, toDay)
+	if err != nil {
+		return book, err
+	}
+	for rows.Next() {
+		entry := models.CashBookEntry{Source: "payment"}
+		var orderID sql.NullInt64
+		if err := rows.Scan(&entry.ID, &entry.Date, &entry.Kind, &entry.Amount, &orderID, &entry.Comment); err != nil {
+			rows.Close()
+			return book, err
+		}
+		if orderID.Valid {
+			id := int(orderID.Int64)
+			entry.OrderID = &id
+		}
+		book.Entries = append(book.Entries, entry)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return book, err
+	}
+
+	rows, err = DB.Query(`
+		SELECT m.id, to_char(m.date, 'YYYY-MM-DD'), m.kind, `+cashMovementSigned+`, m.comment, m.method, COALESCE(m.to_method, '')
+		FROM cash_movements m
+		WHERE (m.method = $1 OR m.to_method = $1) AND m.date BETWEEN $2::date AND $3::date
+		ORDER BY m.date, m.id
+	`, method, fromDay, toDay)
+	if err != nil {
+		return book, err
+	}
+	for rows.Next() {
+		entry := models.CashBookEntry{Source: "movement"}
+		var fromMethod, toMethod string
+		if err := rows.Scan(&entry.ID, &entry.Date, &entry.Kind, &entry.Amount, &entry.Comment, &fromMethod, &toMethod); err != nil {
+			rows.Close()
+			return book, err
+		}
+		if entry.Kind == cashKindTransfer {
+			counterpart := "→ " + toMethod
+			if toMethod == method {
+				counterpart = "← " + fromMethod
+			}
+			entry.Comment = strings.TrimSpace(counterpart + " " + entry.Comment)
+		}
+		book.Entries = append(book.Entries, entry)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return book, err
+	}
+	sort.SliceStable(book.Entries, func(i, j int) bool { return book.Entries[i].Date < book.Entries[j].Date })
+
+	counts, err := loadCashCounts(method, from, to)
+	if err != nil {
+		return book, err
+	}
+
+	byDay := make(map[string][]models.CashBookEntry)
+	for _, entry := range book.Entries {
+		byDay[entry.Date] = append(byDay[entry.Date], entry)
+	}
+	balance := book.Opening
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		d := models.CashBookDay{Date: day.Format(cashDayLayout), Opening: roundMoney(balance)}
+		for _, entry := range byDay[d.Date] {
+			switch {
+			case entry.Source == "payment" && entry.Kind == paymentTypeRefund:
+				d.Refunds -= entry.Amount
+			case entry.Source == "payment":
+				d.Payments += entry.Amount
+			case entry.Amount >= 0:
+				d.Inflow += entry.Amount
+			default:
+				d.Outflow -= entry.Amount
+			}
+		}
+		balance += d.Payments - d.Refunds + d.Inflow - d.Outflow
+		d.Payments, d.Refunds = roundMoney(d.Payments), roundMoney(d.Refunds)
+		d.Inflow, d.Outflow = roundMoney(d.Inflow), roundMoney(d.Outflow)
+		d.Closing = roundMoney(balance)
+		if count, ok := counts[d.Date]; ok {
+			counted := count.Counted
+			difference := roundMoney(counted - d.Closing)
+			d.Counted, d.Difference = &counted, &difference
+		}
+		book.Days = append(book.Days, d)
+	}
+	book.Opening = roundMoney(book.Opening)
+	book.Closing = roundMoney(balance)
+	return book, nil
+}
+
+func cashBookTable(book models.CashBook) export.Table {
+	table := export.Table{
+		Name:    "Касса " + book.Method,
+		Columns: []string{"Дата", "Остаток на начало", "Оплаты", "Возвраты", "Прочий приход", "Расход", "Остаток на конец", "Пересчитано", "Расхождение"},
+	}
+	for _, d := range book.Days {
+		var counted, difference any
+		if d.Counted != nil {
+			counted, difference = *d.Counted, *d.Difference
+		}
+		table.Rows = append(table.Rows, []any{d.Date, d.Opening, d.Payments, d.Refunds, d.Inflow, d.Outflow, d.Closing, counted, difference})
+	}
+	return table
+}
+
+// GetCashBook — GET /cash_book?method=&date_from=&date_to=, ?format=csv|xlsx — по дням файлом.
+func GetCashBook(c *gin.Context) {
+	method := strings.TrimSpace(c.Query("method"))
+	if method == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "method is required"})
+		return
+	}
+	from, to, err := cashPeriod(c)
+	if err != nil {
+		writeCashError(c, err)
+		return
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
+	var exists bool
+	if err := DB.QueryRow("SELECT EXISTS(SELECT 1 FROM payment_methods WHERE method = $1)", method).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment method not found"})
+		return
+	}
+
+	book, err := BuildCashBook(method, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if format != "" {
+		writeExport(c, format, "cash-book-"+book.DateFrom+"-"+book.DateTo, cashBookTable(book))
+		return
+	}
+	c.JSON(http.StatusOK, book)
+}
+
+// GetCashReconciliation сравнивает пересчёты с расчётным остатком на конец дня.
+// ?method= — один способ, иначе все, по которым есть пересчёты; ?mismatches_only=true — только расхождения.
+func GetCashReconciliation(c *gin.Context) {
+	from, to, err := cashPeriod(c)
+	if err != nil {
+		writeCashError(c, err)
+		return
+	}
+
+	methods := make([]string, 0)
+	if method := strings.TrimSpace(c.Query("method")); method != "" {
+		methods = append(methods, method)
+	} else {
+		rows, err := DB.Query(
+			"SELECT DISTINCT method FROM cash_counts WHERE date BETWEEN $1 AND $2 ORDER BY method",
+			from.Format(cashDayLayout), to.Format(cashDayLayout),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for rows.Next() {
+			var method string
+			if err := rows.Scan(&method); err != nil {
+				rows.Close()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			methods = append(methods, method)
+		}
+		rows.Close()
+	}
+
+	mismatchesOnly := c.Query("mismatches_only") == "true"
+	result := make([]models.CashCount, 0)
+	for _, method := range methods {
+		book, err := BuildCashBook(method, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		counts, err := loadCashCounts(method, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, d := range book.Days {
+			count, ok := counts[d.Date]
+			if !ok {
+				continue
+			}
+			count.Computed = d.Closing
+			count.Difference = *d.Difference
+			count.Mismatch = math.Abs(count.Difference) >= 0.01
+			if mismatchesOnly && !count.Mismatch {
+				continue
+			}
+			result = append(result, count)
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// SaveCashCount — PUT /cash_book/counts: пересчёт на конец дня, повторный пересчёт заменяет прежний.
+func SaveCashCount(c *gin.Context) {
+	var req cashCountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Method = strings.TrimSpace(req.Method)
+	if _, err := time.Parse(cashDayLayout, req.Date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date: expected YYYY-MM-DD"})
+		return
+	}
+
+	var exists bool
+	if err := DB.QueryRow("SELECT EXISTS(SELECT 1 FROM payment_methods WHERE method = $1)", req.Method).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown payment method: " + req.Method})
+		return
+	}
+
+	_, err := DB.Exec(`
+		INSERT INTO cash_counts (method, date, counted, comment, counted_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (method, date) DO UPDATE
+		SET counted = EXCLUDED.counted, comment = EXCLUDED.comment, counted_by = EXCLUDED.counted_by, updated_at = CURRENT_TIMESTAMP
+	`, req.Method, req.Date, roundMoney(req.Counted), strings.TrimSpace(req.Comment), c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Cash count saved"})
+}
+
+// DeleteCashCount — DELETE /cash_book/counts?method=&date=.
+func DeleteCashCount(c *gin.Context) {
+	result, err := DB.Exec("DELETE FROM cash_counts WHERE method = $1 AND date = $2::date", c.Query("method"), c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cash count not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Cash count deleted"})
+}
+
+// validateCashMovement проверяет движение. Отключённый способ можно оставить у старого движения
+// (previous), но не выбрать заново.
+func validateCashMovement(q queryer, m *models.CashMovement, previous *models.CashMovement) error {
+	m.Method = strings.TrimSpace(m.Method)
+	m.ToMethod = strings.TrimSpace(m.ToMethod)
+	m.Kind = strings.ToLower(strings.TrimSpace(m.Kind))
+	m.Comment = strings.TrimSpace(m.Comment)
+	m.Amount = roundMoney(m.Amount)
+
+	if !cashMovementKinds[m.Kind] {
+		return newBadRequestError("Invalid kind: expected opening, income, expense, handover or transfer")
+	}
+	date, err := time.Parse(cashDayLayout, strings.TrimSpace(m.Date))
+	if err != nil {
+		return newBadRequestError("Invalid date: expected YYYY-MM-DD")
+	}
+	m.Date = date.Format(cashDayLayout)
+
+	if m.Kind == cashKindOpening {
+		if m.Amount == 0 {
+			return newBadRequestError("Opening balance must not be zero")
+		}
+	} else if m.Amount <= 0 {
+		return newBadRequestError("Amount must be positive")
+	}
+
+	if m.Kind == cashKindTransfer {
+		if m.ToMethod == "" || m.ToMethod == m.Method {
+			return newBadRequestError("Transfer requires to_method different from method")
+		}
+	} else {
+		m.ToMethod = ""
+	}
+
+	checks := []string{m.Method}
+	if m.ToMethod != "" {
+		checks = append(checks, m.ToMethod)
+	}
+	for _, method := range checks {
+		if previous != nil && (method == previous.Method || method == previous.ToMethod) {
+			continue
+		}
+		usable, err := paymentMethodUsable(q, method)
+		if err != nil {
+			return err
+		}
+		if !usable {
+			return newBadRequestError("Invalid or disabled payment method: " + method)
+		}
+	}
+	return nil
+}
+
+const cashMovementColumns = "id, to_char(date, 'YYYY-MM-DD'), method, COALESCE(to_method, ''), kind, amount, comment, created_by, created_at"
+
+func scanCashMovement(row interface{ Scan(...any) error }) (models.CashMovement, error) {
+	var m models.CashMovement
+	var createdAt sql.NullTime
+	if err := row.Scan(&m.ID, &m.Date, &m.Method, &m.ToMethod, &m.Kind, &m.Amount, &m.Comment, &m.CreatedBy, &createdAt); err != nil {
+		return m, err
+	}
+	if createdAt.Valid {
+		m.CreatedAt = createdAt.Time.Format(paymentDateTimeLayout)
+	}
+	return m, nil
+}
+
+func loadCashMovement(id int) (models.CashMovement, error) {
+	return scanCashMovement(DB.QueryRow("SELECT "+cashMovementColumns+" FROM cash_movements WHERE id = $1", id))
+}
+
+// GetCashMovements — ?method= (в том числе переводы на него), ?kind=, ?date_from=&date_to=.
+func GetCashMovements(c *gin.Context) {
+	from, to, err := cashPeriod(c)
+	if err != nil {
+		writeCashError(c, err)
+		return
+	}
+	where := []string{"date BETWEEN $1 AND $2"}
+	args := []any{from.Format(cashDayLayout), to.Format(cashDayLayout)}
+	if method := strings.TrimSpace(c.Query("method")); method != "" {
+		args = append(args, method)
+		where = append(where, fmt.Sprintf("(method = $%d OR to_method = $%d)", len(args), len(args)))
+	}
+	if kind := strings.TrimSpace(c.Query("kind")); kind != "" {
+		args = append(args, kind)
+		where = append(where, fmt.Sprintf("kind = $%d", len(args)))
+	}
+
+	rows, err := DB.Query("SELECT "+cashMovementColumns+" FROM cash_movements"+whereClause(strings.Join(where, " AND "))+" ORDER BY date, id", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	movements := make([]models.CashMovement, 0)
+	for rows.Next() {
+		m, err := scanCashMovement(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		movements = append(movements, m)
+	}
+	c.JSON(http.StatusOK, movements)
+}
+
+func CreateCashMovement(c *gin.Context) {
+	var m models.CashMovement
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateCashMovement(DB, &m, nil); err != nil {
+		writeCashError(c, err)
+		return
+	}
+
+	err := DB.QueryRow(`
+		INSERT INTO cash_movements (date, method, to_method, kind, amount, comment, created_by)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7)
+		RETURNING id
+	`, m.Date, m.Method, m.ToMethod, m.Kind, m.Amount, m.Comment, c.GetString("username")).Scan(&m.ID)
+	if err != nil {
+		writeCashError(c, err)
+		return
+	}
+
+	created, err := loadCashMovement(m.ID)
+	if err != nil {
+		writeCashError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func UpdateCashMovement(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cash movement ID"})
+		return
+	}
+	var m models.CashMovement
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	previous, err := loadCashMovement(id)
+	if err != nil {
+		writeCashError(c, err)
+		return
+	}
+	if err := validateCashMovement(DB, &m, &previous); err != nil {
+		writeCashError(c, err)
+		return
+	}
+
+	if _, err := DB.Exec(`
+		UPDATE cash_movements SET date = $1, method = $2, to_method = NULLIF($3, ''), kind = $4, amount = $5, comment = $6
+		WHERE id = $7
+	`, m.Date, m.Method, m.ToMethod, m.Kind, m.Amount, m.Comment, id); err != nil {
+		writeCashError(c, err)
+		return
+	}
+
+	updated, err := loadCashMovement(id)
+	if err != nil {
+		writeCashError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+func DeleteCashMovement(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cash movement ID"})
+		return
+	}
+	result, err := DB.Exec("DELETE FROM cash_movements WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cash movement not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Cash movement deleted"})
+}
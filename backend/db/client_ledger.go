@@ -0,0 +1,532 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Лицевой счёт клиента. Оплата с client_id и без order_id — деньги клиента: предоплата
+// или одна оплата за несколько заказов. Распределения (payment_allocations) зачитывают части
+// такой оплаты в заказы клиента; нераспределённый остаток — кредит клиента.
+// Оплата заказа = прямые оплаты заказа + распределения в него.
+
+type clientPaymentRequest struct {
+	models.Payment
+	Allocations  []allocationRequest `json:"allocations"`
+	AutoAllocate bool                `json:"auto_allocate"` // распределить по самым старым долгам
+}
+
+type allocationRequest struct {
+	OrderID int     `json:"order_id"`
+	Amount  float64 `json:"amount"`
+}
+
+type allocateRequest struct {
+	Allocations  []allocationRequest `json:"allocations"`
+	AutoAllocate bool                `json:"auto_allocate"`
+}
+
+func CreateTablesClientLedger() {
+	_, err := DB.Exec(`
+		ALTER TABLE payments_monitoring ADD COLUMN IF NOT EXISTS client_id BIGINT REFERENCES clients(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_payments_monitoring_client_id ON payments_monitoring(client_id);
+
+		CREATE TABLE IF NOT EXISTS payment_allocations (
+			id BIGSERIAL PRIMARY KEY,
+			payment_id BIGINT NOT NULL REFERENCES payments_monitoring(id) ON DELETE CASCADE,
+			order_id BIGINT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+			amount DOUBLE PRECISION NOT NULL CHECK (amount > 0),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (payment_id, order_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_payment_allocations_order_id ON payment_allocations(order_id);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create client ledger tables:", err)
+	}
+	log.Println("Client ledger tables are ready")
+}
+
+func roundMoney(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// orderPaidAmount — сколько оплачено по заказу: прямые оплаты и распределения клиентских оплат.
+// Отклонённые оплаты не учитываются, их распределения удаляются при отклонении.
+func orderPaidAmount(tx *sql.Tx, orderID int) (float64, error) {
+	var paid float64
+	err := tx.QueryRow(`
+		SELECT
+			COALESCE((SELECT SUM(amount) FROM payments_monitoring WHERE order_id = $1 AND `+paymentCountsAsPaid+`), 0)
+			+ COALESCE((SELECT SUM(amount) FROM payment_allocations WHERE order_id = $1), 0)
+	`, orderID).Scan(&paid)
+	return paid, err
+}
+
+func loadOrderAllocations(q queryer, orderID int) ([]models.PaymentAllocation, error) {
+	return queryAllocations(q, "a.order_id = $1", orderID)
+}
+
+func queryAllocations(q queryer, where string, args ...any) ([]models.PaymentAllocation, error) {
+	rows, err := q.Query(`
+		SELECT a.id, a.payment_id, a.order_id, a.amount, COALESCE(to_char(pm.date, 'YYYY-MM-DD HH24:MI:SS'), ''), COALESCE(pm.method, ''), a.created_at, pm.status
+		FROM payment_allocations a
+		JOIN payments_monitoring pm ON pm.id = a.payment_id
+		WHERE `+where+`
+		ORDER BY a.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allocations := make([]models.PaymentAllocation, 0)
+	for rows.Next() {
+		var a models.PaymentAllocation
+		var createdAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.PaymentID, &a.OrderID, &a.Amount, &a.Date, &a.Method, &createdAt, &a.PaymentStatus); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid {
+			a.CreatedAt = createdAt.Time.Format(paymentDateTimeLayout)
+		}
+		allocations = append(allocations, a)
+	}
+	return allocations, rows.Err()
+}
+
+func totalAllocated(allocations []models.PaymentAllocation) float64 {
+	total := 0.0
+	for _, a := range allocations {
+		total += a.Amount
+	}
+	return total
+}
+
+// paymentOrderIDs — заказы, долг которых зависит от оплаты: её заказ и заказы из распределений.
+func paymentOrderIDs(tx *sql.Tx, paymentID int) ([]int, error) {
+	rows, err := tx.Query(`
+		SELECT order_id FROM payments_monitoring WHERE id = $1 AND order_id IS NOT NULL
+		UNION
+		SELECT order_id FROM payment_allocations WHERE payment_id = $1
+	`, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func recalculateOrdersDebt(tx *sql.Tx, orderIDs []int) error {
+	for _, id := range orderIDs {
+		if err := recalculateOrderDebt(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseOrderAllocations возвращает распределённые в заказ деньги на баланс клиента.
+func releaseOrderAllocations(tx *sql.Tx, orderID int) error {
+	_, err := tx.Exec("DELETE FROM payment_allocations WHERE order_id = $1", orderID)
+	return err
+}
+
+// lockClientPayment блокирует клиентскую оплату и возвращает её нераспределённый остаток.
+// Отклонённую оплату распределять нельзя.
+func lockClientPayment(tx *sql.Tx, clientID, paymentID int) (float64, error) {
+	var amount float64
+	var status string
+	err := tx.QueryRow(`
+		SELECT COALESCE(amount, 0), status FROM payments_monitoring
+		WHERE id = $1 AND client_id = $2 AND order_id IS NULL
+		FOR UPDATE
+	`, paymentID, clientID).Scan(&amount, &status)
+	if err == sql.ErrNoRows {
+		return 0, newBadRequestError(fmt.Sprintf("Payment %d is not an unassigned payment of client %d", paymentID, clientID))
+	}
+	if err != nil {
+		return 0, err
+	}
+	if status == paymentStatusRejected {
+		return 0, newBadRequestError(fmt.Sprintf("Payment %d is rejected", paymentID))
+	}
+
+	var allocated float64
+	if err := tx.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM payment_allocations WHERE payment_id = $1", paymentID).Scan(&allocated); err != nil {
+		return 0, err
+	}
+	return roundMoney(amount - allocated), nil
+}
+
+// autoAllocations раскладывает free по открытым заказам клиента с долгом, начиная с самых старых.
+func autoAllocations(tx *sql.Tx, clientID int, free float64) ([]allocationRequest, error) {
+	rows, err := tx.Query(`
+		SELECT id, debt FROM orders
+		WHERE client_id = $1 AND status NOT IN ($2, $3) AND debt > 0.005
+		ORDER BY created_at, id
+	`, clientID, orderStatusCancelled, orderStatusReturned)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allocations := make([]allocationRequest, 0)
+	for rows.Next() && free > 0.005 {
+		var a allocationRequest
+		var debt float64
+		if err := rows.Scan(&a.OrderID, &debt); err != nil {
+			return nil, err
+		}
+		a.Amount = roundMoney(math.Min(free, debt))
+		free = roundMoney(free - a.Amount)
+		allocations = append(allocations, a)
+	}
+	return allocations, rows.Err()
+}
+
+// allocatePayment зачитывает части клиентской оплаты в заказы того же клиента.
+// Зачесть можно не больше остатка оплаты и не больше долга заказа.
+func allocatePayment(tx *sql.Tx, clientID, paymentID int, requests []allocationRequest, auto bool) error {
+	free, err := lockClientPayment(tx, clientID, paymentID)
+	if err != nil {
+		return err
+	}
+	if auto {
+		if len(requests) > 0 {
+			return newBadRequestError("Use either allocations or auto_allocate, not both")
+		}
+		if requests, err = autoAllocations(tx, clientID, free); err != nil {
+			return err
+		}
+	}
+
+	for _, req := range requests {
+		req.Amount = roundMoney(req.Amount)
+		if req.Amount <= 0 {
+			return newBadRequestError("Allocation amount must be positive")
+		}
+		if req.Amount > free+0.005 {
+			return newBadRequestError(fmt.Sprintf("Payment %d has only %.2f left to allocate", paymentID, free))
+		}
+
+		var orderClientID sql.NullInt64
+		var status int
+		var debt float64
+		err := tx.QueryRow("SELECT client_id, status, COALESCE(debt, 0) FROM orders WHERE id = $1 FOR UPDATE", req.OrderID).Scan(&orderClientID, &status, &debt)
+		if err == sql.ErrNoRows {
+			return newBadRequestError(fmt.Sprintf("Order %d not found", req.OrderID))
+		}
+		if err != nil {
+			return err
+		}
+		if !orderClientID.Valid || int(orderClientID.Int64) != clientID {
+			return newBadRequestError(fmt.Sprintf("Order %d belongs to another client", req.OrderID))
+		}
+		if isClosedOrderStatus(status) {
+			return newBadRequestError(fmt.Sprintf("Order %d is closed", req.OrderID))
+		}
+		if req.Amount > roundMoney(debt)+0.005 {
+			return newBadRequestError(fmt.Sprintf("Order %d owes only %.2f", req.OrderID, debt))
+		}
+
+		if _, err := tx.Exec(`
+			INSERT INTO payment_allocations (payment_id, order_id, amount)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (payment_id, order_id) DO UPDATE SET amount = payment_allocations.amount + EXCLUDED.amount
+		`, paymentID, req.OrderID, req.Amount); err != nil {
+			return err
+		}
+		if err := recalculateOrderDebt(tx, req.OrderID); err != nil {
+			return err
+		}
+		free = roundMoney(free - req.Amount)
+	}
+	return nil
+}
+
+func clientIDParam(c *gin.Context) (int, bool) {
+	clientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return 0, false
+	}
+	var exists bool
+	if err := DB.QueryRow("SELECT EXISTS(SELECT 1 FROM clients WHERE id = $1)", clientID).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return 0, false
+	}
+	return clientID, true
+}
+
+// LoadClientBalance собирает лицевой счёт: оплаты клиента с распределениями и долги по его заказам.
+func LoadClientBalance(clientID int) (models.ClientBalance, error) {
+	balance := models.ClientBalance{
+		ClientID: clientID,
+		Payments: make([]models.ClientPayment, 0),
+		Orders:   make([]models.ClientBalanceOrder, 0),
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, COALESCE(to_char(date, 'YYYY-MM-DD HH24:MI:SS'), ''), COALESCE(method, ''), COALESCE(amount, 0), COALESCE(comment, ''),
+			type, currency, COALESCE(original_amount, amount, 0), rate, `+paymentStatusColumns+`
+		FROM payments_monitoring
+		WHERE client_id = $1 AND order_id IS NULL
+		ORDER BY date, id
+	`, clientID)
+	if err != nil {
+		return balance, err
+	}
+	for rows.Next() {
+		p := models.ClientPayment{ClientID: clientID}
+		dest := []any{&p.ID, &p.Date, &p.Method, &p.Amount, &p.Comment, &p.Type, &p.Currency, &p.OriginalAmount, &p.Rate}
+		if err := rows.Scan(append(dest, scanPaymentStatus(&p.Payment)...)...); err != nil {
+			rows.Close()
+			return balance, err
+		}
+		balance.Payments = append(balance.Payments, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return balance, err
+	}
+
+	for i := range balance.Payments {
+		p := &balance.Payments[i]
+		if p.Allocations, err = queryAllocations(DB, "a.payment_id = $1", p.ID); err != nil {
+			return balance, err
+		}
+		p.Allocated = roundMoney(totalAllocated(p.Allocations))
+		if p.Status == paymentStatusRejected {
+			continue
+		}
+		p.Unallocated = roundMoney(p.Amount - p.Allocated)
+		balance.Credit += p.Unallocated
+	}
+
+	// Переплата закрытого заказа остаётся на счёте клиента, если её не решено вернуть.
+	rows, err = DB.Query(`
+		SELECT id, name, status, COALESCE(debt, 0) FROM orders
+		WHERE client_id = $1 AND (debt > 0.005 OR debt < -0.005)
+			AND COALESCE(payment_resolution, '') <> $2
+		ORDER BY created_at, id
+	`, clientID, paymentResolutionRefund)
+	if err != nil {
+		return balance, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o models.ClientBalanceOrder
+		if err := rows.Scan(&o.OrderID, &o.Name, &o.Status, &o.Debt); err != nil {
+			return balance, err
+		}
+		if o.Debt > 0 {
+			balance.OrdersDebt += o.Debt
+		} else {
+			balance.OrdersOverpaid -= o.Debt
+		}
+		balance.Orders = append(balance.Orders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return balance, err
+	}
+
+	balance.Credit = roundMoney(balance.Credit)
+	balance.OrdersDebt = roundMoney(balance.OrdersDebt)
+	balance.OrdersOverpaid = roundMoney(balance.OrdersOverpaid)
+	balance.Balance = roundMoney(balance.Credit + balance.OrdersOverpaid - balance.OrdersDebt)
+	return balance, nil
+}
+
+// GetClientBalance — GET /clients/:id/balance.
+func GetClientBalance(c *gin.Context) {
+	clientID, ok := clientIDParam(c)
+	if !ok {
+		return
+	}
+	balance, err := LoadClientBalance(clientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, balance)
+}
+
+// CreateClientPayment принимает деньги на счёт клиента и, если передано, сразу распределяет их по заказам.
+func CreateClientPayment(c *gin.Context) {
+	clientID, ok := clientIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req clientPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	payment := req.Payment
+	if err := validateOrderPayment(tx, &payment, ""); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if err := checkPeriodOpen(tx, payment.Date); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	payment.Comment = strings.TrimSpace(payment.Comment)
+	if err := tx.QueryRow(
+		`INSERT INTO payments_monitoring (date, method, amount, comment, client_id, currency, original_amount, rate)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		payment.Date, payment.Method, payment.Amount, payment.Comment, clientID, payment.Currency, payment.OriginalAmount, payment.Rate,
+	).Scan(&payment.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert payment: " + err.Error()})
+		return
+	}
+
+	if len(req.Allocations) > 0 || req.AutoAllocate {
+		if err := allocatePayment(tx, clientID, payment.ID, req.Allocations, req.AutoAllocate); err != nil {
+			writeOrderError(c, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+	respondClientBalance(c, http.StatusCreated, clientID)
+}
+
+// AllocateClientPayment — POST /clients/:id/payments/:payment_id/allocations.
+func AllocateClientPayment(c *gin.Context) {
+	clientID, ok := clientIDParam(c)
+	if !ok {
+		return
+	}
+	paymentID, err := strconv.Atoi(c.Param("payment_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	var req allocateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Allocations) == 0 && !req.AutoAllocate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "allocations or auto_allocate is required"})
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := allocatePayment(tx, clientID, paymentID, req.Allocations, req.AutoAllocate); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+	respondClientBalance(c, http.StatusOK, clientID)
+}
+
+// DeleteClientAllocation отменяет распределение: деньги возвращаются на баланс клиента.
+func DeleteClientAllocation(c *gin.Context) {
+	clientID, ok := clientIDParam(c)
+	if !ok {
+		return
+	}
+	paymentID, err := strconv.Atoi(c.Param("payment_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+	allocationID, err := strconv.Atoi(c.Param("allocation_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid allocation ID"})
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := lockClientPayment(tx, clientID, paymentID); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	var orderID int
+	err = tx.QueryRow("DELETE FROM payment_allocations WHERE id = $1 AND payment_id = $2 RETURNING order_id", allocationID, paymentID).Scan(&orderID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Allocation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkShippedOrderPeriodOpen(tx, orderID); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if err := recalculateOrderDebt(tx, orderID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate order debt: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+	respondClientBalance(c, http.StatusOK, clientID)
+}
+
+func respondClientBalance(c *gin.Context, status, clientID int) {
+	balance, err := LoadClientBalance(clientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(status, balance)
+}
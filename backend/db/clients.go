@@ -1,9 +1,13 @@
 package db
 
 import (
+	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/Talonmortem/SHM/internal/models"
 	"github.com/gin-gonic/gin"
@@ -23,6 +27,8 @@ func CreateTablesClients() {
 
 		CREATE INDEX IF NOT EXISTS idx_clients_city ON clients(city);
 		CREATE INDEX IF NOT EXISTS idx_clients_full_name ON clients(full_name);
+		CREATE INDEX IF NOT EXISTS idx_clients_phone_key
+			ON clients (RIGHT(REGEXP_REPLACE(COALESCE(phone, ''), '\D', '', 'g'), 10));
 	`)
 	if err != nil {
 		log.Fatal("Failed to create clients table:", err)
@@ -30,6 +36,217 @@ func CreateTablesClients() {
 	log.Println("Clients table is ready")
 }
 
+// orderDelivery — поля доставки, которые хранятся в заказе. У заказа, привязанного к клиенту,
+// пустое поле означает «как в карточке клиента», заполненное — переопределение для этого заказа.
+type orderDelivery struct {
+	City        string
+	FullName    string
+	Phone       string
+	PassportInn string
+	TK          string
+}
+
+// normalizePhone оставляет последние 10 цифр номера, чтобы +7, 8 и форматирование не мешали сравнению.
+func normalizePhone(raw string) string {
+	var digits strings.Builder
+	for _, r := range raw {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	value := digits.String()
+	if len(value) > 10 {
+		value = value[len(value)-10:]
+	}
+	return value
+}
+
+func normalizeClientName(raw string) string {
+	return strings.ToLower(strings.Join(strings.FieldsFunc(raw, unicode.IsSpace), " "))
+}
+
+func findClientByPhone(tx *sql.Tx, phone string) (int, error) {
+	key := normalizePhone(phone)
+	if len(key) < 10 {
+		return 0, nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT id FROM clients
+		WHERE RIGHT(REGEXP_REPLACE(COALESCE(phone, ''), '\D', '', 'g'), 10) = $1
+		LIMIT 2
+	`, key)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) != 1 {
+		return 0, nil
+	}
+	return ids[0], nil
+}
+
+// prepareOrderClient определяет клиента заказа (явно по client_id или однозначно по телефону)
+// и возвращает поля доставки для записи: совпадающие с карточкой клиента не сохраняются,
+// чтобы изменения клиента доходили до заказа.
+func prepareOrderClient(tx *sql.Tx, order *models.Order) (sql.NullInt64, orderDelivery, error) {
+	delivery := orderDelivery{
+		City:        strings.TrimSpace(order.City),
+		FullName:    strings.TrimSpace(order.FullName),
+		Phone:       strings.TrimSpace(order.Phone),
+		PassportInn: strings.TrimSpace(order.PassportInn),
+		TK:          strings.TrimSpace(order.TK),
+	}
+
+	clientID := order.ClientID
+	if clientID < 0 {
+		return sql.NullInt64{}, delivery, newBadRequestError("Invalid client ID")
+	}
+	if clientID == 0 {
+		id, err := findClientByPhone(tx, delivery.Phone)
+		if err != nil {
+			return sql.NullInt64{}, delivery, err
+		}
+		clientID = id
+	}
+	if clientID == 0 {
+		return sql.NullInt64{}, delivery, nil
+	}
+
+	var client models.Client
+	err := tx.QueryRow(`
+		SELECT id, COALESCE(city, ''), full_name, COALESCE(phone, ''), COALESCE(passport_number, ''), COALESCE(tk, '')
+		FROM clients WHERE id = $1
+	`, clientID).Scan(&client.ID, &client.City, &client.FullName, &client.Phone, &client.PassportNumber, &client.TK)
+	if err == sql.ErrNoRows {
+		return sql.NullInt64{}, delivery, newBadRequestError(fmt.Sprintf("client %d does not exist", clientID))
+	}
+	if err != nil {
+		return sql.NullInt64{}, delivery, err
+	}
+
+	if strings.EqualFold(delivery.City, strings.TrimSpace(client.City)) {
+		delivery.City = ""
+	}
+	if normalizeClientName(delivery.FullName) == normalizeClientName(client.FullName) {
+		delivery.FullName = ""
+	}
+	if normalizePhone(delivery.Phone) == normalizePhone(client.Phone) {
+		delivery.Phone = ""
+	}
+	if delivery.PassportInn == strings.TrimSpace(client.PassportNumber) {
+		delivery.PassportInn = ""
+	}
+	if strings.EqualFold(delivery.TK, strings.TrimSpace(client.TK)) {
+		delivery.TK = ""
+	}
+
+	order.ClientID = clientID
+	return sql.NullInt64{Int64: int64(clientID), Valid: true}, delivery, nil
+}
+
+// backfillOrderClients один раз привязывает старые заказы к клиентам: сначала по телефону, потом
+// по ФИО, только если совпадение однозначное. Поля доставки, совпадающие с карточкой, очищаются.
+// Повторять нельзя: DeleteClient намеренно отвязывает заказы, и повторная привязка вернула бы их
+// другому клиенту с теми же контактами. Отметка о выполнении — в data_migrations; база, где заказы
+// уже привязаны (привязка шла при каждом старте), только получает отметку.
+func backfillOrderClients() {
+	const migration = "order_clients_backfill"
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Fatal("Failed to link orders to clients:", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS data_migrations (
+			name TEXT PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		LOCK TABLE data_migrations;
+	`)
+	if err != nil {
+		log.Fatal("Failed to link orders to clients:", err)
+	}
+	var done, linked bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM data_migrations WHERE name = $1),
+			EXISTS(SELECT 1 FROM orders WHERE client_id IS NOT NULL)
+	`, migration).Scan(&done, &linked)
+	if err != nil {
+		log.Fatal("Failed to link orders to clients:", err)
+	}
+	if done {
+		return
+	}
+
+	if !linked {
+		_, err = tx.Exec(`
+			WITH client_phones AS (
+				SELECT RIGHT(REGEXP_REPLACE(COALESCE(phone, ''), '\D', '', 'g'), 10) AS phone_key,
+					MIN(id) AS client_id, COUNT(*) AS n
+				FROM clients
+				WHERE LENGTH(REGEXP_REPLACE(COALESCE(phone, ''), '\D', '', 'g')) >= 10
+				GROUP BY 1
+			)
+			UPDATE orders o
+			SET client_id = cp.client_id
+			FROM client_phones cp
+			WHERE o.client_id IS NULL
+				AND cp.n = 1
+				AND RIGHT(REGEXP_REPLACE(COALESCE(o.phone, ''), '\D', '', 'g'), 10) = cp.phone_key;
+
+			WITH client_names AS (
+				SELECT LOWER(REGEXP_REPLACE(BTRIM(full_name), '\s+', ' ', 'g')) AS name_key,
+					MIN(id) AS client_id, COUNT(*) AS n
+				FROM clients
+				WHERE BTRIM(COALESCE(full_name, '')) <> ''
+				GROUP BY 1
+			)
+			UPDATE orders o
+			SET client_id = cn.client_id
+			FROM client_names cn
+			WHERE o.client_id IS NULL
+				AND cn.n = 1
+				AND LOWER(REGEXP_REPLACE(BTRIM(COALESCE(o.full_name, '')), '\s+', ' ', 'g')) = cn.name_key;
+
+			UPDATE orders o
+			SET
+				city = CASE WHEN LOWER(BTRIM(COALESCE(o.city, ''))) = LOWER(BTRIM(COALESCE(cl.city, ''))) THEN '' ELSE o.city END,
+				full_name = CASE WHEN LOWER(REGEXP_REPLACE(BTRIM(COALESCE(o.full_name, '')), '\s+', ' ', 'g'))
+					= LOWER(REGEXP_REPLACE(BTRIM(COALESCE(cl.full_name, '')), '\s+', ' ', 'g')) THEN '' ELSE o.full_name END,
+				phone = CASE WHEN RIGHT(REGEXP_REPLACE(COALESCE(o.phone, ''), '\D', '', 'g'), 10)
+					= RIGHT(REGEXP_REPLACE(COALESCE(cl.phone, ''), '\D', '', 'g'), 10) THEN '' ELSE o.phone END,
+				passport_inn = CASE WHEN BTRIM(COALESCE(o.passport_inn, '')) = BTRIM(COALESCE(cl.passport_number, '')) THEN '' ELSE o.passport_inn END,
+				tk = CASE WHEN LOWER(BTRIM(COALESCE(o.tk, ''))) = LOWER(BTRIM(COALESCE(cl.tk, ''))) THEN '' ELSE o.tk END
+			FROM clients cl
+			WHERE cl.id = o.client_id;
+		`)
+		if err != nil {
+			log.Fatal("Failed to link orders to clients:", err)
+		}
+		log.Println("Orders linked to clients")
+	}
+	if _, err := tx.Exec("INSERT INTO data_migrations (name) VALUES ($1)", migration); err != nil {
+		log.Fatal("Failed to link orders to clients:", err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal("Failed to link orders to clients:", err)
+	}
+}
+
 func CreateClient(c *gin.Context) {
 	var client models.Client
 	if err := c.ShouldBindJSON(&client); err != nil {
@@ -45,19 +262,54 @@ func CreateClient(c *gin.Context) {
 	err := DB.QueryRow(`
 		INSERT INTO clients (city, full_name, phone, passport_number, tk, comment)
 		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id
-	`, client.City, client.FullName, client.Phone, client.PassportNumber, client.TK, client.Comment).Scan(&client.ID)
+		RETURNING id, version
+	`, client.City, client.FullName, client.Phone, client.PassportNumber, client.TK, client.Comment).Scan(&client.ID, &client.Version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setETag(c, client.Version)
+	c.JSON(http.StatusOK, client)
+}
+
+const clientColumns = "id, city, full_name, phone, passport_number, tk, comment, version"
+
+func scanClient(row interface{ Scan(...any) error }, client *models.Client) error {
+	return row.Scan(&client.ID, &client.City, &client.FullName, &client.Phone, &client.PassportNumber, &client.TK, &client.Comment, &client.Version)
+}
+
+func loadClient(id int) (models.Client, error) {
+	var client models.Client
+	err := scanClient(DB.QueryRow("SELECT "+clientColumns+" FROM clients WHERE id = $1", id), &client)
+	return client, err
+}
+
+// GetClient отдаёт одного клиента; ETag — его версия для If-Match при сохранении.
+func GetClient(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+
+	client, err := loadClient(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	setETag(c, client.Version)
 	c.JSON(http.StatusOK, client)
 }
 
 func GetClients(c *gin.Context) {
 	rows, err := DB.Query(`
-		SELECT id, city, full_name, phone, passport_number, tk, comment
+		SELECT ` + clientColumns + `
 		FROM clients
 		ORDER BY id DESC
 	`)
@@ -70,7 +322,7 @@ func GetClients(c *gin.Context) {
 	clients := make([]models.Client, 0)
 	for rows.Next() {
 		var client models.Client
-		if err := rows.Scan(&client.ID, &client.City, &client.FullName, &client.Phone, &client.PassportNumber, &client.TK, &client.Comment); err != nil {
+		if err := scanClient(rows, &client); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -81,7 +333,16 @@ func GetClients(c *gin.Context) {
 }
 
 func UpdateClient(c *gin.Context) {
-	id := c.Param("id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+	expectedVersion, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
 	var client models.Client
 	if err := c.ShouldBindJSON(&client); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -93,27 +354,72 @@ func UpdateClient(c *gin.Context) {
 		return
 	}
 
-	_, err := DB.Exec(`
+	err = DB.QueryRow(`
 		UPDATE clients
-		SET city = $1, full_name = $2, phone = $3, passport_number = $4, tk = $5, comment = $6
-		WHERE id = $7
-	`, client.City, client.FullName, client.Phone, client.PassportNumber, client.TK, client.Comment, id)
+		SET city = $1, full_name = $2, phone = $3, passport_number = $4, tk = $5, comment = $6, version = version + 1
+		WHERE id = $7 AND ($8::BIGINT = -1 OR version = $8)
+		RETURNING version
+	`, client.City, client.FullName, client.Phone, client.PassportNumber, client.TK, client.Comment, id, expectedVersion).Scan(&client.Version)
+	if err == sql.ErrNoRows {
+		current, loadErr := loadClient(id)
+		if loadErr == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+			return
+		}
+		if loadErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": loadErr.Error()})
+			return
+		}
+		writeVersionConflict(c, current.Version, current)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Client updated successfully"})
+	setETag(c, client.Version)
+	c.JSON(http.StatusOK, gin.H{"message": "Client updated successfully", "version": client.Version})
 }
 
 func DeleteClient(c *gin.Context) {
 	id := c.Param("id")
 
-	_, err := DB.Exec(`DELETE FROM clients WHERE id = $1`, id)
+	tx, err := DB.Begin()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	defer tx.Rollback()
+
+	// Заказы клиента забирают себе его данные, иначе после удаления поля доставки окажутся пустыми.
+	_, err = tx.Exec(`
+		UPDATE orders o
+		SET
+			city = COALESCE(NULLIF(o.city, ''), cl.city),
+			full_name = COALESCE(NULLIF(o.full_name, ''), cl.full_name),
+			phone = COALESCE(NULLIF(o.phone, ''), cl.phone),
+			passport_inn = COALESCE(NULLIF(o.passport_inn, ''), cl.passport_number),
+			tk = COALESCE(NULLIF(o.tk, ''), cl.tk),
+			client_id = NULL
+		FROM clients cl
+		WHERE cl.id = o.client_id AND cl.id = $1
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM clients WHERE id = $1`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Client deleted successfully"})
 }
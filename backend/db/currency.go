@@ -0,0 +1,287 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Валютные оплаты. amount всегда хранит рублёвый эквивалент — по нему считаются долги,
+// распределения и отчёты; original_amount, currency и rate — сумма в валюте способа оплаты
+// и курс на дату оплаты. Курсы ведутся вручную в exchange_rates.
+
+const (
+	baseCurrency = "RUB"
+
+	// Курс берётся на дату оплаты или ближайшую раньше: ЦБ не публикует курсы на выходные
+	// и праздники. Более старый курс считается устаревшим.
+	maxExchangeRateAgeDays = 14
+)
+
+type exchangeRateRequest struct {
+	Currency string  `json:"currency"`
+	Date     string  `json:"date"`
+	Rate     float64 `json:"rate"`
+}
+
+// CreateTablesCurrency создаёт таблицу курсов и добавляет оплатам валюту, исходную сумму и курс.
+// Старые оплаты считаются рублёвыми.
+func CreateTablesCurrency() {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS exchange_rates (
+			currency TEXT NOT NULL,
+			date DATE NOT NULL,
+			rate DOUBLE PRECISION NOT NULL CHECK (rate > 0),
+			PRIMARY KEY (currency, date)
+		);
+
+		ALTER TABLE payments_monitoring ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'RUB';
+		ALTER TABLE payments_monitoring ADD COLUMN IF NOT EXISTS original_amount DOUBLE PRECISION;
+		ALTER TABLE payments_monitoring ADD COLUMN IF NOT EXISTS rate DOUBLE PRECISION NOT NULL DEFAULT 1;
+
+		UPDATE payments_monitoring SET original_amount = amount WHERE original_amount IS NULL;
+
+		CREATE INDEX IF NOT EXISTS idx_payments_monitoring_currency ON payments_monitoring(currency);
+	`)
+	if err != nil {
+		log.Fatal("Failed to migrate currencies:", err)
+	}
+	log.Println("Currency tables are ready")
+}
+
+func normalizeCurrency(raw string) (string, error) {
+	currency := strings.ToUpper(strings.TrimSpace(raw))
+	if currency == "" {
+		return baseCurrency, nil
+	}
+	if len(currency) != 3 || strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", newBadRequestError("Currency must be a three-letter ISO code, e.g. RUB or USD")
+	}
+	return currency, nil
+}
+
+// paymentMethodCurrency — валюта способа оплаты; пустая в справочнике означает рубли.
+func paymentMethodCurrency(q queryer, method string) (string, error) {
+	rows, err := q.Query("SELECT COALESCE(NULLIF(currency, ''), $2) FROM payment_methods WHERE method = $1", method, baseCurrency)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	currency := baseCurrency
+	if rows.Next() {
+		if err := rows.Scan(&currency); err != nil {
+			return "", err
+		}
+	}
+	return currency, rows.Err()
+}
+
+// exchangeRateOn — курс валюты на дату оплаты (paymentDateTimeLayout или YYYY-MM-DD).
+func exchangeRateOn(q queryer, currency, date string) (float64, error) {
+	rows, err := q.Query(`
+		SELECT rate FROM exchange_rates
+		WHERE currency = $1 AND date <= LEFT($2, 10)::date AND date > LEFT($2, 10)::date - $3::int
+		ORDER BY date DESC
+		LIMIT 1
+	`, currency, date, maxExchangeRateAgeDays)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		if len(date) > 10 {
+			date = date[:10]
+		}
+		return 0, newBadRequestError(fmt.Sprintf("No %s exchange rate for %s: add it to exchange rates or pass rate", currency, date))
+	}
+	var rate float64
+	err = rows.Scan(&rate)
+	return rate, err
+}
+
+// applyPaymentCurrency заполняет валюту, исходную сумму, курс и рублёвый amount оплаты.
+// Валюта берётся из способа оплаты. Для валютного способа сумма вводится в его валюте —
+// в original_amount, а если его нет, в amount; курс без явного rate берётся на дату оплаты.
+// Для рублёвого способа original_amount = amount, курс 1. Знак суммы сохраняется.
+// p.Date к этому моменту должна быть нормализована.
+func applyPaymentCurrency(q queryer, p *models.Payment) error {
+	currency, err := paymentMethodCurrency(q, p.Method)
+	if err != nil {
+		return err
+	}
+	if p.Currency != "" {
+		requested, err := normalizeCurrency(p.Currency)
+		if err != nil {
+			return err
+		}
+		if requested != currency {
+			return newBadRequestError(fmt.Sprintf("Payment method %s accepts %s, not %s", p.Method, currency, requested))
+		}
+	}
+	p.Currency = currency
+
+	if currency == baseCurrency {
+		p.OriginalAmount, p.Rate = p.Amount, 1
+		return nil
+	}
+	if p.OriginalAmount == 0 {
+		p.OriginalAmount = p.Amount
+	}
+	if p.Rate < 0 || math.IsNaN(p.Rate) || math.IsInf(p.Rate, 0) {
+		return newBadRequestError("Exchange rate must be positive")
+	}
+	if p.Rate == 0 {
+		if p.Rate, err = exchangeRateOn(q, currency, p.Date); err != nil {
+			return err
+		}
+	}
+	p.Amount = roundMoney(p.OriginalAmount * p.Rate)
+	return nil
+}
+
+// applyBaseAmountCurrency — то же для суммы, уже заданной в рублях (возврат по документу возврата):
+// сумма в валюте способа получается делением на курс.
+func applyBaseAmountCurrency(q queryer, p *models.Payment) error {
+	currency, err := paymentMethodCurrency(q, p.Method)
+	if err != nil {
+		return err
+	}
+	p.Currency, p.OriginalAmount, p.Rate = currency, p.Amount, 1
+	if currency == baseCurrency {
+		return nil
+	}
+	if p.Rate, err = exchangeRateOn(q, currency, p.Date); err != nil {
+		return err
+	}
+	p.OriginalAmount = roundMoney(p.Amount / p.Rate)
+	return nil
+}
+
+// GetExchangeRates — GET /exchange_rates?currency=&date_from=&date_to=, новые даты первыми.
+func GetExchangeRates(c *gin.Context) {
+	conditions := []string{}
+	args := []any{}
+	if raw := c.Query("currency"); raw != "" {
+		currency, err := normalizeCurrency(raw)
+		if err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		args = append(args, currency)
+		conditions = append(conditions, fmt.Sprintf("currency = $%d", len(args)))
+	}
+	for _, bound := range []struct{ param, op string }{{"date_from", ">="}, {"date_to", "<="}} {
+		raw := c.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + bound.param + ": expected YYYY-MM-DD"})
+			return
+		}
+		args = append(args, raw)
+		conditions = append(conditions, fmt.Sprintf("date %s $%d", bound.op, len(args)))
+	}
+
+	rows, err := DB.Query("SELECT currency, date, rate FROM exchange_rates"+whereClause(strings.Join(conditions, " AND "))+" ORDER BY date DESC, currency", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	rates := make([]models.ExchangeRate, 0)
+	for rows.Next() {
+		var r models.ExchangeRate
+		var date time.Time
+		if err := rows.Scan(&r.Currency, &date, &r.Rate); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		r.Date = date.Format("2006-01-02")
+		rates = append(rates, r)
+	}
+	c.JSON(http.StatusOK, rates)
+}
+
+// SaveExchangeRate — PUT /exchange_rates: задаёт курс валюты на дату (рублей за единицу).
+// Уже внесённые оплаты не пересчитываются: курс в них сохранён.
+func SaveExchangeRate(c *gin.Context) {
+	var req exchangeRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	currency, err := normalizeCurrency(req.Currency)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if currency == baseCurrency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "RUB rate is always 1"})
+		return
+	}
+	if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date: expected YYYY-MM-DD"})
+		return
+	}
+	if !(req.Rate > 0) || math.IsInf(req.Rate, 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Exchange rate must be positive"})
+		return
+	}
+
+	if _, err := DB.Exec(`
+		INSERT INTO exchange_rates (currency, date, rate) VALUES ($1, $2, $3)
+		ON CONFLICT (currency, date) DO UPDATE SET rate = EXCLUDED.rate
+	`, currency, req.Date, req.Rate); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, models.ExchangeRate{Currency: currency, Date: req.Date, Rate: req.Rate})
+}
+
+// DeleteExchangeRate — DELETE /exchange_rates?currency=&date=.
+func DeleteExchangeRate(c *gin.Context) {
+	currency, err := normalizeCurrency(c.Query("currency"))
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if _, err := time.Parse("2006-01-02", c.Query("date")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date: expected YYYY-MM-DD"})
+		return
+	}
+
+	result, err := DB.Exec("DELETE FROM exchange_rates WHERE currency = $1 AND date = $2", currency, c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Exchange rate not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Exchange rate deleted"})
+}
+
+// keepPaymentRate оставляет правимой оплате прежний курс, если не меняются способ и день оплаты:
+// иначе правка комментария пересчитала бы сумму по курсу из справочника.
+func keepPaymentRate(p *models.Payment, oldMethod, oldDate string, oldRate float64) {
+	if p.Rate != 0 || strings.TrimSpace(p.Method) != oldMethod {
+		return
+	}
+	date, err := normalizePaymentDateInput(p.Date)
+	if err != nil || len(oldDate) < 10 || date[:10] != oldDate[:10] {
+		return
+	}
+	p.Rate = oldRate
+}
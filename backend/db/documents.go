@@ -0,0 +1,168 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Печатные документы заказа.
+const (
+	DocumentInvoice     = "invoice"
+	DocumentPackingList = "packing-list"
+)
+
+func CreateTablesDocuments() {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS document_sequences (
+			doc_type TEXT PRIMARY KEY,
+			prefix TEXT NOT NULL DEFAULT '',
+			next_number BIGINT NOT NULL DEFAULT 1 CHECK (next_number > 0),
+			padding INT NOT NULL DEFAULT 0 CHECK (padding >= 0 AND padding <= 12)
+		);
+
+		CREATE TABLE IF NOT EXISTS order_documents (
+			id BIGSERIAL PRIMARY KEY,
+			order_id BIGINT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+			doc_type TEXT NOT NULL REFERENCES document_sequences(doc_type),
+			number TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (order_id, doc_type)
+		);
+
+		INSERT INTO document_sequences (doc_type, prefix, next_number, padding)
+		VALUES ('invoice', 'СЧ-', 1, 5), ('packing-list', 'УП-', 1, 5)
+		ON CONFLICT (doc_type) DO NOTHING;
+	`)
+	if err != nil {
+		log.Fatal("Failed to create document tables:", err)
+	}
+	log.Println("Document tables are ready")
+}
+
+func formatDocumentNumber(prefix string, number int64, padding int) string {
+	return fmt.Sprintf("%s%0*d", prefix, padding, number)
+}
+
+// OrderDocumentNumber возвращает номер документа заказа. Номер выдаётся из последовательности
+// при первой печати и дальше не меняется, повторная печать даёт тот же номер и дату.
+func OrderDocumentNumber(orderID int, docType string) (models.OrderDocument, error) {
+	doc := models.OrderDocument{OrderID: orderID, DocType: docType}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return doc, err
+	}
+	defer tx.Rollback()
+
+	// Блокировка заказа не даёт двум одновременным запросам выдать два номера.
+	if err := lockOrder(tx, orderID); err != nil {
+		return doc, err
+	}
+
+	var createdAt time.Time
+	err = tx.QueryRow(`
+		SELECT id, number, created_at FROM order_documents WHERE order_id = $1 AND doc_type = $2
+	`, orderID, docType).Scan(&doc.ID, &doc.Number, &createdAt)
+	if err == nil {
+		doc.CreatedAt = createdAt.Format(paymentDateTimeLayout)
+		return doc, nil
+	}
+	if err != sql.ErrNoRows {
+		return doc, err
+	}
+
+	var prefix string
+	var number int64
+	var padding int
+	err = tx.QueryRow(`
+		UPDATE document_sequences SET next_number = next_number + 1
+		WHERE doc_type = $1
+		RETURNING prefix, next_number - 1, padding
+	`, docType).Scan(&prefix, &number, &padding)
+	if err == sql.ErrNoRows {
+		return doc, fmt.Errorf("no numbering sequence for document type %q", docType)
+	}
+	if err != nil {
+		return doc, err
+	}
+	doc.Number = formatDocumentNumber(prefix, number, padding)
+
+	if err := tx.QueryRow(`
+		INSERT INTO order_documents (order_id, doc_type, number) VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`, orderID, docType, doc.Number).Scan(&doc.ID, &createdAt); err != nil {
+		return doc, err
+	}
+	doc.CreatedAt = createdAt.Format(paymentDateTimeLayout)
+
+	return doc, tx.Commit()
+}
+
+func GetDocumentSequences(c *gin.Context) {
+	rows, err := DB.Query("SELECT doc_type, prefix, next_number, padding FROM document_sequences ORDER BY doc_type")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	sequences := make([]models.DocumentSequence, 0)
+	for rows.Next() {
+		var seq models.DocumentSequence
+		if err := rows.Scan(&seq.DocType, &seq.Prefix, &seq.NextNumber, &seq.Padding); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		seq.Sample = formatDocumentNumber(seq.Prefix, seq.NextNumber, seq.Padding)
+		sequences = append(sequences, seq)
+	}
+
+	c.JSON(http.StatusOK, sequences)
+}
+
+// UpdateDocumentSequence меняет префикс, разрядность и следующий номер. Уже выданные
+// номера документов не пересчитываются.
+func UpdateDocumentSequence(c *gin.Context) {
+	var seq models.DocumentSequence
+	if err := c.ShouldBindJSON(&seq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	seq.DocType = c.Param("doc_type")
+	seq.Prefix = strings.TrimSpace(seq.Prefix)
+	if seq.NextNumber <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "next_number must be positive"})
+		return
+	}
+	if seq.Padding < 0 || seq.Padding > 12 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "padding must be between 0 and 12"})
+		return
+	}
+
+	result, err := DB.Exec(`
+		UPDATE document_sequences SET prefix = $1, next_number = $2, padding = $3 WHERE doc_type = $4
+	`, seq.Prefix, seq.NextNumber, seq.Padding, seq.DocType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document sequence not found"})
+		return
+	}
+
+	seq.Sample = formatDocumentNumber(seq.Prefix, seq.NextNumber, seq.Padding)
+	c.JSON(http.StatusOK, seq)
+}
@@ -0,0 +1,275 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Talonmortem/SHM/internal/export"
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Выгрузка таблиц: GET /<таблица>/export?format=csv|xlsx с теми же фильтрами, что и у списка.
+// Строки пишутся в ответ по мере чтения из базы, без сборки файла в памяти.
+
+var orderStatusNames = map[int]string{
+	orderStatusNew:       "Новый",
+	orderStatusReady:     "Готов к отправке",
+	orderStatusShipped:   "Отправлен",
+	orderStatusCancelled: "Отменён",
+	orderStatusReturned:  "Возвращён",
+}
+
+var productStatusNames = map[int]string{
+	1: "На продаже",
+	2: "Забронировано",
+	3: "Продано",
+}
+
+func statusName(names map[int]string, status int) string {
+	if name, ok := names[status]; ok {
+		return name
+	}
+	return strconv.Itoa(status)
+}
+
+// exportFormat читает ?format=. Пустая строка — обычный JSON-ответ, при неизвестном формате отвечает 400.
+func exportFormat(c *gin.Context) (string, bool) {
+	format, err := export.ParseFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return format, true
+}
+
+// startExport пишет заголовки ответа-файла; name — имя файла без расширения, только латиница.
+func startExport(c *gin.Context, format, name string) {
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	c.Status(http.StatusOK)
+}
+
+// writeExport отдаёт уже посчитанную таблицу файлом.
+func writeExport(c *gin.Context, format, name string, table export.Table) {
+	startExport(c, format, name)
+	if err := export.Write(c.Writer, format, table); err != nil {
+		log.Printf("Export %s failed: %v", name, err)
+	}
+}
+
+// streamExport выполняет запрос и отдаёт строки файлом по мере чтения. Ошибка запроса — 500 в JSON;
+// ошибка после начала ответа клиенту уже не передать, она пишется в лог, и файл обрывается.
+func streamExport(c *gin.Context, name, sheet string, columns []string, query string, args []any, scan func(*sql.Rows) ([]any, error)) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+	if format == "" {
+		format = export.FormatCSV
+	}
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	name += "-" + time.Now().Format("2006-01-02")
+	startExport(c, format, name)
+	w, err := export.NewWriter(c.Writer, format, sheet, columns)
+	if err != nil {
+		log.Printf("Export %s failed: %v", name, err)
+		return
+	}
+	for rows.Next() {
+		values, err := scan(rows)
+		if err == nil {
+			err = w.WriteRow(values)
+		}
+		if err != nil {
+			log.Printf("Export %s failed: %v", name, err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Export %s failed: %v", name, err)
+		return
+	}
+	if err := w.Close(); err != nil {
+		log.Printf("Export %s failed: %v", name, err)
+	}
+}
+
+// exportNumber превращает число, сохранённое строкой («1,5», «1 200»), в числовую ячейку.
+// Нечисловое значение остаётся текстом, пустое — пустой ячейкой.
+func exportNumber(raw string) any {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	if value, err := parseAmount(raw); err == nil {
+		return value
+	}
+	return raw
+}
+
+func whereClause(where string) string {
+	if where == "" {
+		return ""
+	}
+	return " WHERE " + where
+}
+
+func ExportProducts(c *gin.Context) {
+	columns := []string{"ID", "Статус", "Название", "Вес, кг", "Скидка", "Сумма со скидкой, ₽", "Количество", "Цена за шт., ₽", "Видео", "Описание"}
+	streamExport(c, "products", "Товары", columns, "SELECT "+productColumns+" FROM products ORDER BY id", nil, func(rows *sql.Rows) ([]any, error) {
+		var p models.Product
+		if err := scanProduct(rows, &p); err != nil {
+			return nil, err
+		}
+		return []any{
+			p.ID, statusName(productStatusNames, p.Status), p.Name, exportNumber(p.Weight), exportNumber(p.Skidka),
+			exportNumber(p.SummaRubSoSkidkoj), p.Count, exportNumber(p.OnePrice), p.Video, p.Description,
+		}, nil
+	})
+}
+
+// ExportOrders выгружает заказы по строке на заказ: количество мешков, оплачено и долг считаются в запросе.
+func ExportOrders(c *gin.Context) {
+	where := ""
+	var args []any
+	if raw := strings.TrimSpace(c.Query("client_id")); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+			return
+		}
+		where = "o.client_id = $1"
+		args = append(args, id)
+	}
+
+	columns := []string{
+		"ID", "Название", "Статус", "Создан", "Дата отгрузки", "ID клиента", "ФИО", "Телефон", "Город", "ТК",
+		"Мешков", "Сумма, ₽", "Оплачено, ₽", "Долг, ₽", "Мест", "Стоимость доставки, ₽", "Вес, кг", "Описание",
+	}
+	query := `
+		SELECT o.id, o.name, o.status, o.created_at, COALESCE(to_char(o.ship_date, 'YYYY-MM-DD'), ''), o.client_id,
+			COALESCE(NULLIF(o.full_name, ''), cl.full_name, ''), COALESCE(NULLIF(o.phone, ''), cl.phone, ''),
+			COALESCE(NULLIF(o.city, ''), cl.city, ''), COALESCE(NULLIF(o.tk, ''), cl.tk, ''),
+			(SELECT COUNT(*) FROM order_products op WHERE op.order_id = o.id),
+			(SELECT COALESCE(SUM(pm.amount), 0) FROM payments_monitoring pm WHERE pm.order_id = o.id AND pm.status <> 'rejected')
+				+ (SELECT COALESCE(SUM(pa.amount), 0) FROM payment_allocations pa WHERE pa.order_id = o.id),
+			COALESCE(o.debt, 0), COALESCE(o.places, 0),
+			(SELECT COALESCE(SUM(oa.amount), 0) FROM order_adjustments oa WHERE oa.order_id = o.id AND oa.kind = 'delivery'),
+			COALESCE(o.weight, 0), COALESCE(o.description, '')
+		FROM orders o
+		LEFT JOIN clients cl ON cl.id = o.client_id` + whereClause(where) + `
+		ORDER BY o.id`
+	streamExport(c, "orders", "Заказы", columns, query, args, func(rows *sql.Rows) ([]any, error) {
+		var id, status, products, places int
+		var name, shipDate, fullName, phone, city, tk, description string
+		var createdAt sql.NullTime
+		var clientID sql.NullInt64
+		var paid, debt, price, weight float64
+		if err := rows.Scan(&id, &name, &status, &createdAt, &shipDate, &clientID, &fullName, &phone, &city, &tk,
+			&products, &paid, &debt, &places, &price, &weight, &description); err != nil {
+			return nil, err
+		}
+		var created, client any
+		if createdAt.Valid {
+			created = createdAt.Time.Format(paymentDateTimeLayout)
+		}
+		if clientID.Valid {
+			client = clientID.Int64
+		}
+		return []any{
+			id, name, statusName(orderStatusNames, status), created, shipDate, client, fullName, phone, city, tk,
+			products, paid + debt, paid, debt, places, price, weight, description,
+		}, nil
+	})
+}
+
+func ExportArticles(c *gin.Context) {
+	columns := []string{"Служебный ID", "Артикул", "№", "Код", "Описание", "Евро", "Colli", "Кг", "Стоимость"}
+	streamExport(c, "articles", "Артикулы", columns, "SELECT "+articleColumns+" FROM articles ORDER BY id", nil, func(rows *sql.Rows) ([]any, error) {
+		var a models.Article
+		if err := scanArticle(rows, &a); err != nil {
+			return nil, err
+		}
+		return []any{a.ServiceID, a.ID, a.No, a.Code, a.Description, a.Euro, a.Colli, a.KG, a.Value}, nil
+	})
+}
+
+func ExportClients(c *gin.Context) {
+	columns := []string{"ID", "ФИО", "Телефон", "Город", "Паспорт", "ТК", "Комментарий"}
+	streamExport(c, "clients", "Клиенты", columns, "SELECT "+clientColumns+" FROM clients ORDER BY id DESC", nil, func(rows *sql.Rows) ([]any, error) {
+		var cl models.Client
+		if err := scanClient(rows, &cl); err != nil {
+			return nil, err
+		}
+		return []any{cl.ID, cl.FullName, cl.Phone, cl.City, cl.PassportNumber, cl.TK, cl.Comment}, nil
+	})
+}
+
+func ExportShipments(c *gin.Context) {
+	where, args, err := shipmentsFilter(c)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	columns := []string{"ID", "Дата отгрузки", "Город", "ФИО", "Телефон", "Паспорт/ИНН", "ТК", "Мест", "Стоимость, ₽", "Вес, кг"}
+	query := "SELECT " + shipmentColumns + " FROM shipments" + whereClause(where) + " ORDER BY ship_date DESC, id DESC"
+	streamExport(c, "shipments", "Отгрузки", columns, query, args, func(rows *sql.Rows) ([]any, error) {
+		var s models.Shipment
+		if err := scanShipment(rows, &s); err != nil {
+			return nil, err
+		}
+		return []any{s.ID, s.ShipDate, s.City, s.FullName, s.Phone, s.PassportInn, s.TK, s.Places, s.Price, s.Weight}, nil
+	})
+}
+
+func ExportPaymentsMonitoring(c *gin.Context) {
+	where, args, err := paymentsMonitoringFilter(c)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	columns := []string{"ID", "Дата", "Способ оплаты", "Заказ", "Тип", "Валюта", "Сумма в валюте", "Курс", "Сумма, ₽", "Комментарий", "Причина возврата", "Статус", "Проверил"}
+	query := `SELECT pm.id, COALESCE(to_char(pm.date, 'YYYY-MM-DD HH24:MI:SS'), ''), pm.method, pm.order_id, pm.type,
+		pm.currency, COALESCE(pm.original_amount, pm.amount, 0), pm.rate, COALESCE(pm.amount, 0), COALESCE(pm.comment, ''), pm.reason,
+		pm.status, COALESCE(pm.confirmed_by, '')
+	FROM payments_monitoring pm
+	JOIN payment_methods pp ON pp.method = pm.method` + whereClause(where) + " ORDER BY pm.date"
+	streamExport(c, "payments", "Оплаты", columns, query, args, func(rows *sql.Rows) ([]any, error) {
+		var id int
+		var date, method, paymentType, currency, comment, reason, status, confirmedBy string
+		var orderID sql.NullInt64
+		var originalAmount, rate, amount float64
+		if err := rows.Scan(&id, &date, &method, &orderID, &paymentType, &currency, &originalAmount, &rate, &amount, &comment, &reason, &status, &confirmedBy); err != nil {
+			return nil, err
+		}
+		var order any
+		if orderID.Valid {
+			order = orderID.Int64
+		}
+		return []any{id, date, method, order, paymentTypeNames[paymentType], currency, originalAmount, rate, amount, comment, reason,
+			paymentStatusNames[status], confirmedBy}, nil
+	})
+}
+
+func ExportBalance(c *gin.Context) {
+	columns := []string{"Артикул", "№", "Код", "Описание", "Приход, кг", "Отгружено, кг", "Остаток, кг", "В брони, кг", "Свободно, кг"}
+	streamExport(c, "balance", "Остатки", columns, balanceQuery, nil, func(rows *sql.Rows) ([]any, error) {
+		var b models.BalanceRow
+		if err := scanBalanceRow(rows, &b); err != nil {
+			return nil, err
+		}
+		return []any{b.ID, b.No, b.Code, b.Description, b.IncomeKG, b.SentKG, b.BalanceKG, b.ReservedKG, b.FreeKG}, nil
+	})
+}
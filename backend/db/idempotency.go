@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Idempotency-Key: ответ на POST с ключом хранится сутки, повтор запроса с тем же ключом
+// получает сохранённый ответ вместо повторного создания заказа, оплаты или отправки.
+
+const (
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyPendingTTL — сколько держать ключ запроса, который так и не завершился (паника, рестарт).
+	idempotencyPendingTTL  = 5 * time.Minute
+	idempotencyCleanupTick = time.Hour
+)
+
+// IdempotencyRecord — сохранённый запрос. StatusCode 0 — запрос ещё выполняется.
+type IdempotencyRecord struct {
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+func CreateTablesIdempotency() {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			username TEXT NOT NULL,
+			key TEXT NOT NULL,
+			method TEXT NOT NULL,
+			path TEXT NOT NULL,
+			request_hash TEXT NOT NULL,
+			status_code INT,
+			content_type TEXT NOT NULL DEFAULT '',
+			response BYTEA,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (username, key)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create idempotency keys table:", err)
+	}
+	log.Println("Idempotency keys table is ready")
+}
+
+const expiredIdempotencyCondition = `(
+	created_at < NOW() - make_interval(secs => $1)
+	OR (status_code IS NULL AND created_at < NOW() - make_interval(secs => $2))
+)`
+
+// BeginIdempotentRequest занимает ключ пользователя. Если ключ свободен, возвращает owned=true:
+// запрос нужно выполнить и сохранить ответ. Иначе возвращает ранее сохранённую запись.
+func BeginIdempotentRequest(username, key, method, path, requestHash string) (IdempotencyRecord, bool, error) {
+	var record IdempotencyRecord
+	if _, err := DB.Exec(
+		"DELETE FROM idempotency_keys WHERE username = $3 AND key = $4 AND "+expiredIdempotencyCondition,
+		idempotencyKeyTTL.Seconds(), idempotencyPendingTTL.Seconds(), username, key,
+	); err != nil {
+		return record, false, err
+	}
+
+	result, err := DB.Exec(`
+		INSERT INTO idempotency_keys (username, key, method, path, request_hash)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (username, key) DO NOTHING
+	`, username, key, method, path, requestHash)
+	if err != nil {
+		return record, false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return record, false, err
+	} else if affected == 1 {
+		return record, true, nil
+	}
+
+	var statusCode sql.NullInt64
+	err = DB.QueryRow(
+		"SELECT request_hash, status_code, content_type, response FROM idempotency_keys WHERE username = $1 AND key = $2",
+		username, key,
+	).Scan(&record.RequestHash, &statusCode, &record.ContentType, &record.Body)
+	record.StatusCode = int(statusCode.Int64)
+	return record, false, err
+}
+
+// CompleteIdempotentRequest сохраняет ответ для повторов.
+func CompleteIdempotentRequest(username, key string, statusCode int, contentType string, body []byte) error {
+	_, err := DB.Exec(`
+		UPDATE idempotency_keys SET status_code = $3, content_type = $4, response = $5
+		WHERE username = $1 AND key = $2
+	`, username, key, statusCode, contentType, body)
+	return err
+}
+
+// AbandonIdempotentRequest освобождает ключ, чтобы запрос можно было повторить (например, после 500).
+func AbandonIdempotentRequest(username, key string) error {
+	_, err := DB.Exec("DELETE FROM idempotency_keys WHERE username = $1 AND key = $2", username, key)
+	return err
+}
+
+// StartIdempotencyKeyCleanup раз в час удаляет ключи старше суток.
+func StartIdempotencyKeyCleanup() {
+	go func() {
+		ticker := time.NewTicker(idempotencyCleanupTick)
+		defer ticker.Stop()
+		for {
+			result, err := DB.Exec("DELETE FROM idempotency_keys WHERE "+expiredIdempotencyCondition,
+				idempotencyKeyTTL.Seconds(), idempotencyPendingTTL.Seconds())
+			if err != nil {
+				log.Printf("Idempotency key cleanup failed: %v", err)
+			} else if n, _ := result.RowsAffected(); n > 0 {
+				log.Printf("Idempotency key cleanup: %d keys removed", n)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// isUniqueViolation сообщает, что запись нарушила уникальный индекс.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -13,49 +13,113 @@ import (
 )
 
 func GetOrders(c *gin.Context) {
+	getOrders(c, strings.TrimSpace(c.Query("client_id")))
+}
+
+// GetClientOrders отдаёт заказы клиента из справочника.
+func GetClientOrders(c *gin.Context) {
+	getOrders(c, c.Param("id"))
+}
+
+func getOrders(c *gin.Context, clientID string) {
+	var orders []models.Order
+	var err error
+	if clientID != "" {
+		id, convErr := strconv.Atoi(clientID)
+		if convErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+			return
+		}
+		orders, err = queryOrders("o.client_id = $1", id)
+	} else {
+		orders, err = queryOrders("")
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, orders)
+}
+
+// LoadOrder читает один заказ со всеми товарами и оплатами.
+func LoadOrder(id int) (models.Order, error) {
+	orders, err := queryOrders("o.id = $1", id)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if len(orders) == 0 {
+		return models.Order{}, sql.ErrNoRows
+	}
+	return orders[0], nil
+}
+
+// GetOrder отдаёт один заказ; ETag — его версия для If-Match при сохранении.
+func GetOrder(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	order, err := LoadOrder(id)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	setETag(c, order.Version)
+	c.JSON(http.StatusOK, order)
+}
+
+// queryOrders собирает заказы с товарами и оплатами; where — необязательное условие на o.*.
+func queryOrders(where string, args ...any) ([]models.Order, error) {
 	query := `
         SELECT o.id, o.name, o.quantity, o.status, o.description, o.debt,
-               o.ship_date, o.city, o.full_name, o.phone, o.passport_inn, o.tk, o.places, o.price, o.weight,
-               p.id, p.status, p.name, p.video, p.weight, p.skidka, p.summaRubSoSkidkoj, p.count, p.onePrice, p.description,
-               pm.id, pm.date, pm.method, pm.amount, pm.comment
+               to_char(o.ship_date, 'YYYY-MM-DD'),
+               COALESCE(NULLIF(o.city, ''), cl.city), COALESCE(NULLIF(o.full_name, ''), cl.full_name),
+               COALESCE(NULLIF(o.phone, ''), cl.phone), COALESCE(NULLIF(o.passport_inn, ''), cl.passport_number),
+               COALESCE(NULLIF(o.tk, ''), cl.tk),
+               o.places, o.weight, o.client_id,
+               o.payment_resolution, o.close_reason, o.closed_at, o.version,
+               p.id, p.status, p.name, p.video, p.weight, p.skidka, p.summaRubSoSkidkoj, p.count, p.onePrice, p.description
         FROM orders o
+        LEFT JOIN clients cl ON cl.id = o.client_id
         LEFT JOIN order_products op ON o.id = op.order_id
         LEFT JOIN products p ON op.product_id = p.id
-        LEFT JOIN payments_monitoring pm ON o.id = pm.order_id
     `
-	rows, err := DB.Query(query)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := DB.Query(query, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
 	ordersMap := make(map[int]*models.Order)
 	componentsMaps := make(map[int]map[int]models.Product)
-	paymentsMap := make(map[int]map[int]models.Payment)
 
 	for rows.Next() {
 		var o models.Order
 		var p models.Product
-		var pm models.Payment
 		var debt sql.NullFloat64
 		var shipDate, city, fullName, phone, passportInn, tk sql.NullString
-		var places sql.NullInt64
-		var price, weight sql.NullFloat64
-		var productID, paymentID sql.NullInt64
+		var places, orderClientID sql.NullInt64
+		var weight sql.NullFloat64
+		var paymentResolution, closeReason sql.NullString
+		var closedAt sql.NullTime
+		var productID sql.NullInt64
 		var productStatus, productCount sql.NullInt64
 		var productName, productVideo, productWeight, productSkidka, productSummaRubSoSkidkoj, productOnePrice, productDescription sql.NullString
-		var paymentMethod, paymentDate, paymentComment sql.NullString
-		var paymentAmount sql.NullFloat64
 		err := rows.Scan(
 			&o.ID, &o.Name, &o.Quantity, &o.Status, &o.Description, &debt,
-			&shipDate, &city, &fullName, &phone, &passportInn, &tk, &places, &price, &weight,
+			&shipDate, &city, &fullName, &phone, &passportInn, &tk, &places, &weight, &orderClientID,
+			&paymentResolution, &closeReason, &closedAt, &o.Version,
 			&productID, &productStatus, &productName, &productVideo, &productWeight, &productSkidka, &productSummaRubSoSkidkoj, &productCount, &productOnePrice, &productDescription,
-			&paymentID, &paymentDate, &paymentMethod, &paymentAmount, &paymentComment,
 		)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return nil, err
 		}
 
 		if _, exists := ordersMap[o.ID]; !exists {
@@ -83,15 +147,23 @@ func GetOrders(c *gin.Context) {
 			if places.Valid {
 				o.Places = int(places.Int64)
 			}
-			if price.Valid {
-				o.Price = price.Float64
-			}
 			if weight.Valid {
 				o.Weight = weight.Float64
 			}
+			if orderClientID.Valid {
+				o.ClientID = int(orderClientID.Int64)
+			}
+			if paymentResolution.Valid {
+				o.PaymentResolution = paymentResolution.String
+			}
+			if closeReason.Valid {
+				o.CloseReason = closeReason.String
+			}
+			if closedAt.Valid {
+				o.ClosedAt = closedAt.Time.Format(paymentDateTimeLayout)
+			}
 			ordersMap[o.ID] = &o
 			componentsMaps[o.ID] = make(map[int]models.Product)
-			paymentsMap[o.ID] = make(map[int]models.Payment)
 		}
 
 		if productID.Valid && productName.Valid {
@@ -123,21 +195,6 @@ func GetOrders(c *gin.Context) {
 			}
 			componentsMaps[o.ID][p.ID] = p
 		}
-
-		if paymentID.Valid && paymentMethod.Valid {
-			pm.ID = int(paymentID.Int64)
-			pm.Method = paymentMethod.String
-			if paymentDate.Valid {
-				pm.Date = paymentDate.String
-			}
-			if paymentAmount.Valid {
-				pm.Amount = paymentAmount.Float64
-			}
-			if paymentComment.Valid {
-				pm.Comment = paymentComment.String
-			}
-			paymentsMap[o.ID][pm.ID] = pm
-		}
 	}
 
 	var orders []models.Order
@@ -145,54 +202,66 @@ func GetOrders(c *gin.Context) {
 		for _, p := range componentsMaps[id] {
 			articleRows, err := DB.Query("SELECT id, article, cursEvro, priceEvro, weight, count, sumEvro, sumRub FROM article_in_product WHERE product_id = $1", p.ID)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
+				return nil, err
 			}
 			for articleRows.Next() {
 				var article models.ArticleInProduct
 				if err := articleRows.Scan(&article.ID, &article.Article, &article.CursEvro, &article.PriceEvro, &article.Weight, &article.Count, &article.SumEvro, &article.SumRub); err != nil {
 					articleRows.Close()
-					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-					return
+					return nil, err
 				}
 				p.ArticlesInProduct = append(p.ArticlesInProduct, article)
 			}
 			articleRows.Close()
 			o.Components = append(o.Components, p)
 		}
-		for _, pm := range paymentsMap[id] {
-			o.Payments = append(o.Payments, pm)
+		payments, err := loadOrderPayments(DB, id)
+		if err != nil {
+			return nil, err
+		}
+		o.Payments = payments
+		adjustments, err := loadOrderAdjustments(DB, id)
+		if err != nil {
+			return nil, err
 		}
-		o.Debt = countDebt(o.Components, o.Payments)
+		o.Adjustments = adjustments
+		allocations, err := loadOrderAllocations(DB, id)
+		if err != nil {
+			return nil, err
+		}
+		o.Allocations = allocations
+		o.ProductsTotal = productsAmount(o.Components)
+		o.Total = orderTotal(o.Status, o.ProductsTotal, o.Adjustments)
+		o.Price = deliveryAmount(o.Adjustments)
+		o.Debt = o.Total - totalPaid(o.Payments) - totalAllocated(o.Allocations)
+		o.PendingPaid = pendingPaid(o.Payments, o.Allocations)
 		orders = append(orders, *o)
 	}
 
-	c.JSON(http.StatusOK, orders)
+	return orders, nil
 }
 
-func countDebt(component []models.Product, payments []models.Payment) float64 {
-	totalOrderAmount := 0.0
+// productsAmount суммирует цены товаров со скидками по мешкам.
+func productsAmount(component []models.Product) float64 {
+	total := 0.0
 	for _, product := range component {
 		price, err := parseAmount(product.SummaRubSoSkidkoj)
 		if err != nil {
 			log.Println("Failed to parse product price:", err)
 			continue
 		}
-		totalOrderAmount += price
-	}
-
-	totalPaid := 0.0
-	for _, payment := range payments {
-		totalPaid += payment.Amount
+		total += price
 	}
-
-	debt := totalOrderAmount - totalPaid
-	return debt
+	return total
 }
 
+// totalPaid — сумма оплат заказа без отклонённых.
 func totalPaid(payments []models.Payment) float64 {
 	total := 0.0
 	for _, payment := range payments {
+		if payment.Status == paymentStatusRejected {
+			continue
+		}
 		total += payment.Amount
 	}
 	return total
@@ -220,6 +289,11 @@ func countOrderAmountByProductIDs(tx *sql.Tx, productIDs []int) (float64, error)
 }
 
 func recalculateOrderDebt(tx *sql.Tx, orderID int) error {
+	var status int
+	if err := tx.QueryRow("SELECT status FROM orders WHERE id = $1", orderID).Scan(&status); err != nil {
+		return err
+	}
+
 	rows, err := tx.Query("SELECT product_id FROM order_products WHERE order_id = $1", orderID)
 	if err != nil {
 		return err
@@ -238,20 +312,21 @@ func recalculateOrderDebt(tx *sql.Tx, orderID int) error {
 		return err
 	}
 
-	totalOrderAmount, err := countOrderAmountByProductIDs(tx, productIDs)
+	productsTotal, err := countOrderAmountByProductIDs(tx, productIDs)
 	if err != nil {
 		return err
 	}
-
-	var totalPaid sql.NullFloat64
-	if err := tx.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM payments_monitoring WHERE order_id = $1", orderID).Scan(&totalPaid); err != nil {
+	adjustments, err := loadOrderAdjustments(tx, orderID)
+	if err != nil {
 		return err
 	}
+	totalOrderAmount := orderTotal(status, productsTotal, adjustments)
 
-	debt := totalOrderAmount
-	if totalPaid.Valid {
-		debt -= totalPaid.Float64
+	paid, err := orderPaidAmount(tx, orderID)
+	if err != nil {
+		return err
 	}
+	debt := totalOrderAmount - paid
 
 	_, err = tx.Exec("UPDATE orders SET quantity = $1, debt = $2 WHERE id = $3", len(productIDs), debt, orderID)
 	return err
@@ -313,7 +388,13 @@ func validateOrderProductSelection(tx *sql.Tx, orderID int, productIDs []int, ex
 		}
 
 		var linkedOrderID int
-		err := tx.QueryRow("SELECT order_id FROM order_products WHERE product_id = $1 LIMIT 1", productID).Scan(&linkedOrderID)
+		err := tx.QueryRow(`
+			SELECT op.order_id
+			FROM order_products op
+			JOIN orders o ON o.id = op.order_id
+			WHERE op.product_id = $1 AND o.status NOT IN (3, 4)
+			LIMIT 1
+		`, productID).Scan(&linkedOrderID)
 		if err != nil && err != sql.ErrNoRows {
 			return err
 		}
@@ -343,17 +424,31 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
+	if err := validateOrderAdjustments(order.Adjustments); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	shipDate, err := normalizeOptionalDateInput(order.ShipDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ship date"})
+		return
+	}
+	order.ShipDate = shipDate
+
 	for _, p := range order.Payments {
-		var exists bool
-		err := DB.QueryRow("SELECT EXISTS(SELECT 1 FROM payment_methods WHERE method = $1)", p.Method).Scan(&exists)
-		if err != nil || !exists {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid partial payment method: " + p.Method})
+		usable, err := paymentMethodUsable(DB, p.Method)
+		if err != nil || !usable {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or disabled partial payment method: " + p.Method})
 			return
 		}
-		if p.Amount <= 0 {
+		if p.Amount <= 0 && p.OriginalAmount <= 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Partial payment amount must be positive"})
 			return
 		}
+		if p.Type != "" && p.Type != paymentTypePayment {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Refunds are recorded via /orders/:id/refunds"})
+			return
+		}
 	}
 
 	tx, err := DB.Begin()
@@ -363,6 +458,14 @@ func CreateOrder(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
+	// Отгрузить новый заказ задним числом в закрытый период нельзя.
+	if order.Status == orderStatusShipped {
+		if err := checkPeriodOpen(tx, order.ShipDate); err != nil {
+			writeOrderError(c, err)
+			return
+		}
+	}
+
 	productIDs, err := collectUniqueProductIDs(order.Components)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -373,24 +476,38 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
+	clientID, delivery, err := prepareOrderClient(tx, &order)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
 	var orderID int
 	err = tx.QueryRow(`
 		INSERT INTO orders (
-			name, quantity, status, description, debt, ship_date, city, full_name, phone, passport_inn, tk, places, price, weight
+			name, quantity, status, description, debt, ship_date, city, full_name, phone, passport_inn, tk, places, weight, client_id
 		)
 		VALUES ($1, 0, $2, $3, 0, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 		RETURNING id
-	`, order.Name, order.Status, order.Description, order.ShipDate, order.City, order.FullName, order.Phone, order.PassportInn, order.TK, order.Places, order.Price, order.Weight).Scan(&orderID)
+	`, order.Name, order.Status, order.Description, nullableDate(order.ShipDate), delivery.City, delivery.FullName, delivery.Phone, delivery.PassportInn, delivery.TK, order.Places, order.Weight, clientID).Scan(&orderID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order: " + err.Error()})
 		return
 	}
 
+	if order.Adjustments == nil {
+		order.Adjustments = []models.OrderAdjustment{}
+	}
+	if err := replaceOrderAdjustments(tx, orderID, order.Adjustments); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save order adjustments: " + err.Error()})
+		return
+	}
+
 	log.Printf("\nOrder components: %v\n", order.Components)
 
 	targetProductStatus := productStatusForOrder(order.Status)
 	for _, product := range order.Components {
-		_, err := tx.Exec("UPDATE products SET status = $1 WHERE id = $2", targetProductStatus, product.ID)
+		_, err := tx.Exec("UPDATE products SET version = version + 1, status = $1 WHERE id = $2", targetProductStatus, product.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product status: " + err.Error()})
 			return
@@ -406,16 +523,39 @@ func CreateOrder(c *gin.Context) {
 
 	for i := range order.Payments {
 		p := &order.Payments[i]
-		paymentTimestamp := currentPaymentDateTime()
-		err := tx.QueryRow(
-			"INSERT INTO payments_monitoring (date, method, order_id, amount, comment) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-			paymentTimestamp, p.Method, orderID, p.Amount, p.Comment,
+		p.Type, p.Reason, p.ProductID = paymentTypePayment, "", nil
+		paymentTimestamp, err := normalizePaymentDateInput(p.Date)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment date format"})
+			return
+		}
+		p.Date = paymentTimestamp
+		if err := checkPeriodOpen(tx, paymentTimestamp); err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		if err := applyPaymentCurrency(tx, p); err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		if p.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Partial payment amount must be positive"})
+			return
+		}
+		err = tx.QueryRow(
+			`INSERT INTO payments_monitoring (date, method, order_id, amount, comment, currency, original_amount, rate)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+			paymentTimestamp, p.Method, orderID, p.Amount, p.Comment, p.Currency, p.OriginalAmount, p.Rate,
 		).Scan(&p.ID)
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Duplicate payments in the order: give them different comments"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert payment: " + err.Error()})
 			return
 		}
-		p.Date = paymentTimestamp
+		p.Date, p.Status = paymentTimestamp, paymentStatusPending
 		log.Printf("Платеж с ID %d с параметрами %v создан\n", p.ID, p)
 	}
 
@@ -425,7 +565,11 @@ func CreateOrder(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate order amount: " + err.Error()})
 		return
 	}
-	order.Debt = totalOrderAmount - totalPaid(order.Payments)
+	order.ProductsTotal = totalOrderAmount
+	order.Total = orderTotal(order.Status, totalOrderAmount, order.Adjustments)
+	order.Price = deliveryAmount(order.Adjustments)
+	order.Debt = order.Total - totalPaid(order.Payments)
+	order.PendingPaid = pendingPaid(order.Payments, nil)
 
 	_, err = tx.Exec("UPDATE orders SET quantity = $1, debt = $2 WHERE id = $3", order.Quantity, order.Debt, orderID)
 	if err != nil {
@@ -440,6 +584,8 @@ func CreateOrder(c *gin.Context) {
 	}
 
 	order.ID = int(orderID)
+	order.Version = 1
+	setETag(c, order.Version)
 	log.Printf("Order created with ID: %d\n", order.ID)
 	c.JSON(http.StatusOK, order)
 }
@@ -451,6 +597,10 @@ func UpdateOrder(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
 		return
 	}
+	expectedVersion, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
 	var order models.Order
 	if err := c.ShouldBindJSON(&order); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -461,18 +611,16 @@ func UpdateOrder(c *gin.Context) {
 		return
 	}
 
-	for _, p := range order.Payments {
-		var exists bool
-		err := DB.QueryRow("SELECT EXISTS(SELECT 1 FROM payment_methods WHERE method = $1)", p.Method).Scan(&exists)
-		if err != nil || !exists {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid partial payment method: " + p.Method})
-			return
-		}
-		if p.Amount <= 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Partial payment amount must be positive"})
-			return
-		}
+	if err := validateOrderAdjustments(order.Adjustments); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	shipDate, err := normalizeOptionalDateInput(order.ShipDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ship date"})
+		return
 	}
+	order.ShipDate = shipDate
 
 	tx, err := DB.Begin()
 	if err != nil {
@@ -482,7 +630,8 @@ func UpdateOrder(c *gin.Context) {
 	defer tx.Rollback()
 
 	var oldOrderStatus int
-	if err := tx.QueryRow("SELECT status FROM orders WHERE id = $1", id).Scan(&oldOrderStatus); err != nil {
+	var currentVersion int64
+	if err := tx.QueryRow("SELECT status, version FROM orders WHERE id = $1 FOR UPDATE", id).Scan(&oldOrderStatus, &currentVersion); err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 			return
@@ -490,6 +639,31 @@ func UpdateOrder(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch old order status: " + err.Error()})
 		return
 	}
+	if !versionMatches(expectedVersion, currentVersion) {
+		tx.Rollback()
+		current, err := LoadOrder(id)
+		if err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		writeVersionConflict(c, current.Version, current)
+		return
+	}
+	// Отгруженный заказ закрытого периода уже в отчётах; отгрузить задним числом в него тоже нельзя.
+	if err := checkShippedOrderPeriodOpen(tx, id); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if order.Status == orderStatusShipped {
+		if err := checkPeriodOpen(tx, order.ShipDate); err != nil {
+			writeOrderError(c, err)
+			return
+		}
+	}
+	if isClosedOrderStatus(oldOrderStatus) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is cancelled or returned and cannot be edited"})
+		return
+	}
 
 	var oldProductIDs []int
 	oldRows, err := tx.Query("SELECT product_id FROM order_products WHERE order_id = $1", id)
@@ -529,7 +703,7 @@ func UpdateOrder(c *gin.Context) {
 
 	for _, pid := range oldProductIDs {
 		if !newProductIDs[pid] {
-			_, err := tx.Exec("UPDATE products SET status = 1 WHERE id = $1", pid)
+			_, err := tx.Exec("UPDATE products SET version = version + 1, status = 1 WHERE id = $1", pid)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset product status: " + err.Error()})
 				return
@@ -547,7 +721,7 @@ func UpdateOrder(c *gin.Context) {
 			}
 		}
 		if isNew {
-			_, err := tx.Exec("UPDATE products SET status = $1 WHERE id = $2", targetProductStatus, p.ID)
+			_, err := tx.Exec("UPDATE products SET version = version + 1, status = $1 WHERE id = $2", targetProductStatus, p.ID)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product status: " + err.Error()})
 				return
@@ -557,7 +731,7 @@ func UpdateOrder(c *gin.Context) {
 
 	if oldOrderStatus != 2 && order.Status == 2 {
 		for _, p := range order.Components {
-			_, err := tx.Exec("UPDATE products SET status = 3 WHERE id = $1", p.ID)
+			_, err := tx.Exec("UPDATE products SET version = version + 1, status = 3 WHERE id = $1", p.ID)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark product as sold: " + err.Error()})
 				return
@@ -579,104 +753,68 @@ func UpdateOrder(c *gin.Context) {
 		}
 	}
 
-	existingPaymentIDs := map[int]struct{}{}
-	existingPaymentsRows, err := tx.Query("SELECT id FROM payments_monitoring WHERE order_id = $1", id)
+	// Оплаты меняются только через /orders/:id/payments, здесь они нужны лишь для долга.
+	dbPayments, err := loadOrderPayments(tx, id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch existing payments: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read payments for debt calculation: " + err.Error()})
 		return
 	}
-	for existingPaymentsRows.Next() {
-		var paymentID int
-		if err := existingPaymentsRows.Scan(&paymentID); err != nil {
-			existingPaymentsRows.Close()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse existing payments: " + err.Error()})
-			return
-		}
-		existingPaymentIDs[paymentID] = struct{}{}
-	}
-	existingPaymentsRows.Close()
 
-	keptPaymentIDs := map[int]struct{}{}
-	for i := range order.Payments {
-		p := &order.Payments[i]
-		paymentTimestamp := currentPaymentDateTime()
-		if p.ID > 0 {
-			res, err := tx.Exec("UPDATE payments_monitoring SET date = $1, method = $2, amount = $3, comment = $4 WHERE id = $5 AND order_id = $6",
-				paymentTimestamp, p.Method, p.Amount, p.Comment, p.ID, id)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment: " + err.Error()})
-				return
-			}
-			affected, err := res.RowsAffected()
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check updated payment rows: " + err.Error()})
-				return
-			}
-			if affected == 0 {
-				err := tx.QueryRow("INSERT INTO payments_monitoring (date, method, order_id, amount, comment) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-					paymentTimestamp, p.Method, id, p.Amount, p.Comment).Scan(&p.ID)
-				if err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert payment for order: " + err.Error()})
-					return
-				}
-			}
-			p.Date = paymentTimestamp
-		} else {
-			err := tx.QueryRow("INSERT INTO payments_monitoring (date, method, order_id, amount, comment) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-				paymentTimestamp, p.Method, id, p.Amount, p.Comment).Scan(&p.ID)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert new payment: " + err.Error()})
-				return
-			}
-			p.Date = paymentTimestamp
-		}
-		keptPaymentIDs[p.ID] = struct{}{}
+	order.Quantity = len(order.Components)
+	totalOrderAmount, err := countOrderAmountByProductIDs(tx, productIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate order amount: " + err.Error()})
+		return
 	}
-
-	for existingID := range existingPaymentIDs {
-		if _, keep := keptPaymentIDs[existingID]; keep {
-			continue
+	// Без поля adjustments в запросе корректировки заказа остаются прежними.
+	if order.Adjustments != nil {
+		if err := replaceOrderAdjustments(tx, id, order.Adjustments); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save order adjustments: " + err.Error()})
+			return
 		}
-		if _, err := tx.Exec("DELETE FROM payments_monitoring WHERE id = $1 AND order_id = $2", existingID, id); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete stale payment: " + err.Error()})
+	} else {
+		order.Adjustments, err = loadOrderAdjustments(tx, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read order adjustments: " + err.Error()})
 			return
 		}
 	}
+	order.ProductsTotal = totalOrderAmount
+	order.Total = orderTotal(order.Status, totalOrderAmount, order.Adjustments)
+	order.Price = deliveryAmount(order.Adjustments)
 
-	dbPaymentsRows, err := tx.Query("SELECT id, date, method, amount, comment FROM payments_monitoring WHERE order_id = $1", id)
+	clientID, delivery, err := prepareOrderClient(tx, &order)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read payments for debt calculation: " + err.Error()})
+		writeOrderError(c, err)
 		return
 	}
-	var dbPayments []models.Payment
-	for dbPaymentsRows.Next() {
-		var pm models.Payment
-		if err := dbPaymentsRows.Scan(&pm.ID, &pm.Date, &pm.Method, &pm.Amount, &pm.Comment); err != nil {
-			dbPaymentsRows.Close()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse payments for debt calculation: " + err.Error()})
-			return
-		}
-		dbPayments = append(dbPayments, pm)
+	// Деньги другого клиента в заказе не остаются: при смене клиента распределения возвращаются на его счёт.
+	if _, err := tx.Exec(`
+		DELETE FROM payment_allocations a USING payments_monitoring pm
+		WHERE a.payment_id = pm.id AND a.order_id = $1 AND pm.client_id IS DISTINCT FROM $2
+	`, id, clientID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release payment allocations: " + err.Error()})
+		return
 	}
-	dbPaymentsRows.Close()
-
-	order.Quantity = len(order.Components)
-	totalOrderAmount, err := countOrderAmountByProductIDs(tx, productIDs)
+	order.Allocations, err = loadOrderAllocations(tx, id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate order amount: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read payment allocations: " + err.Error()})
 		return
 	}
-	order.Debt = totalOrderAmount - totalPaid(dbPayments)
+	order.Debt = order.Total - totalPaid(dbPayments) - totalAllocated(order.Allocations)
+	order.PendingPaid = pendingPaid(dbPayments, order.Allocations)
 
-	_, err = tx.Exec(
+	err = tx.QueryRow(
 		`UPDATE orders
 		SET name = $1, quantity = $2, status = $3, description = $4, debt = $5,
-			ship_date = $6, city = $7, full_name = $8, phone = $9, passport_inn = $10, tk = $11, places = $12, price = $13, weight = $14
-		WHERE id = $15`,
+			ship_date = $6, city = $7, full_name = $8, phone = $9, passport_inn = $10, tk = $11, places = $12, weight = $13,
+			client_id = $14, version = version + 1
+		WHERE id = $15
+		RETURNING version`,
 		order.Name, order.Quantity, order.Status, order.Description, order.Debt,
-		order.ShipDate, order.City, order.FullName, order.Phone, order.PassportInn, order.TK, order.Places, order.Price, order.Weight,
-		id,
-	)
+		nullableDate(order.ShipDate), delivery.City, delivery.FullName, delivery.Phone, delivery.PassportInn, delivery.TK, order.Places, order.Weight,
+		clientID, id,
+	).Scan(&order.Version)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order: " + err.Error()})
 		return
@@ -689,12 +827,28 @@ func UpdateOrder(c *gin.Context) {
 	}
 	order.ID = id
 	order.Payments = dbPayments
+	setETag(c, order.Version)
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Order updated",
 		"order":   order,
 	})
 }
 
+// releaseOrderProducts возвращает товары заказа в продажу (статус 1) и отдаёт их ID.
+func releaseOrderProducts(tx *sql.Tx, orderID int) ([]int, error) {
+	productIDs, err := orderProductIDs(tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pid := range productIDs {
+		if _, err := tx.Exec("UPDATE products SET version = version + 1, status = 1 WHERE id = $1", pid); err != nil {
+			return nil, err
+		}
+	}
+	return productIDs, nil
+}
+
 func DeleteOrder(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -710,28 +864,24 @@ func DeleteOrder(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	var productIDs []int
-	rows, err := tx.Query("SELECT product_id FROM order_products WHERE order_id = $1", id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch components: " + err.Error()})
+	if err := checkShippedOrderPeriodOpen(tx, id); err != nil {
+		writeOrderError(c, err)
 		return
 	}
-	for rows.Next() {
-		var pid int
-		if err := rows.Scan(&pid); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		productIDs = append(productIDs, pid)
+
+	var hasPayments bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM payments_monitoring WHERE order_id = $1)", id).Scan(&hasPayments); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if hasPayments {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has payments: cancel or return it instead of deleting"})
+		return
 	}
-	rows.Close()
 
-	for _, pid := range productIDs {
-		_, err = tx.Exec("UPDATE products SET status = 1 WHERE id = $1", pid)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset product status: " + err.Error()})
-			return
-		}
+	if _, err := releaseOrderProducts(tx, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset product status: " + err.Error()})
+		return
 	}
 
 	_, err = tx.Exec("DELETE FROM orders WHERE id = $1", id)
@@ -745,5 +895,6 @@ func DeleteOrder(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
 		return
 	}
+	purgeEntityAttachments(AttachmentOrder, id)
 	c.JSON(http.StatusOK, gin.H{"message": "Order deleted"})
 }
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/Talonmortem/SHM/internal/models"
+)
+
+// Виды корректировок заказа. Скидки уменьшают сумму, доставка и упаковка увеличивают.
+const (
+	adjustmentDiscountPercent = "discount_percent" // процент от суммы товаров
+	adjustmentDiscountFixed   = "discount_fixed"
+	adjustmentDelivery        = "delivery" // доставка до ТК
+	adjustmentPackaging       = "packaging"
+)
+
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func CreateTablesOrderAdjustments() {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS order_adjustments (
+			id BIGSERIAL PRIMARY KEY,
+			order_id BIGINT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			amount DOUBLE PRECISION NOT NULL DEFAULT 0,
+			comment TEXT NOT NULL DEFAULT ''
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_order_adjustments_order_id ON order_adjustments(order_id);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create order adjustments table:", err)
+	}
+	log.Println("Order adjustments table is ready")
+}
+
+func validateOrderAdjustments(adjustments []models.OrderAdjustment) error {
+	percent := 0.0
+	for i := range adjustments {
+		adj := &adjustments[i]
+		adj.Kind = strings.ToLower(strings.TrimSpace(adj.Kind))
+		adj.Comment = strings.TrimSpace(adj.Comment)
+		switch adj.Kind {
+		case adjustmentDiscountPercent:
+			percent += adj.Amount
+		case adjustmentDiscountFixed, adjustmentDelivery, adjustmentPackaging:
+		default:
+			return newBadRequestError(fmt.Sprintf("Unknown adjustment kind %q: expected discount_percent, discount_fixed, delivery or packaging", adj.Kind))
+		}
+		if adj.Amount < 0 {
+			return newBadRequestError("Adjustment amount must not be negative")
+		}
+	}
+	if percent > 100 {
+		return newBadRequestError("Total discount percent must not exceed 100")
+	}
+	return nil
+}
+
+// applyOrderAdjustments заполняет Value каждой корректировки (влияние на сумму в рублях, со знаком)
+// и возвращает сумму заказа. Процентная скидка считается от суммы товаров.
+func applyOrderAdjustments(productsTotal float64, adjustments []models.OrderAdjustment) float64 {
+	total := productsTotal
+	for i := range adjustments {
+		adj := &adjustments[i]
+		switch adj.Kind {
+		case adjustmentDiscountPercent:
+			adj.Value = -math.Round(productsTotal*adj.Amount) / 100
+		case adjustmentDiscountFixed:
+			adj.Value = -adj.Amount
+		default:
+			adj.Value = adj.Amount
+		}
+		total += adj.Value
+	}
+	return total
+}
+
+// orderTotal — сумма к оплате по заказу. Отменённый или возвращённый заказ ничего не стоит.
+func orderTotal(status int, productsTotal float64, adjustments []models.OrderAdjustment) float64 {
+	total := applyOrderAdjustments(productsTotal, adjustments)
+	if isClosedOrderStatus(status) {
+		return 0
+	}
+	return total
+}
+
+// deliveryAmount — стоимость доставки заказа: сумма корректировок delivery. Её отдаёт поле price.
+func deliveryAmount(adjustments []models.OrderAdjustment) float64 {
+	total := 0.0
+	for _, adj := range adjustments {
+		if adj.Kind == adjustmentDelivery {
+			total += adj.Amount
+		}
+	}
+	return total
+}
+
+// orderPriceTolerance — расхождение с суммой товаров, которое считается округлением, а не доставкой.
+const orderPriceTolerance = 1.0
+
+// MigrateOrderPriceResult — итог переноса orders.price. Locked и Pending — номера заказов.
+type MigrateOrderPriceResult struct {
+	Moved          int   // создано корректировок доставки
+	SameAsProducts int   // price совпадал с суммой товаров: это автозаполнение формы, не доставка
+	Locked         []int // заказы закрытого периода: не тронуты
+	Pending        []int // ещё не перенесены (при пробном запуске — все, что были бы перенесены)
+}
+
+// MigrateOrderPrice переносит старое поле orders.price («цена отправки») в корректировку доставки
+// «Цена отправки» и пересчитывает долг. Запускается вручную (cmd/migrate-order-price): без apply
+// только показывает, что будет перенесено. Форма заказа подставляла в price сумму товаров — такое
+// значение не доставка и не переносится. Заказы закрытого периода не меняются. Сам столбец остаётся:
+// его удаляет DropOrderPriceColumn после проверки.
+func MigrateOrderPrice(apply bool) (MigrateOrderPriceResult, error) {
+	var result MigrateOrderPriceResult
+	exists, err := orderPriceColumnExists()
+	if err != nil || !exists {
+		return result, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	candidates, err := orderPriceCandidates(tx)
+	if err != nil {
+		return result, err
+	}
+	for _, candidate := range candidates {
+		if err := checkShippedOrderPeriodOpen(tx, candidate.orderID); err != nil {
+			var closed *periodClosedError
+			if !errors.As(err, &closed) {
+				return result, err
+			}
+			log.Printf("Order %d: price %.2f is in a closed period, skipped", candidate.orderID, candidate.price)
+			result.Locked = append(result.Locked, candidate.orderID)
+			continue
+		}
+		if math.Abs(candidate.price-candidate.productsTotal) < orderPriceTolerance {
+			result.SameAsProducts++
+			continue
+		}
+		log.Printf("Order %d: price %.2f, products %.2f -> delivery %.2f", candidate.orderID, candidate.price, candidate.productsTotal, roundMoney(candidate.price))
+		if !apply {
+			result.Pending = append(result.Pending, candidate.orderID)
+			continue
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO order_adjustments (order_id, kind, amount, comment) VALUES ($1, $2, $3, $4)",
+			candidate.orderID, adjustmentDelivery, roundMoney(candidate.price), "Цена отправки",
+		); err != nil {
+			return result, err
+		}
+		if err := recalculateOrderDebt(tx, candidate.orderID); err != nil {
+			return result, err
+		}
+		result.Moved++
+	}
+	if !apply {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+// DropOrderPriceColumn удаляет orders.price, когда переносить больше нечего. Заказы закрытого
+// периода с отличной от товаров ценой удаление не пропускают: сначала откройте период.
+func DropOrderPriceColumn() error {
+	exists, err := orderPriceColumnExists()
+	if err != nil || !exists {
+		return err
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	candidates, err := orderPriceCandidates(tx)
+	if err != nil {
+		return err
+	}
+	var left []int
+	for _, candidate := range candidates {
+		if math.Abs(candidate.price-candidate.productsTotal) >= orderPriceTolerance {
+			left = append(left, candidate.orderID)
+		}
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("orders.price is not migrated for orders %v", left)
+	}
+	if _, err := tx.Exec("ALTER TABLE orders DROP COLUMN price"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func orderPriceColumnExists() (bool, error) {
+	var exists bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM information_schema.columns WHERE table_name = 'orders' AND column_name = 'price')
+	`).Scan(&exists)
+	return exists, err
+}
+
+type orderPriceCandidate struct {
+	orderID       int
+	price         float64
+	productsTotal float64
+}
+
+// orderPriceCandidates — заказы с ценой отправки и без корректировки доставки.
+func orderPriceCandidates(tx *sql.Tx) ([]orderPriceCandidate, error) {
+	rows, err := tx.Query(`
+		SELECT o.id, o.price FROM orders o
+		WHERE o.price > 0
+			AND NOT EXISTS (SELECT 1 FROM order_adjustments oa WHERE oa.order_id = o.id AND oa.kind = $1)
+		ORDER BY o.id
+	`, adjustmentDelivery)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []orderPriceCandidate
+	for rows.Next() {
+		var candidate orderPriceCandidate
+		if err := rows.Scan(&candidate.orderID, &candidate.price); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range candidates {
+		productIDs, err := orderProductIDs(tx, candidates[i].orderID)
+		if err != nil {
+			return nil, err
+		}
+		if candidates[i].productsTotal, err = countOrderAmountByProductIDs(tx, productIDs); err != nil {
+			return nil, err
+		}
+	}
+	return candidates, nil
+}
+
+func loadOrderAdjustments(q queryer, orderID int) ([]models.OrderAdjustment, error) {
+	rows, err := q.Query("SELECT id, kind, amount, comment FROM order_adjustments WHERE order_id = $1 ORDER BY id", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adjustments := make([]models.OrderAdjustment, 0)
+	for rows.Next() {
+		var adj models.OrderAdjustment
+		if err := rows.Scan(&adj.ID, &adj.Kind, &adj.Amount, &adj.Comment); err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, adj)
+	}
+	return adjustments, rows.Err()
+}
+
+// replaceOrderAdjustments заменяет корректировки заказа переданным списком.
+func replaceOrderAdjustments(tx *sql.Tx, orderID int, adjustments []models.OrderAdjustment) error {
+	if _, err := tx.Exec("DELETE FROM order_adjustments WHERE order_id = $1", orderID); err != nil {
+		return err
+	}
+	for i := range adjustments {
+		adj := &adjustments[i]
+		if err := tx.QueryRow(
+			"INSERT INTO order_adjustments (order_id, kind, amount, comment) VALUES ($1, $2, $3, $4) RETURNING id",
+			orderID, adj.Kind, adj.Amount, adj.Comment,
+		).Scan(&adj.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Статусы заказа. 3 и 4 — закрытые: заказ и история оплат сохраняются, в сумму
+// к оплате заказ больше не входит.
+const (
+	orderStatusNew       = 0
+	orderStatusReady     = 1
+	orderStatusShipped   = 2
+	orderStatusCancelled = 3
+	orderStatusReturned  = 4
+)
+
+// Что делать с уже внесёнными деньгами при отмене/возврате.
+const (
+	paymentResolutionRefund = "refund" // деньги нужно вернуть клиенту
+	paymentResolutionCredit = "credit" // деньги остаются у клиента на балансе
+)
+
+type closeOrderRequest struct {
+	PaymentResolution string `json:"payment_resolution"`
+	Reason            string `json:"reason"`
+}
+
+func isClosedOrderStatus(status int) bool {
+	return status == orderStatusCancelled || status == orderStatusReturned
+}
+
+func writeOrderError(c *gin.Context, err error) {
+	var badReq *badRequestError
+	if errors.As(err, &badReq) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": badReq.message})
+		return
+	}
+	var closed *periodClosedError
+	if errors.As(err, &closed) {
+		c.JSON(http.StatusConflict, gin.H{"error": closed.Error()})
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	log.Printf("order handler error: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// closeOrder переводит заказ в статус Отменён или Возврат, долг пересчитывается без стоимости
+// товаров. При отмене товары возвращаются в статус 1. При возврате мешки остаются проданными:
+// в продажу или на разбор они попадают только при оприходовании возврата (ReceiveReturn).
+func closeOrder(tx *sql.Tx, orderID, status int, resolution, reason string) error {
+	var oldStatus int
+	if err := tx.QueryRow("SELECT status FROM orders WHERE id = $1 FOR UPDATE", orderID).Scan(&oldStatus); err != nil {
+		return err
+	}
+	if err := checkShippedOrderPeriodOpen(tx, orderID); err != nil {
+		return err
+	}
+
+	switch status {
+	case orderStatusCancelled:
+		if oldStatus != orderStatusNew && oldStatus != orderStatusReady {
+			return newBadRequestError("Only new or ready orders can be cancelled")
+		}
+	case orderStatusReturned:
+		if oldStatus != orderStatusShipped {
+			return newBadRequestError("Only shipped orders can be returned")
+		}
+	default:
+		return fmt.Errorf("unexpected closing status %d", status)
+	}
+
+	paid, err := orderPaidAmount(tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	resolution = strings.ToLower(strings.TrimSpace(resolution))
+	if paid != 0 {
+		if resolution != paymentResolutionRefund && resolution != paymentResolutionCredit {
+			return newBadRequestError("Order has payments: payment_resolution must be refund or credit")
+		}
+	} else {
+		resolution = ""
+	}
+
+	if status == orderStatusCancelled {
+		if _, err := releaseOrderProducts(tx, orderID); err != nil {
+			return err
+		}
+	}
+	// При зачёте распределённые в заказ клиентские оплаты возвращаются на баланс клиента.
+	if resolution == paymentResolutionCredit {
+		if err := releaseOrderAllocations(tx, orderID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE orders
+		SET status = $1, version = version + 1, payment_resolution = NULLIF($2, ''), close_reason = $3, closed_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+	`, status, resolution, strings.TrimSpace(reason), orderID); err != nil {
+		return err
+	}
+
+	return recalculateOrderDebt(tx, orderID)
+}
+
+func closeOrderHandler(c *gin.Context, status int) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req closeOrderRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := closeOrder(tx, id, status, req.PaymentResolution, req.Reason); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Order closed", "id": id, "status": status})
+}
+
+func CancelOrder(c *gin.Context) {
+	closeOrderHandler(c, orderStatusCancelled)
+}
+
+func ReturnOrder(c *gin.Context) {
+	closeOrderHandler(c, orderStatusReturned)
+}
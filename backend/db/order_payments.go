@@ -0,0 +1,364 @@
+package db
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Оплаты заказа редактируются только через /orders/:id/payments: дата оплаты задаётся
+// пользователем и не меняется при правке других полей заказа.
+
+func loadOrderPayments(q queryer, orderID int) ([]models.Payment, error) {
+	rows, err := q.Query(`
+		SELECT id, COALESCE(to_char(date, 'YYYY-MM-DD HH24:MI:SS'), ''), COALESCE(method, ''), COALESCE(amount, 0), COALESCE(comment, ''), type, reason, product_id,
+			currency, COALESCE(original_amount, amount, 0), rate, `+paymentStatusColumns+`
+		FROM payments_monitoring
+		WHERE order_id = $1
+		ORDER BY date, id
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := make([]models.Payment, 0)
+	for rows.Next() {
+		pm := models.Payment{OrderID: orderID}
+		var productID sql.NullInt64
+		dest := []any{&pm.ID, &pm.Date, &pm.Method, &pm.Amount, &pm.Comment, &pm.Type, &pm.Reason, &productID,
+			&pm.Currency, &pm.OriginalAmount, &pm.Rate}
+		if err := rows.Scan(append(dest, scanPaymentStatus(&pm)...)...); err != nil {
+			return nil, err
+		}
+		if productID.Valid {
+			id := int(productID.Int64)
+			pm.ProductID = &id
+		}
+		payments = append(payments, pm)
+	}
+	return payments, rows.Err()
+}
+
+// validateOrderPayment проверяет способ, тип и сумму оплаты, приводит дату к общему формату
+// и пересчитывает сумму в рубли.
+// Пустая дата допускается только для новой оплаты и означает «сейчас».
+// previousMethod — способ правимой оплаты: его можно оставить, даже если он уже отключён.
+// Возврат возможен только по заказу (p.OrderID).
+func validateOrderPayment(tx *sql.Tx, p *models.Payment, previousMethod string) error {
+	p.Method = strings.TrimSpace(p.Method)
+	if p.Method == "" || p.Method != previousMethod {
+		usable, err := paymentMethodUsable(tx, p.Method)
+		if err != nil {
+			return err
+		}
+		if !usable {
+			return newBadRequestError("Invalid or disabled payment method: " + p.Method)
+		}
+	}
+
+	date, err := normalizePaymentDateInput(p.Date)
+	if err != nil {
+		return newBadRequestError("Invalid payment date format")
+	}
+	p.Date = date
+
+	if err := applyPaymentCurrency(tx, p); err != nil {
+		return err
+	}
+	if err := normalizePaymentType(p); err != nil {
+		return err
+	}
+	if p.Type == paymentTypeRefund {
+		if p.OrderID == 0 {
+			return newBadRequestError("Refunds can only be recorded against an order")
+		}
+		if err := validateRefundProduct(tx, p.OrderID, p.ProductID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func orderPaymentParams(c *gin.Context) (int, int, bool) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return 0, 0, false
+	}
+	paymentID := 0
+	if raw := c.Param("payment_id"); raw != "" {
+		paymentID, err = strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+			return 0, 0, false
+		}
+	}
+	return orderID, paymentID, true
+}
+
+// lockOrder блокирует строку заказа до конца транзакции и проверяет, что заказ существует.
+func lockOrder(tx *sql.Tx, orderID int) error {
+	var id int
+	return tx.QueryRow("SELECT id FROM orders WHERE id = $1 FOR UPDATE", orderID).Scan(&id)
+}
+
+func GetOrderPayments(c *gin.Context) {
+	orderID, _, ok := orderPaymentParams(c)
+	if !ok {
+		return
+	}
+
+	var exists bool
+	if err := DB.QueryRow("SELECT EXISTS(SELECT 1 FROM orders WHERE id = $1)", orderID).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	payments, err := loadOrderPayments(DB, orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, payments)
+}
+
+func CreateOrderPayment(c *gin.Context) {
+	createOrderPayment(c, paymentTypePayment)
+}
+
+// UpdateOrderPayment меняет оплату заказа. Если дата не передана, остаётся прежняя.
+func UpdateOrderPayment(c *gin.Context) {
+	updateOrderPayment(c, paymentTypePayment)
+}
+
+func DeleteOrderPayment(c *gin.Context) {
+	deleteOrderPayment(c, paymentTypePayment)
+}
+
+// wrongPaymentTypeError — оплату и возврат нельзя менять через ресурс другого типа.
+func wrongPaymentTypeError(paymentType string) error {
+	if paymentType == paymentTypeRefund {
+		return newBadRequestError("This is a payment: use /orders/:id/payments")
+	}
+	return newBadRequestError("This is a refund: use /orders/:id/refunds")
+}
+
+func createOrderPayment(c *gin.Context, paymentType string) {
+	orderID, _, ok := orderPaymentParams(c)
+	if !ok {
+		return
+	}
+
+	var payment models.Payment
+	if err := c.ShouldBindJSON(&payment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockOrder(tx, orderID); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	payment.OrderID = orderID
+	payment.Type = paymentType
+	if err := validateOrderPayment(tx, &payment, ""); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if err := checkPeriodOpen(tx, payment.Date); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	if err := tx.QueryRow(
+		`INSERT INTO payments_monitoring (date, method, order_id, amount, comment, type, reason, product_id, currency, original_amount, rate)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		payment.Date, payment.Method, orderID, payment.Amount, payment.Comment, payment.Type, payment.Reason, payment.ProductID,
+		payment.Currency, payment.OriginalAmount, payment.Rate,
+	).Scan(&payment.ID); err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "The order already has the same payment: change its comment to add another one"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert payment: " + err.Error()})
+		return
+	}
+	// Возврат от того, кто может подтверждать оплаты (manager/admin), подтверждён сразу.
+	payment.Status = paymentStatusPending
+	confirm := false
+	if payment.Type == paymentTypeRefund {
+		if confirm, err = mayConfirmPayments(tx, c.GetInt("role_id")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm refund: " + err.Error()})
+			return
+		}
+	}
+	if confirm {
+		if err := markPaymentConfirmed(tx, payment.ID, c.GetString("username")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm refund: " + err.Error()})
+			return
+		}
+		payment.Status, payment.ConfirmedBy = paymentStatusConfirmed, c.GetString("username")
+	}
+	if err := recalculateOrderDebt(tx, orderID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate order debt: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, payment)
+}
+
+func updateOrderPayment(c *gin.Context, paymentType string) {
+	orderID, paymentID, ok := orderPaymentParams(c)
+	if !ok {
+		return
+	}
+
+	var payment models.Payment
+	if err := c.ShouldBindJSON(&payment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockOrder(tx, orderID); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	var oldDate, oldMethod sql.NullString
+	var oldType string
+	var oldRate float64
+	if err := tx.QueryRow(
+		"SELECT to_char(date, 'YYYY-MM-DD HH24:MI:SS'), method, type, rate FROM payments_monitoring WHERE id = $1 AND order_id = $2", paymentID, orderID,
+	).Scan(&oldDate, &oldMethod, &oldType, &oldRate); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if oldType != paymentType {
+		writeOrderError(c, wrongPaymentTypeError(paymentType))
+		return
+	}
+	if strings.TrimSpace(payment.Date) == "" {
+		payment.Date = oldDate.String
+	}
+	payment.OrderID = orderID
+	payment.Type = paymentType
+	keepPaymentRate(&payment, oldMethod.String, oldDate.String, oldRate)
+	if err := validateOrderPayment(tx, &payment, oldMethod.String); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if err := checkPeriodOpen(tx, oldDate.String, payment.Date); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE payments_monitoring SET date = $1, method = $2, amount = $3, comment = $4, reason = $5, product_id = $6,
+			currency = $7, original_amount = $8, rate = $9, `+paymentReviewReset("$1", "$2", "$3")+`
+		WHERE id = $10 AND order_id = $11`,
+		payment.Date, payment.Method, payment.Amount, payment.Comment, payment.Reason, payment.ProductID,
+		payment.Currency, payment.OriginalAmount, payment.Rate, paymentID, orderID,
+	); err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "The order already has the same payment: change its comment"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment: " + err.Error()})
+		return
+	}
+	if err := recalculateOrderDebt(tx, orderID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate order debt: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+	payment.ID = paymentID
+	c.JSON(http.StatusOK, payment)
+}
+
+func deleteOrderPayment(c *gin.Context, paymentType string) {
+	orderID, paymentID, ok := orderPaymentParams(c)
+	if !ok {
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockOrder(tx, orderID); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	var oldType string
+	if err := tx.QueryRow("SELECT type FROM payments_monitoring WHERE id = $1 AND order_id = $2", paymentID, orderID).Scan(&oldType); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if oldType != paymentType {
+		writeOrderError(c, wrongPaymentTypeError(paymentType))
+		return
+	}
+	if err := checkPaymentPeriodOpen(tx, paymentID); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM payments_monitoring WHERE id = $1 AND order_id = $2", paymentID, orderID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payment: " + err.Error()})
+		return
+	}
+	if err := recalculateOrderDebt(tx, orderID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate order debt: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+	purgeEntityAttachments(AttachmentPayment, paymentID)
+	c.JSON(http.StatusOK, gin.H{"message": "Payment deleted"})
+}
@@ -0,0 +1,346 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Разделение и объединение работают только с неотгруженными заказами (статусы 0 и 1):
+// товары в них ещё в брони, статус товаров при переносе не меняется.
+
+type splitOrderRequest struct {
+	ProductIDs []int  `json:"product_ids"`
+	PaymentIDs []int  `json:"payment_ids"`
+	Name       string `json:"name"`
+}
+
+type mergeOrdersRequest struct {
+	TargetID  int   `json:"target_id"`
+	SourceIDs []int `json:"source_ids"`
+}
+
+type lockedOrder struct {
+	ID       int
+	Name     string
+	Status   int
+	ClientID sql.NullInt64
+}
+
+func lockOpenOrder(tx *sql.Tx, orderID int) (lockedOrder, error) {
+	order := lockedOrder{ID: orderID}
+	var name sql.NullString
+	if err := tx.QueryRow("SELECT name, status, client_id FROM orders WHERE id = $1 FOR UPDATE", orderID).Scan(&name, &order.Status, &order.ClientID); err != nil {
+		return order, err
+	}
+	order.Name = name.String
+	if order.Status != orderStatusNew && order.Status != orderStatusReady {
+		return order, newBadRequestError(fmt.Sprintf("Order %d is shipped or closed: only new and ready orders can be split or merged", orderID))
+	}
+	return order, nil
+}
+
+// finishOrderChange пересчитывает долг и поднимает версию, чтобы открытые формы заказа получили 409.
+func finishOrderChange(tx *sql.Tx, orderID int) error {
+	if _, err := tx.Exec("UPDATE orders SET version = version + 1 WHERE id = $1", orderID); err != nil {
+		return err
+	}
+	return recalculateOrderDebt(tx, orderID)
+}
+
+// moveOrderPayment переносит оплату, не нарушая уникальность (order_id, method, amount, comment).
+// Оплату из закрытого периода переносить нельзя.
+func moveOrderPayment(tx *sql.Tx, paymentID, fromOrderID, toOrderID int) error {
+	if err := checkPaymentPeriodOpen(tx, paymentID); err != nil {
+		return err
+	}
+	var duplicate bool
+	err := tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM payments_monitoring moved
+			JOIN payments_monitoring existing
+				ON existing.order_id = $3
+				AND COALESCE(existing.method, '') = COALESCE(moved.method, '')
+				AND existing.amount = moved.amount
+				AND COALESCE(existing.comment, '') = COALESCE(moved.comment, '')
+			WHERE moved.id = $1 AND moved.order_id = $2
+		)
+	`, paymentID, fromOrderID, toOrderID).Scan(&duplicate)
+	if err != nil {
+		return err
+	}
+	if duplicate {
+		return newBadRequestError(fmt.Sprintf("Order %d already has the same payment as payment %d: change its comment first", toOrderID, paymentID))
+	}
+
+	result, err := tx.Exec("UPDATE payments_monitoring SET order_id = $1 WHERE id = $2 AND order_id = $3", toOrderID, paymentID, fromOrderID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return newBadRequestError(fmt.Sprintf("Payment %d does not belong to order %d", paymentID, fromOrderID))
+	}
+	return nil
+}
+
+// SplitOrder переносит выбранные товары и, по желанию, оплаты в новый заказ с теми же
+// данными клиента и доставки. Корректировки (скидки, доставка) остаются у исходного заказа.
+func SplitOrder(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req splitOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.ProductIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "product_ids must not be empty"})
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	source, err := lockOpenOrder(tx, id)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	orderProducts, err := orderProductIDs(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	inOrder := make(map[int]bool, len(orderProducts))
+	for _, pid := range orderProducts {
+		inOrder[pid] = true
+	}
+	moving := make(map[int]bool, len(req.ProductIDs))
+	for _, pid := range req.ProductIDs {
+		if !inOrder[pid] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Product %d is not in order %d", pid, id)})
+			return
+		}
+		moving[pid] = true
+	}
+	if len(moving) == len(orderProducts) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one product must stay in the original order"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = strings.TrimSpace(source.Name + " (часть)")
+	}
+
+	var newID int
+	err = tx.QueryRow(`
+		INSERT INTO orders (
+			name, quantity, status, description, debt, ship_date, city, full_name, phone, passport_inn, tk, places, weight, client_id
+		)
+		SELECT $1, 0, status, description, 0, ship_date, city, full_name, phone, passport_inn, tk, 0, 0, client_id
+		FROM orders WHERE id = $2
+		RETURNING id
+	`, name, id).Scan(&newID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order: " + err.Error()})
+		return
+	}
+
+	for pid := range moving {
+		if _, err := tx.Exec("UPDATE order_products SET order_id = $1 WHERE order_id = $2 AND product_id = $3", newID, id, pid); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move product: " + err.Error()})
+			return
+		}
+	}
+	for _, paymentID := range req.PaymentIDs {
+		if err := moveOrderPayment(tx, paymentID, id, newID); err != nil {
+			writeOrderError(c, err)
+			return
+		}
+	}
+
+	for _, orderID := range []int{id, newID} {
+		if err := finishOrderChange(tx, orderID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate order debt: " + err.Error()})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+
+	respondOrders(c, map[string]int{"order": id, "new_order": newID})
+}
+
+// MergeOrders переносит товары, оплаты и корректировки исходных заказов в целевой.
+// Процентная скидка исходного заказа переносится фиксированной суммой, посчитанной от его товаров,
+// чтобы не распространиться на чужие мешки. Исходные заказы остаются отменёнными со ссылкой на целевой.
+func MergeOrders(c *gin.Context) {
+	var req mergeOrdersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TargetID <= 0 || len(req.SourceIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target_id and source_ids are required"})
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	target, err := lockOpenOrder(tx, req.TargetID)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	seen := map[int]bool{req.TargetID: true}
+	for _, sourceID := range req.SourceIDs {
+		if seen[sourceID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Order %d is listed twice", sourceID)})
+			return
+		}
+		seen[sourceID] = true
+
+		source, err := lockOpenOrder(tx, sourceID)
+		if err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		if source.Status != target.Status {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Order %d has a different status than order %d", sourceID, req.TargetID)})
+			return
+		}
+		if source.ClientID.Valid && target.ClientID.Valid && source.ClientID.Int64 != target.ClientID.Int64 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Order %d belongs to another client", sourceID)})
+			return
+		}
+
+		if err := mergeOrderInto(tx, sourceID, req.TargetID); err != nil {
+			writeOrderError(c, err)
+			return
+		}
+	}
+
+	if err := finishOrderChange(tx, req.TargetID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate order debt: " + err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+
+	respondOrders(c, map[string]int{"order": req.TargetID})
+}
+
+func mergeOrderInto(tx *sql.Tx, sourceID, targetID int) error {
+	productIDs, err := orderProductIDs(tx, sourceID)
+	if err != nil {
+		return err
+	}
+	productsTotal, err := countOrderAmountByProductIDs(tx, productIDs)
+	if err != nil {
+		return err
+	}
+	adjustments, err := loadOrderAdjustments(tx, sourceID)
+	if err != nil {
+		return err
+	}
+	applyOrderAdjustments(productsTotal, adjustments)
+	for _, adj := range adjustments {
+		if adj.Kind == adjustmentDiscountPercent {
+			comment := fmt.Sprintf("Скидка %s%% из заказа №%d", strconv.FormatFloat(adj.Amount, 'f', -1, 64), sourceID)
+			if adj.Comment != "" {
+				comment += ": " + adj.Comment
+			}
+			if _, err := tx.Exec(
+				"INSERT INTO order_adjustments (order_id, kind, amount, comment) VALUES ($1, $2, $3, $4)",
+				targetID, adjustmentDiscountFixed, -adj.Value, comment,
+			); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tx.Exec("UPDATE order_adjustments SET order_id = $1 WHERE id = $2", targetID, adj.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM order_adjustments WHERE order_id = $1", sourceID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE order_products SET order_id = $1 WHERE order_id = $2", targetID, sourceID); err != nil {
+		return err
+	}
+
+	payments, err := loadOrderPayments(tx, sourceID)
+	if err != nil {
+		return err
+	}
+	for _, payment := range payments {
+		if err := moveOrderPayment(tx, payment.ID, sourceID, targetID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO payment_allocations (payment_id, order_id, amount)
+		SELECT payment_id, $1, amount FROM payment_allocations WHERE order_id = $2
+		ON CONFLICT (payment_id, order_id) DO UPDATE SET amount = payment_allocations.amount + EXCLUDED.amount
+	`, targetID, sourceID); err != nil {
+		return err
+	}
+	if err := releaseOrderAllocations(tx, sourceID); err != nil {
+		return err
+	}
+
+	// Исходный заказ пуст и без оплат: закрываем его как отменённый, чтобы осталась история.
+	if _, err := tx.Exec(`
+		UPDATE orders
+		SET status = $1, version = version + 1, close_reason = $2, closed_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, orderStatusCancelled, fmt.Sprintf("Объединён с заказом №%d", targetID), sourceID); err != nil {
+		return err
+	}
+	return recalculateOrderDebt(tx, sourceID)
+}
+
+// respondOrders отдаёт заказы после изменения под заданными ключами.
+func respondOrders(c *gin.Context, ids map[string]int) {
+	response := gin.H{}
+	for key, orderID := range ids {
+		order, err := LoadOrder(orderID)
+		if err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		response[key] = order
+	}
+	c.JSON(http.StatusOK, response)
+}
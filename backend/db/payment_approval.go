@@ -0,0 +1,300 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Проверка оплат. Оплата, внесённая вручную, ждёт подтверждения (pending): менеджер сверяет её
+// с банком и подтверждает или отклоняет. Оплаты из банковской выписки подтверждены сразу, возвраты —
+// если их внёс тот, кто может подтверждать оплаты. Ожидающие деньги входят в оплату заказа,
+// отклонённые — нигде не учитываются. Подтверждать и отклонять может только manager/admin:
+// права — в role_permissions.
+
+const (
+	paymentStatusPending   = "pending"
+	paymentStatusConfirmed = "confirmed"
+	paymentStatusRejected  = "rejected"
+)
+
+var paymentStatusNames = map[string]string{
+	paymentStatusPending:   "Ожидает проверки",
+	paymentStatusConfirmed: "Подтверждена",
+	paymentStatusRejected:  "Отклонена",
+}
+
+// paymentCountsAsPaid — условие на payments_monitoring: деньги оплаты учитываются в долгах и итогах.
+const paymentCountsAsPaid = "status <> 'rejected'"
+
+const paymentStatusColumns = "status, status_comment, COALESCE(confirmed_by, ''), COALESCE(to_char(confirmed_at, 'YYYY-MM-DD HH24:MI:SS'), '')"
+
+type rejectPaymentRequest struct {
+	Comment string `json:"comment"`
+}
+
+type confirmPaymentsRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// CreateTablesPaymentApproval добавляет оплатам статус проверки. Уже внесённые оплаты считаются
+// подтверждёнными, новые по умолчанию ждут проверки.
+func CreateTablesPaymentApproval() {
+	_, err := DB.Exec(`
+		ALTER TABLE payments_monitoring ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'confirmed';
+		ALTER TABLE payments_monitoring ALTER COLUMN status SET DEFAULT 'pending';
+		ALTER TABLE payments_monitoring ADD COLUMN IF NOT EXISTS status_comment TEXT NOT NULL DEFAULT '';
+		ALTER TABLE payments_monitoring ADD COLUMN IF NOT EXISTS confirmed_by TEXT;
+		ALTER TABLE payments_monitoring ADD COLUMN IF NOT EXISTS confirmed_at TIMESTAMPTZ;
+
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'chk_payments_monitoring_status') THEN
+				ALTER TABLE payments_monitoring
+					ADD CONSTRAINT chk_payments_monitoring_status CHECK (status IN ('pending', 'confirmed', 'rejected'));
+			END IF;
+		END $$;
+
+		CREATE INDEX IF NOT EXISTS idx_payments_monitoring_status ON payments_monitoring(status);
+	`)
+	if err != nil {
+		log.Fatal("Failed to migrate payment statuses:", err)
+	}
+	// Без правила mayConfirmPayments разрешает всем: запрет для worker обязателен.
+	err = denyRoutes([]string{"worker"},
+		"POST "+confirmPaymentPath,
+		"POST /api/payments/:id/reject",
+		"POST /api/payments_monitoring/confirm",
+		"POST /api/bank_statements/:id/confirm",
+	)
+	if err != nil {
+		log.Fatal("Failed to set payment approval permissions:", err)
+	}
+	log.Println("Payment approval is ready")
+}
+
+func scanPaymentStatus(p *models.Payment) []any {
+	return []any{&p.Status, &p.StatusComment, &p.ConfirmedBy, &p.ConfirmedAt}
+}
+
+// confirmPaymentPath — маршрут подтверждения оплаты; право на него в role_permissions означает
+// право подтверждать оплаты.
+const confirmPaymentPath = "/api/payments/:id/confirm"
+
+// mayConfirmPayments проверяет право роли на confirmPaymentPath так же, как RoleMiddleware:
+// точное правило важнее правил с '*', а без правил доступ разрешён.
+func mayConfirmPayments(q queryer, roleID int) (bool, error) {
+	rows, err := q.Query(`
+		SELECT allowed FROM role_permissions
+		WHERE role_id = $1 AND method IN ($2, '*') AND path IN ($3, '*')
+		ORDER BY method = '*', path = '*'
+		LIMIT 1
+	`, roleID, http.MethodPost, confirmPaymentPath)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	allowed := true
+	if rows.Next() {
+		if err := rows.Scan(&allowed); err != nil {
+			return false, err
+		}
+	}
+	return allowed, rows.Err()
+}
+
+// markPaymentConfirmed подтверждает оплату, внесённую сразу проверенной (выписка, возврат).
+func markPaymentConfirmed(tx *sql.Tx, paymentID int, username string) error {
+	_, err := tx.Exec(`
+		UPDATE payments_monitoring SET status = 'confirmed', status_comment = '', confirmed_by = $2, confirmed_at = NOW()
+		WHERE id = $1
+	`, paymentID, username)
+	return err
+}
+
+// paymentReviewReset — SET-часть UPDATE оплаты: правка даты, способа или суммы, а также любая
+// правка отклонённой оплаты снова отправляет её на проверку. Аргументы — плейсхолдеры новых значений.
+func paymentReviewReset(date, method, amount string) string {
+	changed := fmt.Sprintf(
+		"type = 'payment' AND (status = 'rejected' OR date IS DISTINCT FROM %s::timestamptz OR method IS DISTINCT FROM %s OR ABS(COALESCE(amount, 0) - %s) >= 0.005)",
+		date, method, amount,
+	)
+	return fmt.Sprintf(`status = CASE WHEN %[1]s THEN 'pending' ELSE status END,
+		status_comment = CASE WHEN %[1]s THEN '' ELSE status_comment END,
+		confirmed_by = CASE WHEN %[1]s THEN NULL ELSE confirmed_by END,
+		confirmed_at = CASE WHEN %[1]s THEN NULL ELSE confirmed_at END`, changed)
+}
+
+// PaymentCountsAsPaid — входит ли оплата в оплаченное по заказу (отклонённые не входят).
+func PaymentCountsAsPaid(status string) bool {
+	return status != paymentStatusRejected
+}
+
+// PaymentPending — оплата ещё не подтверждена менеджером.
+func PaymentPending(status string) bool {
+	return status == paymentStatusPending
+}
+
+// OrderPaid — оплачено по заказу так же, как при расчёте долга: оплаты заказа, кроме отклонённых,
+// и зачтённые в него части клиентских оплат.
+func OrderPaid(order models.Order) float64 {
+	return roundMoney(totalPaid(order.Payments) + totalAllocated(order.Allocations))
+}
+
+// pendingPaid — сколько из оплаченного по заказу ещё не подтверждено.
+func pendingPaid(payments []models.Payment, allocations []models.PaymentAllocation) float64 {
+	total := 0.0
+	for _, p := range payments {
+		if p.Status == paymentStatusPending {
+			total += p.Amount
+		}
+	}
+	for _, a := range allocations {
+		if a.PaymentStatus == paymentStatusPending {
+			total += a.Amount
+		}
+	}
+	return roundMoney(total)
+}
+
+// setPaymentStatus меняет статус оплаты и пересчитывает долги затронутых заказов. Отклонённая
+// оплата снимается с заказов: её распределения удаляются.
+func setPaymentStatus(tx *sql.Tx, paymentID int, status, comment, username string) error {
+	var current, paymentType string
+	err := tx.QueryRow("SELECT status, type FROM payments_monitoring WHERE id = $1 FOR UPDATE", paymentID).Scan(&current, &paymentType)
+	if err != nil {
+		return err
+	}
+	if status == paymentStatusRejected && paymentType != paymentTypePayment {
+		return newBadRequestError("Refunds cannot be rejected: delete the refund instead")
+	}
+	if current == status {
+		return nil
+	}
+	// Подтверждение не меняет сумм, а отклонение и возврат из отклонённых меняют отчёты.
+	if status == paymentStatusRejected || current == paymentStatusRejected {
+		if err := checkPaymentPeriodOpen(tx, paymentID); err != nil {
+			return err
+		}
+	}
+
+	orderIDs, err := paymentOrderIDs(tx, paymentID)
+	if err != nil {
+		return err
+	}
+	if status == paymentStatusRejected {
+		if _, err := tx.Exec("DELETE FROM payment_allocations WHERE payment_id = $1", paymentID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`
+		UPDATE payments_monitoring SET status = $2, status_comment = $3, confirmed_by = $4, confirmed_at = NOW()
+		WHERE id = $1
+	`, paymentID, status, comment, username); err != nil {
+		return err
+	}
+	return recalculateOrdersDebt(tx, orderIDs)
+}
+
+func paymentIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+func changePaymentStatus(c *gin.Context, paymentID int, status, comment string) {
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := setPaymentStatus(tx, paymentID, status, comment, c.GetString("username")); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+		writeOrderError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": paymentID, "status": status})
+}
+
+// ConfirmPayment — POST /payments/:id/confirm. Подтвердить можно и отклонённую ранее оплату.
+func ConfirmPayment(c *gin.Context) {
+	id, ok := paymentIDParam(c)
+	if !ok {
+		return
+	}
+	changePaymentStatus(c, id, paymentStatusConfirmed, "")
+}
+
+// RejectPayment — POST /payments/:id/reject {comment}: деньги не пришли, оплата не учитывается.
+func RejectPayment(c *gin.Context) {
+	id, ok := paymentIDParam(c)
+	if !ok {
+		return
+	}
+	var req rejectPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	comment := strings.TrimSpace(req.Comment)
+	if comment == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rejection reason is required"})
+		return
+	}
+	changePaymentStatus(c, id, paymentStatusRejected, comment)
+}
+
+// ConfirmPayments — POST /payments_monitoring/confirm {ids}: подтверждает оплаты, сверенные с банком, одной транзакцией.
+func ConfirmPayments(c *gin.Context) {
+	var req confirmPaymentsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No payments to confirm"})
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	username := c.GetString("username")
+	for _, id := range req.IDs {
+		if err := setPaymentStatus(tx, id, paymentStatusConfirmed, "", username); err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Payment %d not found", id)})
+				return
+			}
+			writeOrderError(c, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"confirmed": len(req.IDs)})
+}
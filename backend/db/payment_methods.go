@@ -0,0 +1,270 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Типы способов оплаты.
+var paymentMethodTypes = map[string]bool{
+	"cash":   true, // наличные, касса
+	"card":   true,
+	"bank":   true, // расчётный счёт
+	"person": true, // перевод конкретному человеку
+}
+
+type paymentMethodRequest struct {
+	Method      string `json:"method"`
+	DisplayName string `json:"display_name"`
+	Type        string `json:"type"`
+	Currency    string `json:"currency"`
+	Active      *bool  `json:"active"`
+}
+
+// CreateTablesPaymentMethods добавляет к способам оплаты название, тип, валюту и признак активности
+// и связывает payments_monitoring.method с payment_methods.method внешним ключом с ON UPDATE CASCADE,
+// чтобы переименование способа переносило и оплаты. Способы, которые встречаются в оплатах,
+// но отсутствуют в справочнике, добавляются отключёнными.
+func CreateTablesPaymentMethods() {
+	_, err := DB.Exec(`
+		ALTER TABLE payment_methods ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '';
+		ALTER TABLE payment_methods ADD COLUMN IF NOT EXISTS type TEXT NOT NULL DEFAULT '';
+		ALTER TABLE payment_methods ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT '';
+		ALTER TABLE payment_methods ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT TRUE;
+
+		INSERT INTO payment_methods (method, is_active)
+		SELECT DISTINCT pm.method, FALSE
+		FROM payments_monitoring pm
+		WHERE pm.method IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM payment_methods p WHERE p.method = pm.method)
+		ON CONFLICT (method) DO NOTHING;
+
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_payments_monitoring_method') THEN
+				ALTER TABLE payments_monitoring
+					ADD CONSTRAINT fk_payments_monitoring_method
+					FOREIGN KEY (method) REFERENCES payment_methods(method) ON UPDATE CASCADE;
+			END IF;
+		END $$;
+	`)
+	if err != nil {
+		log.Fatal("Failed to migrate payment methods:", err)
+	}
+	log.Println("Payment methods table is ready")
+}
+
+const paymentMethodColumns = "id, method, display_name, type, currency, is_active"
+
+func scanPaymentMethod(row interface{ Scan(...any) error }, method *models.PaymentMethod) error {
+	var code sql.NullString
+	if err := row.Scan(&method.ID, &code, &method.DisplayName, &method.Type, &method.Currency, &method.Active); err != nil {
+		return err
+	}
+	method.Method = code.String
+	if method.DisplayName == "" {
+		method.DisplayName = method.Method
+	}
+	return nil
+}
+
+// paymentMethodUsable проверяет, что способ есть в справочнике и не отключён.
+func paymentMethodUsable(q queryer, method string) (bool, error) {
+	rows, err := q.Query("SELECT 1 FROM payment_methods WHERE method = $1 AND is_active", method)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+func validatePaymentMethod(req *paymentMethodRequest) error {
+	req.Method = strings.TrimSpace(req.Method)
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	req.Type = strings.ToLower(strings.TrimSpace(req.Type))
+	req.Currency = strings.ToUpper(strings.TrimSpace(req.Currency))
+
+	if req.Method == "" {
+		return newBadRequestError("Payment method code is required")
+	}
+	if !paymentMethodTypes[req.Type] {
+		return newBadRequestError("Invalid payment method type: expected cash, card, bank or person")
+	}
+	if req.Currency != "" {
+		if len(req.Currency) != 3 || strings.Trim(req.Currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return newBadRequestError("Currency must be a three-letter ISO code, e.g. RUB or USD")
+		}
+	}
+	return nil
+}
+
+func writePaymentMethodError(c *gin.Context, err error) {
+	var badReq *badRequestError
+	switch {
+	case errors.As(err, &badReq):
+		c.JSON(http.StatusBadRequest, gin.H{"error": badReq.message})
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment method not found"})
+	case isUniqueViolation(err):
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment method with this code already exists"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func loadPaymentMethod(id int) (models.PaymentMethod, error) {
+	var method models.PaymentMethod
+	err := scanPaymentMethod(DB.QueryRow("SELECT "+paymentMethodColumns+" FROM payment_methods WHERE id = $1", id), &method)
+	return method, err
+}
+
+// GetPaymentMethods отдаёт способы оплаты, включая отключённые: по ним остаются старые оплаты.
+// ?active=true — только доступные для новых оплат.
+func GetPaymentMethods(c *gin.Context) {
+	query := "SELECT " + paymentMethodColumns + " FROM payment_methods"
+	if c.Query("active") == "true" {
+		query += " WHERE is_active"
+	}
+	rows, err := DB.Query(query + " ORDER BY method")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	paymentMethods := make([]models.PaymentMethod, 0)
+	for rows.Next() {
+		var method models.PaymentMethod
+		if err := scanPaymentMethod(rows, &method); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		paymentMethods = append(paymentMethods, method)
+	}
+	c.JSON(http.StatusOK, paymentMethods)
+}
+
+func CreatePaymentMethod(c *gin.Context) {
+	var req paymentMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePaymentMethod(&req); err != nil {
+		writePaymentMethodError(c, err)
+		return
+	}
+	active := req.Active == nil || *req.Active
+
+	var id int
+	err := DB.QueryRow(`
+		INSERT INTO payment_methods (method, display_name, type, currency, is_active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, req.Method, req.DisplayName, req.Type, req.Currency, active).Scan(&id)
+	if err != nil {
+		writePaymentMethodError(c, err)
+		return
+	}
+
+	method, err := loadPaymentMethod(id)
+	if err != nil {
+		writePaymentMethodError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, method)
+}
+
+// UpdatePaymentMethod меняет способ оплаты. Новый код переносится в оплаты внешним ключом,
+// в возвраты — явно. Если active не передан, признак активности не меняется.
+func UpdatePaymentMethod(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment method ID"})
+		return
+	}
+
+	var req paymentMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePaymentMethod(&req); err != nil {
+		writePaymentMethodError(c, err)
+		return
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var oldMethod sql.NullString
+	var active bool
+	if err := tx.QueryRow("SELECT method, is_active FROM payment_methods WHERE id = $1 FOR UPDATE", id).Scan(&oldMethod, &active); err != nil {
+		writePaymentMethodError(c, err)
+		return
+	}
+	if req.Active != nil {
+		active = *req.Active
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE payment_methods SET method = $1, display_name = $2, type = $3, currency = $4, is_active = $5
+		WHERE id = $6
+	`, req.Method, req.DisplayName, req.Type, req.Currency, active, id); err != nil {
+		writePaymentMethodError(c, err)
+		return
+	}
+	if oldMethod.String != req.Method {
+		if _, err := tx.Exec("UPDATE returns SET refund_method = $1 WHERE refund_method = $2", req.Method, oldMethod.String); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename refund method: " + err.Error()})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+
+	method, err := loadPaymentMethod(id)
+	if err != nil {
+		writePaymentMethodError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, method)
+}
+
+// DeletePaymentMethod не удаляет способ, а отключает его: старые оплаты сохраняют свой способ,
+// новые оплаты с ним создать нельзя. Включить обратно — PUT с active: true.
+func DeletePaymentMethod(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment method ID"})
+		return
+	}
+
+	result, err := DB.Exec("UPDATE payment_methods SET is_active = FALSE WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment method not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Payment method disabled"})
+}
@@ -3,23 +3,24 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Talonmortem/SHM/internal/models"
 	"github.com/gin-gonic/gin"
 )
 
-func GetPaymentsMonitoring(c *gin.Context) {
+// paymentsMonitoringFilter строит условие по ?method=, ?currency=, ?status= и ?date_from=&date_to= для запроса
+// с алиасами pm (payments_monitoring) и pp (payment_methods). Граница-дата включает весь день
+// в поясе бизнеса, граница со временем сравнивается точно.
+func paymentsMonitoringFilter(c *gin.Context) (string, []any, error) {
 	method := c.Query("method")
-	dateFrom := c.Query("date_from")
-	dateTo := c.Query("date_to")
-
-	base := `SELECT pm.id, pm.date, pm.method, pm.amount, pm.comment
-	FROM payments_monitoring pm
-	JOIN payment_methods pp ON pp.method = pm.method`
+	currency := strings.ToUpper(strings.TrimSpace(c.Query("currency")))
 
 	args := []any{}
 	conditions := []string{}
@@ -28,38 +29,57 @@ func GetPaymentsMonitoring(c *gin.Context) {
 		args = append(args, method)
 		conditions = append(conditions, fmt.Sprintf("pp.method = $%d", len(args)))
 	}
-
-	if dateFrom != "" && dateTo != "" {
-		from := dateFrom
-		to := dateTo
-		if len(strings.TrimSpace(from)) == len("2006-01-02") {
-			from += " 00:00:00"
+	if currency != "" {
+		args = append(args, currency)
+		conditions = append(conditions, fmt.Sprintf("pm.currency = $%d", len(args)))
+	}
+	if status := strings.TrimSpace(c.Query("status")); status != "" {
+		if _, ok := paymentStatusNames[status]; !ok {
+			return "", nil, newBadRequestError("status must be pending, confirmed or rejected")
 		}
-		if len(strings.TrimSpace(to)) == len("2006-01-02") {
-			to += " 23:59:59"
+		args = append(args, status)
+		conditions = append(conditions, fmt.Sprintf("pm.status = $%d", len(args)))
+	}
+
+	for _, bound := range []struct {
+		param, dayCondition, timeCondition string
+	}{
+		{"date_from", "pm.date >= $%d::date", "pm.date >= $%d::timestamptz"},
+		{"date_to", "pm.date < $%d::date + 1", "pm.date <= $%d::timestamptz"},
+	} {
+		raw := strings.TrimSpace(c.Query(bound.param))
+		if raw == "" {
+			continue
 		}
-		args = append(args, from)
-		args = append(args, to)
-		conditions = append(conditions, fmt.Sprintf("pm.date BETWEEN $%d AND $%d", len(args)-1, len(args)))
-	} else if dateFrom != "" {
-		from := dateFrom
-		if len(strings.TrimSpace(from)) == len("2006-01-02") {
-			from += " 00:00:00"
+		t, err := parseDateTimeInput(raw)
+		if err != nil {
+			return "", nil, newBadRequestError("Invalid " + bound.param)
 		}
-		args = append(args, from)
-		conditions = append(conditions, fmt.Sprintf("pm.date >= $%d", len(args)))
-	} else if dateTo != "" {
-		to := dateTo
-		if len(strings.TrimSpace(to)) == len("2006-01-02") {
-			to += " 23:59:59"
+		if !strings.Contains(raw, ":") {
+			args = append(args, t.Format(dateLayout))
+			conditions = append(conditions, fmt.Sprintf(bound.dayCondition, len(args)))
+		} else {
+			args = append(args, t.Format(time.RFC3339))
+			conditions = append(conditions, fmt.Sprintf(bound.timeCondition, len(args)))
 		}
-		args = append(args, to)
-		conditions = append(conditions, fmt.Sprintf("pm.date <= $%d", len(args)))
 	}
 
-	query := base
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+func GetPaymentsMonitoring(c *gin.Context) {
+	query := `SELECT pm.id, COALESCE(to_char(pm.date, 'YYYY-MM-DD HH24:MI:SS'), ''), pm.method, COALESCE(pm.order_id, 0), pm.amount, pm.comment, pm.type, pm.reason,
+		pm.currency, COALESCE(pm.original_amount, pm.amount), pm.rate,
+		pm.status, pm.status_comment, COALESCE(pm.confirmed_by, ''), COALESCE(to_char(pm.confirmed_at, 'YYYY-MM-DD HH24:MI:SS'), '')
+	FROM payments_monitoring pm
+	JOIN payment_methods pp ON pp.method = pm.method`
+	where, args, err := paymentsMonitoringFilter(c)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if where != "" {
+		query += " WHERE " + where
 	}
 	query += " ORDER BY pm.date"
 
@@ -74,7 +94,9 @@ func GetPaymentsMonitoring(c *gin.Context) {
 	var payments []models.Payment
 	for row.Next() {
 		var p models.Payment
-		if err := row.Scan(&p.ID, &p.Date, &p.Method, &p.Amount, &p.Comment); err != nil {
+		dest := []any{&p.ID, &p.Date, &p.Method, &p.OrderID, &p.Amount, &p.Comment, &p.Type, &p.Reason,
+			&p.Currency, &p.OriginalAmount, &p.Rate}
+		if err := row.Scan(append(dest, scanPaymentStatus(&p)...)...); err != nil {
 			log.Printf("Error scanning row: %v\n", err)
 			continue
 		}
@@ -84,32 +106,6 @@ func GetPaymentsMonitoring(c *gin.Context) {
 	c.JSON(http.StatusOK, payments)
 }
 
-func GetPaymentMethods(c *gin.Context) {
-	rows, err := DB.Query("SELECT id, method FROM payment_methods ORDER BY method")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer rows.Close()
-
-	var paymentMethods []struct {
-		ID     int    `json:"id"`
-		Method string `json:"method"`
-	}
-	for rows.Next() {
-		var pm struct {
-			ID     int    `json:"id"`
-			Method string `json:"method"`
-		}
-		if err := rows.Scan(&pm.ID, &pm.Method); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		paymentMethods = append(paymentMethods, pm)
-	}
-	c.JSON(http.StatusOK, paymentMethods)
-}
-
 func CreatePayment(c *gin.Context) {
 	var payment models.Payment
 	if err := c.ShouldBindJSON(&payment); err != nil {
@@ -122,9 +118,31 @@ func CreatePayment(c *gin.Context) {
 		return
 	}
 
-	query := `INSERT INTO payments_monitoring (date, method, amount, comment)
-				VALUES ($1, $2, $3, $4)`
-	_, err = DB.Exec(query, normalizedDate, payment.Method, payment.Amount, payment.Comment)
+	if usable, err := paymentMethodUsable(DB, payment.Method); err != nil || !usable {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or disabled payment method: " + payment.Method})
+		return
+	}
+	payment.Date = normalizedDate
+	if err := applyPaymentCurrency(DB, &payment); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if err := normalizePaymentType(&payment); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if payment.Type == paymentTypeRefund {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refunds are recorded via /orders/:id/refunds"})
+		return
+	}
+	if err := checkPeriodOpen(DB, normalizedDate); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	query := `INSERT INTO payments_monitoring (date, method, amount, comment, currency, original_amount, rate)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = DB.Exec(query, normalizedDate, payment.Method, payment.Amount, payment.Comment, payment.Currency, payment.OriginalAmount, payment.Rate)
 	if err != nil {
 		log.Printf("Error inserting payment: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment"})
@@ -135,7 +153,11 @@ func CreatePayment(c *gin.Context) {
 }
 
 func UpdatePayment(c *gin.Context) {
-	id := c.Param("id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
 	var payment models.Payment
 	if err := c.ShouldBindJSON(&payment); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
@@ -147,27 +169,143 @@ func UpdatePayment(c *gin.Context) {
 		return
 	}
 
-	query := `UPDATE payments_monitoring SET date = $1, method = $2, amount = $3, comment = $4
-				WHERE id = $5`
-	_, err = DB.Exec(query, normalizedDate, payment.Method, payment.Amount, payment.Comment, id)
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Отключённый способ можно оставить у старой оплаты, но не выбрать заново.
+	var oldMethod, oldDate sql.NullString
+	var oldType string
+	var oldRate float64
+	if err := tx.QueryRow(
+		"SELECT method, to_char(date, 'YYYY-MM-DD HH24:MI:SS'), type, rate FROM payments_monitoring WHERE id = $1 FOR UPDATE", id,
+	).Scan(&oldMethod, &oldDate, &oldType, &oldRate); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+		return
+	}
+	if oldType == paymentTypeRefund {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This is a refund: use /orders/:id/refunds"})
+		return
+	}
+	if err := checkPeriodOpen(tx, oldDate.String, normalizedDate); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if payment.Method != oldMethod.String {
+		if usable, err := paymentMethodUsable(tx, payment.Method); err != nil || !usable {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or disabled payment method: " + payment.Method})
+			return
+		}
+	}
+	payment.Date = normalizedDate
+	keepPaymentRate(&payment, oldMethod.String, oldDate.String, oldRate)
+	if err := applyPaymentCurrency(tx, &payment); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if payment.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment amount must be positive"})
+		return
+	}
+
+	// Уже распределённую по заказам часть оплаты уменьшить нельзя.
+	var allocated float64
+	if err := tx.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM payment_allocations WHERE payment_id = $1", id).Scan(&allocated); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+		return
+	}
+	if payment.Amount < roundMoney(allocated)-0.005 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Payment is allocated to orders for %.2f: remove allocations first", allocated)})
+		return
+	}
+
+	query := `UPDATE payments_monitoring SET date = $1, method = $2, amount = $3, comment = $4,
+				currency = $5, original_amount = $6, rate = $7, ` + paymentReviewReset("$1", "$2", "$3") + `
+				WHERE id = $8`
+	_, err = tx.Exec(query, normalizedDate, payment.Method, payment.Amount, payment.Comment,
+		payment.Currency, payment.OriginalAmount, payment.Rate, id)
 	if err != nil {
 		log.Printf("Error updating payment: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
 		return
 	}
+	orderIDs, err := paymentOrderIDs(tx, id)
+	if err == nil {
+		err = recalculateOrdersDebt(tx, orderIDs)
+	}
+	if err != nil {
+		log.Printf("Error recalculating order debt for payment %d: %v\n", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate order debt"})
+		return
+	}
 
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Payment updated successfully"})
 }
 
 func DeletePayment(c *gin.Context) {
-	id := c.Param("id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
 
-	_, err := DB.Exec("DELETE FROM payments_monitoring WHERE id = $1", id)
+	tx, err := DB.Begin()
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payment"})
+		return
+	}
+	defer tx.Rollback()
+
+	var paymentType string
+	if err := tx.QueryRow("SELECT type FROM payments_monitoring WHERE id = $1 FOR UPDATE", id).Scan(&paymentType); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payment"})
+		return
+	}
+	if paymentType == paymentTypeRefund {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This is a refund: use /orders/:id/refunds"})
+		return
+	}
+	if err := checkPaymentPeriodOpen(tx, id); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	// Заказы запоминаются до удаления: распределения удалятся вместе с оплатой.
+	orderIDs, err := paymentOrderIDs(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payment"})
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM payments_monitoring WHERE id = $1", id); err != nil {
 		log.Printf("Error deleting payment: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payment"})
 		return
 	}
+	if err := recalculateOrdersDebt(tx, orderIDs); err != nil {
+		log.Printf("Error recalculating order debt after deleting payment %d: %v\n", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate order debt"})
+		return
+	}
 
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payment"})
+		return
+	}
+	purgeEntityAttachments(AttachmentPayment, id)
 	c.JSON(http.StatusOK, gin.H{"message": "Payment deleted successfully"})
 }
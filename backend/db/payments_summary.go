@@ -0,0 +1,146 @@
+package db
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// summaryPeriods — допустимые ?period= и их единица для date_trunc. Недели начинаются с понедельника,
+// границы дней — в поясе бизнеса (в нём работает сессия).
+var summaryPeriods = map[string]string{"day": "day", "week": "week", "month": "month"}
+
+const (
+	summaryRefundAmount  = "COALESCE(SUM(pm.amount) FILTER (WHERE pm.type = 'refund'), 0)"
+	summaryPendingAmount = "COALESCE(SUM(pm.amount) FILTER (WHERE pm.status = 'pending'), 0)"
+)
+
+// GetPaymentsMonitoringSummary — GET /payments_monitoring/summary: итоги оплат по способам, по периодам
+// (?period=day|week|month, по умолчанию day) и сводная способ × день. Фильтры те же, что у списка;
+// отклонённые оплаты в итоги не входят, ожидающие проверки показаны отдельно.
+func GetPaymentsMonitoringSummary(c *gin.Context) {
+	period := c.DefaultQuery("period", "day")
+	unit, ok := summaryPeriods[period]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be day, week or month"})
+		return
+	}
+	where, args, err := paymentsMonitoringFilter(c)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	summary, err := loadPaymentsSummary(where, args, period, unit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build payments summary: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+func loadPaymentsSummary(where string, args []any, period, unit string) (models.PaymentsSummary, error) {
+	summary := models.PaymentsSummary{
+		Period:   period,
+		ByMethod: make([]models.PaymentMethodTotal, 0),
+		ByPeriod: make([]models.PaymentPeriodTotal, 0),
+		Days:     make([]string, 0),
+		Pivot:    make([]models.PaymentPivotRow, 0),
+	}
+	from := `
+		FROM payments_monitoring pm
+		JOIN payment_methods pp ON pp.method = pm.method`
+	counted := "pm." + paymentCountsAsPaid
+	if where != "" {
+		counted = where + " AND " + counted
+	}
+	dated := counted + " AND pm.date IS NOT NULL"
+
+	rows, err := DB.Query(`
+		SELECT pm.method, pm.currency, COUNT(*), COALESCE(SUM(pm.amount), 0), `+summaryRefundAmount+`, `+summaryPendingAmount+`,
+			COALESCE(SUM(COALESCE(pm.original_amount, pm.amount)), 0)`+from+whereClause(counted)+`
+		GROUP BY pm.method, pm.currency
+		ORDER BY pm.method, pm.currency
+	`, args...)
+	if err != nil {
+		return summary, err
+	}
+	for rows.Next() {
+		var t models.PaymentMethodTotal
+		if err := rows.Scan(&t.Method, &t.Currency, &t.Count, &t.Amount, &t.Refunds, &t.Pending, &t.OriginalAmount); err != nil {
+			rows.Close()
+			return summary, err
+		}
+		t.Amount, t.Refunds, t.Pending, t.OriginalAmount = roundMoney(t.Amount), roundMoney(t.Refunds), roundMoney(t.Pending), roundMoney(t.OriginalAmount)
+		summary.Count += t.Count
+		summary.Total += t.Amount
+		summary.Refunds += t.Refunds
+		summary.Pending += t.Pending
+		summary.ByMethod = append(summary.ByMethod, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return summary, err
+	}
+	summary.Total, summary.Refunds, summary.Pending = roundMoney(summary.Total), roundMoney(summary.Refunds), roundMoney(summary.Pending)
+
+	// unit берётся только из summaryPeriods, поэтому его можно подставить в запрос.
+	rows, err = DB.Query(`
+		SELECT to_char(date_trunc('`+unit+`', pm.date), 'YYYY-MM-DD') AS period, COUNT(*),
+			COALESCE(SUM(pm.amount), 0), `+summaryRefundAmount+from+whereClause(dated)+`
+		GROUP BY period
+		ORDER BY period
+	`, args...)
+	if err != nil {
+		return summary, err
+	}
+	for rows.Next() {
+		var t models.PaymentPeriodTotal
+		if err := rows.Scan(&t.Period, &t.Count, &t.Amount, &t.Refunds); err != nil {
+			rows.Close()
+			return summary, err
+		}
+		t.Amount, t.Refunds = roundMoney(t.Amount), roundMoney(t.Refunds)
+		summary.ByPeriod = append(summary.ByPeriod, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return summary, err
+	}
+
+	rows, err = DB.Query(`
+		SELECT pm.method, to_char(pm.date, 'YYYY-MM-DD') AS day, COALESCE(SUM(pm.amount), 0)`+from+whereClause(dated)+`
+		GROUP BY pm.method, day
+		ORDER BY pm.method, day
+	`, args...)
+	if err != nil {
+		return summary, err
+	}
+	defer rows.Close()
+
+	days := make(map[string]bool)
+	for rows.Next() {
+		var method, day string
+		var amount float64
+		if err := rows.Scan(&method, &day, &amount); err != nil {
+			return summary, err
+		}
+		if n := len(summary.Pivot); n == 0 || summary.Pivot[n-1].Method != method {
+			summary.Pivot = append(summary.Pivot, models.PaymentPivotRow{Method: method, Amounts: make(map[string]float64)})
+		}
+		row := &summary.Pivot[len(summary.Pivot)-1]
+		row.Amounts[day] = roundMoney(amount)
+		row.Total = roundMoney(row.Total + amount)
+		if !days[day] {
+			days[day] = true
+			summary.Days = append(summary.Days, day)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return summary, err
+	}
+	sort.Strings(summary.Days)
+	return summary, nil
+}
@@ -0,0 +1,230 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Закрытие финансового периода. Оплаты, отгруженные заказы и выплаты курьеру с датой
+// не позже closed_through нельзя создавать, менять и удалять: по ним уже сданы отчёты. Дату двигает
+// только admin (право в role_permissions); откат назад — переоткрытие — требует причины.
+// Каждое изменение — строка period_lock_events, последняя строка задаёт текущую дату.
+
+const (
+	periodLockClose  = "close"
+	periodLockReopen = "reopen"
+)
+
+// periodClosedError — изменение попадает в закрытый период; отвечаем 409.
+type periodClosedError struct {
+	closedThrough string
+}
+
+func (e *periodClosedError) Error() string {
+	return fmt.Sprintf("Financial period is closed through %s: ask an admin to reopen it", e.closedThrough)
+}
+
+type periodLockRequest struct {
+	ClosedThrough string `json:"closed_through"` // пусто — открыть все периоды
+	Reason        string `json:"reason"`
+}
+
+func CreateTablesPeriodLock() {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS period_lock_events (
+			id BIGSERIAL PRIMARY KEY,
+			action TEXT NOT NULL CHECK (action IN ('close', 'reopen')),
+			closed_through DATE,
+			previous DATE,
+			reason TEXT NOT NULL DEFAULT '',
+			changed_by TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create period lock table:", err)
+	}
+	// Закрывать и открывать период может только admin, даже если cmd/seed не запускали.
+	if err := denyRoutes([]string{"worker", "manager"}, "PUT /api/period_lock"); err != nil {
+		log.Fatal("Failed to set period lock permissions:", err)
+	}
+	log.Println("Period lock table is ready")
+}
+
+// closedThrough — последний день закрытого периода (YYYY-MM-DD) или пустая строка.
+func closedThrough(q queryer) (string, error) {
+	rows, err := q.Query("SELECT COALESCE(to_char(closed_through, 'YYYY-MM-DD'), '') FROM period_lock_events ORDER BY id DESC LIMIT 1")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	date := ""
+	if rows.Next() {
+		if err := rows.Scan(&date); err != nil {
+			return "", err
+		}
+	}
+	return date, rows.Err()
+}
+
+// checkPeriodOpen возвращает periodClosedError, если какая-то из дат попадает в закрытый период.
+// Даты — YYYY-MM-DD или paymentDateTimeLayout; пустые пропускаются.
+func checkPeriodOpen(q queryer, dates ...string) error {
+	closed, err := closedThrough(q)
+	if err != nil || closed == "" {
+		return err
+	}
+	for _, date := range dates {
+		if len(date) >= len(dateLayout) && date[:len(dateLayout)] <= closed {
+			return &periodClosedError{closedThrough: closed}
+		}
+	}
+	return nil
+}
+
+// checkPaymentPeriodOpen проверяет дату уже записанной оплаты.
+func checkPaymentPeriodOpen(q queryer, paymentID int) error {
+	rows, err := q.Query("SELECT COALESCE(to_char(date, 'YYYY-MM-DD'), '') FROM payments_monitoring WHERE id = $1", paymentID)
+	if err != nil {
+		return err
+	}
+	date := ""
+	if rows.Next() {
+		err = rows.Scan(&date)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	return checkPeriodOpen(q, date)
+}
+
+// checkShippedOrderPeriodOpen — отгруженный заказ из закрытого периода не меняется. День отгрузки —
+// ship_date, а если её нет, день создания заказа.
+func checkShippedOrderPeriodOpen(q queryer, orderID int) error {
+	rows, err := q.Query(`
+		SELECT to_char(COALESCE(ship_date, created_at::date), 'YYYY-MM-DD') FROM orders WHERE id = $1 AND status = $2
+	`, orderID, orderStatusShipped)
+	if err != nil {
+		return err
+	}
+	date := ""
+	if rows.Next() {
+		err = rows.Scan(&date)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	return checkPeriodOpen(q, date)
+}
+
+func loadPeriodLock() (models.PeriodLock, error) {
+	lock := models.PeriodLock{Events: make([]models.PeriodLockEvent, 0)}
+	rows, err := DB.Query(`
+		SELECT id, action, COALESCE(to_char(closed_through, 'YYYY-MM-DD'), ''), COALESCE(to_char(previous, 'YYYY-MM-DD'), ''),
+			reason, changed_by, to_char(created_at, 'YYYY-MM-DD HH24:MI:SS')
+		FROM period_lock_events
+		ORDER BY id DESC
+	`)
+	if err != nil {
+		return lock, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.PeriodLockEvent
+		if err := rows.Scan(&e.ID, &e.Action, &e.ClosedThrough, &e.Previous, &e.Reason, &e.ChangedBy, &e.CreatedAt); err != nil {
+			return lock, err
+		}
+		lock.Events = append(lock.Events, e)
+	}
+	if len(lock.Events) > 0 {
+		lock.ClosedThrough = lock.Events[0].ClosedThrough
+	}
+	return lock, rows.Err()
+}
+
+// GetPeriodLock — GET /period_lock: дата закрытия и журнал её изменений, новые первыми.
+func GetPeriodLock(c *gin.Context) {
+	lock, err := loadPeriodLock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, lock)
+}
+
+// SetPeriodLock — PUT /period_lock {closed_through, reason}. Дата позже текущей закрывает период,
+// раньше текущей или пустая — переоткрывает и требует причины.
+func SetPeriodLock(c *gin.Context) {
+	var req periodLockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	date, err := normalizeOptionalDateInput(req.ClosedThrough)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid closed_through: expected YYYY-MM-DD"})
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	// Изменения даты закрытия идут строго по очереди.
+	if _, err := tx.Exec("LOCK TABLE period_lock_events IN EXCLUSIVE MODE"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	previous, err := closedThrough(tx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if date == previous {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Period is already closed through this date"})
+		return
+	}
+	action := periodLockClose
+	if date == "" || (previous != "" && date < previous) {
+		action = periodLockReopen
+		if reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reason is required to reopen a closed period"})
+			return
+		}
+	}
+	if date >= businessNow().Format(dateLayout) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot close a period that has not ended yet"})
+		return
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO period_lock_events (action, closed_through, previous, reason, changed_by)
+		VALUES ($1, $2, $3, $4, $5)
+	`, action, nullableDate(date), nullableDate(previous), reason, c.GetString("username")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Period lock: %s %q -> %q by %s (%s)", action, previous, date, c.GetString("username"), reason)
+
+	lock, err := loadPeriodLock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, lock)
+}
@@ -211,7 +211,7 @@ func reserveArticleStock(tx *sql.Tx, requestedWeights map[int]float64) error {
 			}
 			return err
 		}
-		if _, err := tx.Exec("UPDATE articles SET kg = COALESCE(kg, 0) - $1 WHERE service_id = $2", requested, articleServiceID); err != nil {
+		if _, err := tx.Exec("UPDATE articles SET kg = COALESCE(kg, 0) - $1, version = version + 1 WHERE service_id = $2", requested, articleServiceID); err != nil {
 			return err
 		}
 	}
@@ -225,7 +225,7 @@ func releaseArticleStock(tx *sql.Tx, releasedWeights map[int]float64) error {
 			continue
 		}
 
-		result, err := tx.Exec("UPDATE articles SET kg = COALESCE(kg, 0) + $1 WHERE service_id = $2", released, articleServiceID)
+		result, err := tx.Exec("UPDATE articles SET kg = COALESCE(kg, 0) + $1, version = version + 1 WHERE service_id = $2", released, articleServiceID)
 		if err != nil {
 			return err
 		}
@@ -286,8 +286,54 @@ func insertProductArticles(tx *sql.Tx, productID int, articlesInProduct []models
 	return nil
 }
 
+const productColumns = "id, status, name, weight, skidka, summaRubSoSkidkoj, count, onePrice, video, description, version"
+
+func scanProduct(row interface{ Scan(...any) error }, product *models.Product) error {
+	return row.Scan(
+		&product.ID,
+		&product.Status,
+		&product.Name,
+		&product.Weight,
+		&product.Skidka,
+		&product.SummaRubSoSkidkoj,
+		&product.Count,
+		&product.OnePrice,
+		&product.Video,
+		&product.Description,
+		&product.Version,
+	)
+}
+
+func loadProductArticles(q queryer, productID int) ([]models.ArticleInProduct, error) {
+	rows, err := q.Query("SELECT id, article, cursEvro, priceEvro, weight, count, sumEvro, sumRub FROM article_in_product WHERE product_id = $1", productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var articles []models.ArticleInProduct
+	for rows.Next() {
+		var article models.ArticleInProduct
+		if err := rows.Scan(&article.ID, &article.Article, &article.CursEvro, &article.PriceEvro, &article.Weight, &article.Count, &article.SumEvro, &article.SumRub); err != nil {
+			return nil, err
+		}
+		articles = append(articles, article)
+	}
+	return articles, rows.Err()
+}
+
+func loadProduct(id int) (models.Product, error) {
+	var product models.Product
+	if err := scanProduct(DB.QueryRow("SELECT "+productColumns+" FROM products WHERE id = $1", id), &product); err != nil {
+		return product, err
+	}
+	articles, err := loadProductArticles(DB, id)
+	product.ArticlesInProduct = articles
+	return product, err
+}
+
 func GetProducts(c *gin.Context) {
-	rows, err := DB.Query("SELECT id, status, name, weight, skidka, summaRubSoSkidkoj, count, onePrice, video, description FROM products")
+	rows, err := DB.Query("SELECT " + productColumns + " FROM products")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "articles": "Make sure articles exist in articles table"})
 		return
@@ -297,44 +343,44 @@ func GetProducts(c *gin.Context) {
 	var products []models.Product
 	for rows.Next() {
 		var product models.Product
-		if err := rows.Scan(
-			&product.ID,
-			&product.Status,
-			&product.Name,
-			&product.Weight,
-			&product.Skidka,
-			&product.SummaRubSoSkidkoj,
-			&product.Count,
-			&product.OnePrice,
-			&product.Video,
-			&product.Description,
-		); err != nil {
+		if err := scanProduct(rows, &product); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		articleRows, err := DB.Query("SELECT id, article, cursEvro, priceEvro, weight, count, sumEvro, sumRub FROM article_in_product WHERE product_id = $1", product.ID)
+		product.ArticlesInProduct, err = loadProductArticles(DB, product.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-
-		for articleRows.Next() {
-			var article models.ArticleInProduct
-			if err := articleRows.Scan(&article.ID, &article.Article, &article.CursEvro, &article.PriceEvro, &article.Weight, &article.Count, &article.SumEvro, &article.SumRub); err != nil {
-				articleRows.Close()
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			product.ArticlesInProduct = append(product.ArticlesInProduct, article)
-		}
-		articleRows.Close()
 		products = append(products, product)
 	}
 
 	c.JSON(http.StatusOK, products)
 }
 
+// GetProduct отдаёт один товар; ETag — его версия для If-Match при сохранении.
+func GetProduct(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	product, err := loadProduct(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setETag(c, product.Version)
+	c.JSON(http.StatusOK, product)
+}
+
 func CreateProduct(c *gin.Context) {
 	var product models.Product
 	if err := c.ShouldBindJSON(&product); err != nil {
@@ -389,6 +435,8 @@ func CreateProduct(c *gin.Context) {
 		return
 	}
 
+	product.Version = 1
+	setETag(c, product.Version)
 	c.JSON(http.StatusOK, product)
 }
 
@@ -400,6 +448,11 @@ func UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	expectedVersion, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
 	var product models.Product
 	if err := c.ShouldBindJSON(&product); err != nil {
 		log.Printf("Error binding JSON for product update: %v \n %v", product, err)
@@ -419,6 +472,26 @@ func UpdateProduct(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
+	currentVersion, err := lockVersion(tx, "products", "id", id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !versionMatches(expectedVersion, currentVersion) {
+		tx.Rollback()
+		current, err := loadProduct(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		writeVersionConflict(c, current.Version, current)
+		return
+	}
+
 	oldCounts, err := getReservedArticleWeightsByProductID(tx, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -438,8 +511,8 @@ func UpdateProduct(c *gin.Context) {
 
 	calculateProductFields(&product)
 
-	result, err := tx.Exec(
-		"UPDATE products SET status = $1, name = $2, weight = $3, skidka = $4, summaRubSoSkidkoj = $5, count = $6, onePrice = $7, video = $8, description = $9 WHERE id = $10",
+	err = tx.QueryRow(
+		"UPDATE products SET status = $1, name = $2, weight = $3, skidka = $4, summaRubSoSkidkoj = $5, count = $6, onePrice = $7, video = $8, description = $9, version = version + 1 WHERE id = $10 RETURNING version",
 		product.Status,
 		product.Name,
 		product.Weight,
@@ -450,23 +523,13 @@ func UpdateProduct(c *gin.Context) {
 		product.Video,
 		product.Description,
 		id,
-	)
+	).Scan(&product.Version)
 	if err != nil {
 		log.Printf("Error updating product: %v \n %v", product, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error updating product": err.Error()})
 		return
 	}
 
-	affected, err := result.RowsAffected()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	if affected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-		return
-	}
-
 	_, err = tx.Exec("DELETE FROM article_in_product WHERE product_id = $1", id)
 	if err != nil {
 		log.Printf("Error deleting old articles for product: %v \n %v", product, err)
@@ -516,6 +579,7 @@ func UpdateProduct(c *gin.Context) {
 	}
 
 	product.ID = id
+	setETag(c, product.Version)
 	c.JSON(http.StatusOK, product)
 }
 
@@ -564,6 +628,7 @@ func DeleteProduct(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	purgeEntityAttachments(AttachmentProduct, id)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted"})
 }
@@ -0,0 +1,410 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultProductCardTemplateName = "default"
+
+const defaultProductCardTemplateBody = `{{.Name}}
+Статус: {{.Status}}
+Вес: {{num2 .Weight}} кг
+Кол-во: {{.Count}} шт.
+{{if .HasDiscount}}Скидка {{num .Skidka}} %
+{{end}}Цена €: {{num2 .EuroPrice}} €
+Цена ₽: {{num2 .RublePrice}}
+{{if .HasDiscount}}Цена со скидкой ₽: {{num .RublePriceWithDiscount}}
+{{end}}Цена за кг €: {{num2 .PricePerKgEuro}}
+Цена за кг ₽: {{num2 .PricePerKgRub}}
+Себестоимость одной вещи: {{num2 .CostPerItem}} ₽
+{{if .Video}}Видео: {{.Video}}
+{{end}}`
+
+var productCardContentTypes = map[string]string{
+	"text":     "text/plain; charset=utf-8",
+	"markdown": "text/markdown; charset=utf-8",
+	"html":     "text/html; charset=utf-8",
+}
+
+var productCardFuncs = map[string]any{
+	"num":  func(v float64) string { return strconv.FormatFloat(v, 'f', 0, 64) },
+	"num2": func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) },
+}
+
+// productCard — данные мешка для шаблона карточки, аналог evrohand.Card для наших товаров.
+type productCard struct {
+	ID                     int
+	Name                   string
+	Status                 string
+	Weight                 float64
+	Count                  int
+	Skidka                 float64
+	HasDiscount            bool
+	EuroPrice              float64
+	RublePrice             float64
+	RublePriceWithDiscount float64
+	PricePerKgEuro         float64
+	PricePerKgRub          float64
+	CostPerItem            float64
+	Video                  string
+	Description            string
+	Articles               []productCardArticle
+}
+
+type productCardArticle struct {
+	Code        string
+	Description string
+	Weight      float64
+	PriceEvro   float64
+	Count       int
+}
+
+func CreateTablesProductCards() {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS product_card_templates (
+			id BIGSERIAL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			format TEXT NOT NULL DEFAULT 'text',
+			body TEXT NOT NULL
+		);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create product card templates table:", err)
+	}
+
+	_, err = DB.Exec(`
+		INSERT INTO product_card_templates (name, format, body)
+		VALUES ($1, 'text', $2)
+		ON CONFLICT (name) DO NOTHING
+	`, defaultProductCardTemplateName, defaultProductCardTemplateBody)
+	if err != nil {
+		log.Fatal("Failed to seed default product card template:", err)
+	}
+	log.Println("Product card templates table is ready")
+}
+
+func productStatusTitle(status int) string {
+	switch status {
+	case 1:
+		return "На продаже"
+	case 2:
+		return "Забронировано"
+	case 3:
+		return "Продано"
+	}
+	return ""
+}
+
+func buildProductCard(p models.Product, articles []productCardArticle) productCard {
+	card := productCard{
+		ID:                     p.ID,
+		Name:                   p.Name,
+		Status:                 productStatusTitle(p.Status),
+		Weight:                 parseNumericInput(p.Weight),
+		Count:                  p.Count,
+		Skidka:                 parseNumericInput(p.Skidka),
+		RublePriceWithDiscount: parseNumericInput(p.SummaRubSoSkidkoj),
+		PricePerKgRub:          parseNumericInput(p.OnePrice),
+		Video:                  p.Video,
+		Description:            p.Description,
+		Articles:               articles,
+	}
+	card.HasDiscount = card.Skidka > 0
+
+	for _, a := range p.ArticlesInProduct {
+		card.EuroPrice += parseNumericInput(a.SumEvro)
+		card.RublePrice += parseNumericInput(a.SumRub)
+	}
+	if card.Weight != 0 {
+		card.PricePerKgEuro = card.EuroPrice / card.Weight
+	}
+	if card.Count != 0 {
+		card.CostPerItem = card.RublePriceWithDiscount / float64(card.Count)
+	}
+
+	return card
+}
+
+func parseProductCardTemplate(tpl models.ProductCardTemplate) (interface {
+	Execute(io.Writer, any) error
+}, error) {
+	if tpl.Format == "html" {
+		return htmltemplate.New(tpl.Name).Funcs(productCardFuncs).Parse(tpl.Body)
+	}
+	return texttemplate.New(tpl.Name).Funcs(productCardFuncs).Parse(tpl.Body)
+}
+
+func renderProductCard(tpl models.ProductCardTemplate, card productCard) (string, error) {
+	t, err := parseProductCardTemplate(tpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, card); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func validateProductCardTemplate(tpl *models.ProductCardTemplate) error {
+	tpl.Name = strings.TrimSpace(tpl.Name)
+	tpl.Format = strings.ToLower(strings.TrimSpace(tpl.Format))
+	if tpl.Format == "" {
+		tpl.Format = "text"
+	}
+	if tpl.Name == "" {
+		return newBadRequestError("Template name is required")
+	}
+	if _, ok := productCardContentTypes[tpl.Format]; !ok {
+		return newBadRequestError("Format must be text, markdown or html")
+	}
+	if strings.TrimSpace(tpl.Body) == "" {
+		return newBadRequestError("Template body is required")
+	}
+
+	// Только разбор: выполнение на пустой карточке отвергло бы шаблоны вида {{index .Articles 0}}.
+	// Ошибки выполнения на конкретном мешке возвращает GetProductCard.
+	if _, err := parseProductCardTemplate(*tpl); err != nil {
+		return newBadRequestError(fmt.Sprintf("Invalid template: %v", err))
+	}
+	return nil
+}
+
+// getProductCardTemplate ищет шаблон по имени. Шаблон default есть всегда: если его строки нет,
+// используется встроенный.
+
+func getProductCardTemplate(name string) (models.ProductCardTemplate, error) {
+	var tpl models.ProductCardTemplate
+	err := DB.QueryRow("SELECT id, name, format, body FROM product_card_templates WHERE name = $1", name).
+		Scan(&tpl.ID, &tpl.Name, &tpl.Format, &tpl.Body)
+	if err == sql.ErrNoRows && name == defaultProductCardTemplateName {
+		return models.ProductCardTemplate{Name: name, Format: "text", Body: defaultProductCardTemplateBody}, nil
+	}
+	return tpl, err
+}
+
+// writeProductCardTemplateError отвечает на ошибку сохранения шаблона; занятое имя — 409.
+func writeProductCardTemplateError(c *gin.Context, err error) {
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Template with this name already exists"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func loadProductForCard(id int) (models.Product, []productCardArticle, error) {
+	var p models.Product
+	err := DB.QueryRow(`
+		SELECT id, status, name, COALESCE(weight, ''), COALESCE(skidka, ''), COALESCE(summaRubSoSkidkoj, ''),
+			COALESCE(count, 0), COALESCE(onePrice, ''), COALESCE(video, ''), COALESCE(description, '')
+		FROM products
+		WHERE id = $1
+	`, id).Scan(&p.ID, &p.Status, &p.Name, &p.Weight, &p.Skidka, &p.SummaRubSoSkidkoj, &p.Count, &p.OnePrice, &p.Video, &p.Description)
+	if err != nil {
+		return p, nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT aip.id, aip.article, aip.cursEvro, aip.priceEvro, aip.weight, aip.count, aip.sumEvro, aip.sumRub,
+			COALESCE(a.code, ''), COALESCE(a.description, '')
+		FROM article_in_product aip
+		LEFT JOIN articles a ON a.service_id = aip.article
+		WHERE aip.product_id = $1
+		ORDER BY aip.id
+	`, id)
+	if err != nil {
+		return p, nil, err
+	}
+	defer rows.Close()
+
+	var articles []productCardArticle
+	for rows.Next() {
+		var a models.ArticleInProduct
+		var code, description string
+		if err := rows.Scan(&a.ID, &a.Article, &a.CursEvro, &a.PriceEvro, &a.Weight, &a.Count, &a.SumEvro, &a.SumRub, &code, &description); err != nil {
+			return p, nil, err
+		}
+		p.ArticlesInProduct = append(p.ArticlesInProduct, a)
+		articles = append(articles, productCardArticle{
+			Code:        code,
+			Description: description,
+			Weight:      parseNumericInput(a.Weight),
+			PriceEvro:   parseNumericInput(a.PriceEvro),
+			Count:       a.Count,
+		})
+	}
+
+	return p, articles, rows.Err()
+}
+
+func GetProductCard(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	templateName := strings.TrimSpace(c.Query("template"))
+	if templateName == "" {
+		templateName = defaultProductCardTemplateName
+	}
+
+	tpl, err := getProductCardTemplate(templateName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	product, articles, err := loadProductForCard(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	text, err := renderProductCard(tpl, buildProductCard(product, articles))
+	if err != nil {
+		log.Printf("Error rendering card for product %d with template %q: %v", id, tpl.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render product card: " + err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, productCardContentTypes[tpl.Format], []byte(text))
+}
+
+func GetProductCardTemplates(c *gin.Context) {
+	rows, err := DB.Query("SELECT id, name, format, body FROM product_card_templates ORDER BY name")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	templates := make([]models.ProductCardTemplate, 0)
+	for rows.Next() {
+		var tpl models.ProductCardTemplate
+		if err := rows.Scan(&tpl.ID, &tpl.Name, &tpl.Format, &tpl.Body); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		templates = append(templates, tpl)
+	}
+
+	c.JSON(http.StatusOK, templates)
+}
+
+func CreateProductCardTemplate(c *gin.Context) {
+	var tpl models.ProductCardTemplate
+	if err := c.ShouldBindJSON(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateProductCardTemplate(&tpl); err != nil {
+		writeProductError(c, err)
+		return
+	}
+
+	err := DB.QueryRow(`
+		INSERT INTO product_card_templates (name, format, body)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, tpl.Name, tpl.Format, tpl.Body).Scan(&tpl.ID)
+	if err != nil {
+		writeProductCardTemplateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tpl)
+}
+
+func UpdateProductCardTemplate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var tpl models.ProductCardTemplate
+	if err := c.ShouldBindJSON(&tpl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateProductCardTemplate(&tpl); err != nil {
+		writeProductError(c, err)
+		return
+	}
+
+	var currentName string
+	err = DB.QueryRow(`SELECT name FROM product_card_templates WHERE id = $1`, id).Scan(&currentName)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// default можно править, но не переименовывать: GET /products/:id/card без ?template= берёт его.
+	if currentName == defaultProductCardTemplateName && tpl.Name != currentName {
+		c.JSON(http.StatusConflict, gin.H{"error": "The default template cannot be renamed"})
+		return
+	}
+
+	_, err = DB.Exec(`
+		UPDATE product_card_templates
+		SET name = $1, format = $2, body = $3
+		WHERE id = $4
+	`, tpl.Name, tpl.Format, tpl.Body, id)
+	if err != nil {
+		writeProductCardTemplateError(c, err)
+		return
+	}
+
+	tpl.ID = id
+	c.JSON(http.StatusOK, tpl)
+}
+
+func DeleteProductCardTemplate(c *gin.Context) {
+	id := c.Param("id")
+
+	var name string
+	err := DB.QueryRow(`SELECT name FROM product_card_templates WHERE id = $1`, id).Scan(&name)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if name == defaultProductCardTemplateName {
+		c.JSON(http.StatusConflict, gin.H{"error": "The default template cannot be deleted"})
+		return
+	}
+
+	if _, err := DB.Exec(`DELETE FROM product_card_templates WHERE id = $1`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Template deleted successfully"})
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Talonmortem/SHM/internal/models"
+)
+
+func TestValidateProductCardTemplate(t *testing.T) {
+	valid := []models.ProductCardTemplate{
+		{Name: " short ", Body: "{{.Name}}: {{num .RublePriceWithDiscount}} ₽"},
+		// Индекс в пустом на момент проверки списке — ошибка выполнения, а не шаблона.
+		{Name: "first article", Format: "Markdown", Body: "**{{(index .Articles 0).Code}}** {{.Name}}"},
+		{Name: "html", Format: "html", Body: "<b>{{.Name}}</b>{{range .Articles}}<i>{{.Description}}</i>{{end}}"},
+	}
+	for _, tpl := range valid {
+		if err := validateProductCardTemplate(&tpl); err != nil {
+			t.Errorf("template %q: %v", tpl.Name, err)
+		}
+	}
+
+	invalid := []struct {
+		tpl  models.ProductCardTemplate
+		want string
+	}{
+		{models.ProductCardTemplate{Name: " ", Body: "x"}, "name is required"},
+		{models.ProductCardTemplate{Name: "a", Format: "pdf", Body: "x"}, "Format must be"},
+		{models.ProductCardTemplate{Name: "a", Body: "  "}, "body is required"},
+		{models.ProductCardTemplate{Name: "a", Body: "{{.Name"}, "Invalid template"},
+		{models.ProductCardTemplate{Name: "a", Body: "{{price .Name}}"}, "Invalid template"},
+	}
+	for _, tt := range invalid {
+		err := validateProductCardTemplate(&tt.tpl)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("template %q: error = %v, want %q", tt.tpl.Body, err, tt.want)
+		}
+	}
+}
+
+func TestRenderProductCard(t *testing.T) {
+	card := buildProductCard(models.Product{
+		Name: "Мешок 5", Status: 1, Weight: "10", Skidka: "20", SummaRubSoSkidkoj: "8000", Count: 40,
+		ArticlesInProduct: []models.ArticleInProduct{{SumEvro: "100", SumRub: "10000"}},
+	}, []productCardArticle{{Code: "A-1", Description: "Куртки"}})
+
+	text, err := renderProductCard(models.ProductCardTemplate{Name: defaultProductCardTemplateName, Body: defaultProductCardTemplateBody}, card)
+	if err != nil {
+		t.Fatalf("default template: %v", err)
+	}
+	for _, want := range []string{"Мешок 5\n", "Статус: На продаже", "Скидка 20 %", "Цена со скидкой ₽: 8000", "Цена за кг €: 10.00", "Себестоимость одной вещи: 200.00 ₽"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("default card has no %q:\n%s", want, text)
+		}
+	}
+
+	html, err := renderProductCard(models.ProductCardTemplate{Name: "html", Format: "html", Body: "<b>{{.Name}}</b> {{(index .Articles 0).Description}}"},
+		buildProductCard(models.Product{Name: "<script>"}, []productCardArticle{{Description: "Обувь"}}))
+	if err != nil || html != "<b>&lt;script&gt;</b> Обувь" {
+		t.Errorf("html card = %q, %v", html, err)
+	}
+	if _, err := renderProductCard(models.ProductCardTemplate{Name: "first", Body: "{{(index .Articles 0).Code}}"}, productCard{}); err == nil {
+		t.Error("index into an empty article list must fail when rendering")
+	}
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Возврат денег — отрицательная оплата заказа типа refund с обязательной причиной.
+// Создаются и меняются возвраты только через /orders/:id/refunds, чтобы право на них
+// настраивалось в role_permissions отдельно от обычных оплат.
+
+const (
+	paymentTypePayment = "payment"
+	paymentTypeRefund  = "refund"
+)
+
+var paymentTypeNames = map[string]string{
+	paymentTypePayment: "Оплата",
+	paymentTypeRefund:  "Возврат",
+}
+
+// CreateTablesRefunds добавляет оплатам тип, причину и возвращённый товар. Отрицательные оплаты,
+// созданные раньше (возвраты товара), становятся возвратами с причиной из комментария.
+func CreateTablesRefunds() {
+	_, err := DB.Exec(`
+		ALTER TABLE payments_monitoring ADD COLUMN IF NOT EXISTS type TEXT NOT NULL DEFAULT 'payment';
+		ALTER TABLE payments_monitoring ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+		ALTER TABLE payments_monitoring ADD COLUMN IF NOT EXISTS product_id BIGINT REFERENCES products(id) ON DELETE SET NULL;
+
+		UPDATE payments_monitoring
+		SET type = 'refund', reason = COALESCE(NULLIF(comment, ''), 'Возврат')
+		WHERE amount < 0 AND type = 'payment';
+
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'chk_payments_monitoring_type') THEN
+				ALTER TABLE payments_monitoring
+					ADD CONSTRAINT chk_payments_monitoring_type CHECK (
+						(type = 'payment' AND (amount IS NULL OR amount >= 0))
+						OR (type = 'refund' AND amount < 0 AND reason <> '')
+					);
+			END IF;
+		END $$;
+	`)
+	if err != nil {
+		log.Fatal("Failed to migrate refunds:", err)
+	}
+	log.Println("Refunds are ready")
+}
+
+// normalizePaymentType приводит тип оплаты к payment или refund и знак суммы — к типу:
+// сумму возврата можно передать и положительной. Вызывается после applyPaymentCurrency.
+func normalizePaymentType(p *models.Payment) error {
+	p.Type = strings.ToLower(strings.TrimSpace(p.Type))
+	p.Reason = strings.TrimSpace(p.Reason)
+	switch p.Type {
+	case "", paymentTypePayment:
+		p.Type = paymentTypePayment
+		p.Reason = ""
+		p.ProductID = nil
+		if p.Amount <= 0 {
+			return newBadRequestError("Payment amount must be positive")
+		}
+	case paymentTypeRefund:
+		if p.Reason == "" {
+			return newBadRequestError("Refund reason is required")
+		}
+		if p.Amount == 0 {
+			return newBadRequestError("Refund amount must not be zero")
+		}
+		p.Amount = -math.Abs(p.Amount)
+		p.OriginalAmount = -math.Abs(p.OriginalAmount)
+	default:
+		return newBadRequestError("Invalid payment type: expected payment or refund")
+	}
+	return nil
+}
+
+// validateRefundProduct проверяет, что возвращённый товар был в заказе: он ещё в заказе
+// или уже отвязан от него оприходованным возвратом.
+func validateRefundProduct(tx *sql.Tx, orderID int, productID *int) error {
+	if productID == nil {
+		return nil
+	}
+	var found bool
+	err := tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM order_products WHERE order_id = $1 AND product_id = $2)
+			OR EXISTS(
+				SELECT 1 FROM return_items ri
+				JOIN returns r ON r.id = ri.return_id
+				WHERE r.order_id = $1 AND ri.product_id = $2
+			)
+	`, orderID, *productID).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return newBadRequestError(fmt.Sprintf("Product %d is not part of order %d", *productID, orderID))
+	}
+	return nil
+}
+
+func CreateOrderRefund(c *gin.Context) {
+	createOrderPayment(c, paymentTypeRefund)
+}
+
+func UpdateOrderRefund(c *gin.Context) {
+	updateOrderPayment(c, paymentTypeRefund)
+}
+
+func DeleteOrderRefund(c *gin.Context) {
+	deleteOrderPayment(c, paymentTypeRefund)
+}
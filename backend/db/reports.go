@@ -0,0 +1,198 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Talonmortem/SHM/internal/export"
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	receivablesBasisOrder = "order"
+	receivablesBasisShip  = "ship"
+)
+
+// parseShipDate разбирает orders.ship_date, выбранную как YYYY-MM-DD.
+func parseShipDate(raw string) (time.Time, bool) {
+	t, err := time.ParseInLocation(dateLayout, strings.TrimSpace(raw), businessLocation)
+	return t, err == nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, businessLocation)
+}
+
+func addToBuckets(b *models.ReceivablesBuckets, ageDays int, amount float64) {
+	switch {
+	case ageDays <= 7:
+		b.Days0to7 += amount
+	case ageDays <= 30:
+		b.Days8to30 += amount
+	case ageDays <= 90:
+		b.Days31to90 += amount
+	default:
+		b.Days90Plus += amount
+	}
+	b.Total += amount
+}
+
+func roundBuckets(b *models.ReceivablesBuckets) {
+	for _, v := range []*float64{&b.Days0to7, &b.Days8to30, &b.Days31to90, &b.Days90Plus, &b.Total} {
+		*v = math.Round(*v*100) / 100
+	}
+}
+
+// receivableClientKey группирует заказы: по карточке клиента, без неё — по телефону,
+// затем по ФИО. Заказ без контактов остаётся отдельной строкой.
+func receivableClientKey(orderID int, clientID sql.NullInt64, fullName, phone string) string {
+	if clientID.Valid {
+		return fmt.Sprintf("client:%d", clientID.Int64)
+	}
+	if key := normalizePhone(phone); len(key) == 10 {
+		return "phone:" + key
+	}
+	if key := normalizeClientName(fullName); key != "" {
+		return "name:" + key
+	}
+	return fmt.Sprintf("order:%d", orderID)
+}
+
+// BuildReceivablesReport считает долги по незакрытым заказам на текущую дату.
+// Возраст долга — дни с даты заказа или, при basis=ship, с даты отгрузки (если она ещё не указана — с даты заказа).
+func BuildReceivablesReport(basis string) (models.ReceivablesReport, error) {
+	now := businessNow()
+	today := startOfDay(now)
+	report := models.ReceivablesReport{
+		AsOf:    now.Format("2006-01-02"),
+		Basis:   basis,
+		Clients: make([]models.ReceivableClient, 0),
+	}
+
+	rows, err := DB.Query(`
+		SELECT o.id, o.name, o.status, o.debt, o.created_at, COALESCE(to_char(o.ship_date, 'YYYY-MM-DD'), ''), o.client_id,
+			COALESCE(NULLIF(cl.full_name, ''), o.full_name, ''), COALESCE(NULLIF(cl.phone, ''), o.phone, '')
+		FROM orders o
+		LEFT JOIN clients cl ON cl.id = o.client_id
+		WHERE o.status NOT IN ($1, $2) AND o.debt > 0.005
+		ORDER BY o.created_at, o.id
+	`, orderStatusCancelled, orderStatusReturned)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string]*models.ReceivableClient)
+	var keys []string
+	for rows.Next() {
+		var order models.ReceivableOrder
+		var createdAt sql.NullTime
+		var clientID sql.NullInt64
+		var fullName, phone string
+		if err := rows.Scan(&order.OrderID, &order.Name, &order.Status, &order.Debt, &createdAt, &order.ShipDate, &clientID, &fullName, &phone); err != nil {
+			return report, err
+		}
+
+		since := today
+		if createdAt.Valid {
+			order.CreatedAt = createdAt.Time.Format(paymentDateTimeLayout)
+			since = startOfDay(createdAt.Time)
+		}
+		if basis == receivablesBasisShip {
+			if shipped, ok := parseShipDate(order.ShipDate); ok {
+				since = shipped
+			}
+		}
+		order.AgeDays = int(today.Sub(since).Hours() / 24)
+		if order.AgeDays < 0 {
+			order.AgeDays = 0
+		}
+
+		key := receivableClientKey(order.OrderID, clientID, fullName, phone)
+		group, exists := groups[key]
+		if !exists {
+			group = &models.ReceivableClient{FullName: fullName, Phone: phone}
+			if clientID.Valid {
+				group.ClientID = int(clientID.Int64)
+			}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		group.Orders = append(group.Orders, order)
+		addToBuckets(&group.Buckets, order.AgeDays, order.Debt)
+		addToBuckets(&report.Totals, order.AgeDays, order.Debt)
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	for _, key := range keys {
+		group := groups[key]
+		roundBuckets(&group.Buckets)
+		report.Clients = append(report.Clients, *group)
+	}
+	roundBuckets(&report.Totals)
+	sort.SliceStable(report.Clients, func(i, j int) bool {
+		return report.Clients[i].Buckets.Total > report.Clients[j].Buckets.Total
+	})
+	return report, nil
+}
+
+func receivablesTable(report models.ReceivablesReport) export.Table {
+	table := export.Table{
+		Name:    "Дебиторка " + report.AsOf,
+		Columns: []string{"Клиент", "Телефон", "ID клиента", "Заказы", "0–7 дней", "8–30 дней", "31–90 дней", "90+ дней", "Итого"},
+	}
+	for _, client := range report.Clients {
+		orderIDs := make([]int, 0, len(client.Orders))
+		for _, order := range client.Orders {
+			orderIDs = append(orderIDs, order.OrderID)
+		}
+		var clientID any
+		if client.ClientID != 0 {
+			clientID = client.ClientID
+		}
+		table.Rows = append(table.Rows, []any{
+			client.FullName, client.Phone, clientID, joinIDs(orderIDs),
+			client.Buckets.Days0to7, client.Buckets.Days8to30, client.Buckets.Days31to90, client.Buckets.Days90Plus, client.Buckets.Total,
+		})
+	}
+	totals := report.Totals
+	table.Rows = append(table.Rows, []any{
+		"Итого", nil, nil, nil,
+		totals.Days0to7, totals.Days8to30, totals.Days31to90, totals.Days90Plus, totals.Total,
+	})
+	return table
+}
+
+// GetReceivablesReport — дебиторская задолженность по клиентам с разбивкой по возрасту долга.
+// ?basis=order|ship выбирает дату отсчёта, ?format=csv|xlsx отдаёт файл вместо JSON.
+func GetReceivablesReport(c *gin.Context) {
+	basis := strings.ToLower(strings.TrimSpace(c.DefaultQuery("basis", receivablesBasisOrder)))
+	if basis != receivablesBasisOrder && basis != receivablesBasisShip {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid basis: expected order or ship"})
+		return
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
+	report, err := BuildReceivablesReport(basis)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if format != "" {
+		writeExport(c, format, "receivables-"+report.AsOf, receivablesTable(report))
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
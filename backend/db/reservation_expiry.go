@@ -0,0 +1,264 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	reservationExpiryWarn    = "warn"
+	reservationExpiryRelease = "release"
+
+	reservationExpiryInterval = time.Hour
+)
+
+// orderUnpaidCondition — у заказа o нет ни своих оплат, ни зачтённых в него клиентских оплат
+// (отклонённые не считаются).
+const orderUnpaidCondition = `NOT EXISTS (SELECT 1 FROM payments_monitoring pm WHERE pm.order_id = o.id AND pm.` + paymentCountsAsPaid + `)
+			AND NOT EXISTS (
+				SELECT 1 FROM payment_allocations pa
+				JOIN payments_monitoring pm ON pm.id = pa.payment_id
+				WHERE pa.order_id = o.id AND pm.` + paymentCountsAsPaid + `
+			)`
+
+func CreateTablesReservationExpiry() {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS reservation_expiry_events (
+			id BIGSERIAL PRIMARY KEY,
+			order_id BIGINT NOT NULL,
+			action TEXT NOT NULL,
+			order_name TEXT,
+			full_name TEXT,
+			phone TEXT,
+			product_ids TEXT,
+			order_created_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_reservation_expiry_events_order_id ON reservation_expiry_events(order_id);
+		CREATE INDEX IF NOT EXISTS idx_orders_status_created_at ON orders(status, created_at);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create reservation expiry table:", err)
+	}
+	log.Println("Reservation expiry table is ready")
+}
+
+// StartReservationExpiryWatcher раз в час ищет новые заказы без оплат старше days дней
+// и либо предупреждает о них, либо снимает бронь с товаров.
+func StartReservationExpiryWatcher(days int, action string) {
+	if days <= 0 {
+		log.Println("Reservation expiry is disabled")
+		return
+	}
+	if action != reservationExpiryRelease {
+		action = reservationExpiryWarn
+	}
+	log.Printf("Reservation expiry: %d days, action=%s", days, action)
+
+	go func() {
+		ticker := time.NewTicker(reservationExpiryInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := ExpireReservations(days, action); err != nil {
+				log.Printf("Reservation expiry failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Reservation expiry: %d orders processed (%s)", n, action)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// ExpireReservations обрабатывает заказы со статусом 0 (Новый), у которых нет ни одной оплаты, в том числе
+// зачтённой с баланса клиента, и которые созданы раньше чем days дней назад. Для warn каждое событие пишется один раз,
+// для release заказ отменяется (статус 3) и товары возвращаются в продажу.
+func ExpireReservations(days int, action string) (int, error) {
+	rows, err := DB.Query(`
+		SELECT o.id
+		FROM orders o
+		WHERE o.status = 0
+			AND o.created_at < NOW() - make_interval(days => $1)
+			AND `+orderUnpaidCondition+`
+			AND NOT EXISTS (
+				SELECT 1 FROM reservation_expiry_events e
+				WHERE e.order_id = o.id AND e.action = $2
+			)
+		ORDER BY o.id
+	`, days, action)
+	if err != nil {
+		return 0, err
+	}
+	var orderIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		orderIDs = append(orderIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for _, orderID := range orderIDs {
+		if err := expireOrderReservation(orderID, days, action); err != nil {
+			log.Printf("Reservation expiry for order %d failed: %v", orderID, err)
+			continue
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+func expireOrderReservation(orderID, days int, action string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Повторная проверка под блокировкой: за время обхода заказ могли оплатить или изменить.
+	var event models.ReservationExpiryEvent
+	var name, fullName, phone sql.NullString
+	var createdAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT o.name, COALESCE(NULLIF(o.full_name, ''), cl.full_name), COALESCE(NULLIF(o.phone, ''), cl.phone), o.created_at
+		FROM orders o
+		LEFT JOIN clients cl ON cl.id = o.client_id
+		WHERE o.id = $1
+			AND o.status = 0
+			AND o.created_at < NOW() - make_interval(days => $2)
+			AND `+orderUnpaidCondition+`
+		FOR UPDATE OF o
+	`, orderID, days).Scan(&name, &fullName, &phone, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	productIDs, err := orderProductIDs(tx, orderID)
+	if err != nil {
+		return err
+	}
+	if action == reservationExpiryRelease {
+		if err := closeOrder(tx, orderID, orderStatusCancelled, "", "Истекла бронь"); err != nil {
+			return err
+		}
+	}
+
+	event.OrderID = orderID
+	event.Action = action
+	event.OrderName = name.String
+	event.FullName = fullName.String
+	event.Phone = phone.String
+	event.ProductIDs = productIDs
+	if createdAt.Valid {
+		event.OrderCreatedAt = createdAt.Time.Format(paymentDateTimeLayout)
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO reservation_expiry_events (order_id, action, order_name, full_name, phone, product_ids, order_created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, event.OrderID, event.Action, event.OrderName, event.FullName, event.Phone, joinIDs(productIDs), createdAt); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Reservation expired for order %d (%s), products %v", orderID, action, productIDs)
+	return nil
+}
+
+func orderProductIDs(tx *sql.Tx, orderID int) ([]int, error) {
+	rows, err := tx.Query("SELECT product_id FROM order_products WHERE order_id = $1 ORDER BY product_id", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func joinIDs(ids []int) string {
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, strconv.Itoa(id))
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitIDs(raw string) []int {
+	ids := make([]int, 0)
+	for _, part := range strings.Split(raw, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func GetReservationExpiryEvents(c *gin.Context) {
+	query := `
+		SELECT id, order_id, action, COALESCE(order_name, ''), COALESCE(full_name, ''), COALESCE(phone, ''),
+			COALESCE(product_ids, ''), order_created_at, created_at
+		FROM reservation_expiry_events
+	`
+	args := []any{}
+	if action := strings.TrimSpace(c.Query("action")); action != "" {
+		args = append(args, action)
+		query += " WHERE action = $1"
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	events := make([]models.ReservationExpiryEvent, 0)
+	for rows.Next() {
+		var event models.ReservationExpiryEvent
+		var productIDs string
+		var orderCreatedAt, createdAt sql.NullTime
+		if err := rows.Scan(&event.ID, &event.OrderID, &event.Action, &event.OrderName, &event.FullName, &event.Phone,
+			&productIDs, &orderCreatedAt, &createdAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		event.ProductIDs = splitIDs(productIDs)
+		if orderCreatedAt.Valid {
+			event.OrderCreatedAt = orderCreatedAt.Time.Format(paymentDateTimeLayout)
+		}
+		if createdAt.Valid {
+			event.CreatedAt = createdAt.Time.Format(paymentDateTimeLayout)
+		}
+		events = append(events, event)
+	}
+
+	c.JSON(http.StatusOK, events)
+}
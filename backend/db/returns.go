@@ -0,0 +1,544 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	returnStatusCreated  = "created"
+	returnStatusReceived = "received"
+
+	returnDispositionRestock   = "restock"   // мешок возвращается в продажу (статус 1)
+	returnDispositionBreakdown = "breakdown" // мешок разбирается обратно в кг артикулов
+)
+
+func CreateTablesReturns() {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS returns (
+			id BIGSERIAL PRIMARY KEY,
+			order_id BIGINT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'created',
+			reason TEXT NOT NULL,
+			refund_amount DOUBLE PRECISION NOT NULL DEFAULT 0,
+			refund_method TEXT,
+			refund_payment_id BIGINT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			received_at TIMESTAMP,
+			FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE IF NOT EXISTS return_items (
+			id BIGSERIAL PRIMARY KEY,
+			return_id BIGINT NOT NULL,
+			product_id BIGINT NOT NULL,
+			product_name TEXT,
+			amount DOUBLE PRECISION,
+			weight TEXT,
+			disposition TEXT NOT NULL DEFAULT 'restock',
+			FOREIGN KEY (return_id) REFERENCES returns(id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_returns_order_id ON returns(order_id);
+		CREATE INDEX IF NOT EXISTS idx_return_items_return_id ON return_items(return_id);
+		CREATE INDEX IF NOT EXISTS idx_return_items_product_id ON return_items(product_id);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create returns tables:", err)
+	}
+	log.Println("Returns tables are ready")
+}
+
+func normalizeReturnDisposition(raw string) (string, error) {
+	value := strings.ToLower(strings.TrimSpace(raw))
+	switch value {
+	case "":
+		return returnDispositionRestock, nil
+	case returnDispositionRestock, returnDispositionBreakdown:
+		return value, nil
+	}
+	return "", newBadRequestError("Disposition must be restock or breakdown")
+}
+
+func GetReturns(c *gin.Context) {
+	query := `
+		SELECT r.id, r.order_id, r.status, r.reason, r.refund_amount, COALESCE(r.refund_method, ''),
+			r.created_at, r.received_at,
+			ri.id, ri.product_id, COALESCE(ri.product_name, ''), COALESCE(ri.amount, 0), COALESCE(ri.weight, ''), ri.disposition
+		FROM returns r
+		LEFT JOIN return_items ri ON ri.return_id = r.id
+	`
+	args := []any{}
+	if orderID := strings.TrimSpace(c.Query("order_id")); orderID != "" {
+		args = append(args, orderID)
+		query += " WHERE r.order_id = $1"
+	}
+	query += " ORDER BY r.id DESC, ri.id"
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	returns := make([]models.Return, 0)
+	index := make(map[int]int)
+	for rows.Next() {
+		var r models.Return
+		var createdAt, receivedAt sql.NullTime
+		var itemID, productID sql.NullInt64
+		var item models.ReturnItem
+		var disposition sql.NullString
+		if err := rows.Scan(&r.ID, &r.OrderID, &r.Status, &r.Reason, &r.RefundAmount, &r.RefundMethod,
+			&createdAt, &receivedAt,
+			&itemID, &productID, &item.ProductName, &item.Amount, &item.Weight, &disposition); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		pos, exists := index[r.ID]
+		if !exists {
+			if createdAt.Valid {
+				r.CreatedAt = createdAt.Time.Format(paymentDateTimeLayout)
+			}
+			if receivedAt.Valid {
+				r.ReceivedAt = receivedAt.Time.Format(paymentDateTimeLayout)
+			}
+			r.Items = make([]models.ReturnItem, 0)
+			returns = append(returns, r)
+			pos = len(returns) - 1
+			index[r.ID] = pos
+		}
+
+		if itemID.Valid {
+			item.ID = int(itemID.Int64)
+			item.ProductID = int(productID.Int64)
+			item.Disposition = disposition.String
+			returns[pos].Items = append(returns[pos].Items, item)
+		}
+	}
+
+	c.JSON(http.StatusOK, returns)
+}
+
+func CreateReturn(c *gin.Context) {
+	var ret models.Return
+	if err := c.ShouldBindJSON(&ret); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ret.Reason = strings.TrimSpace(ret.Reason)
+	ret.RefundMethod = strings.TrimSpace(ret.RefundMethod)
+	if ret.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Причина возврата обязательна"})
+		return
+	}
+	if len(ret.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one product must be selected"})
+		return
+	}
+	if ret.RefundAmount < 0 || math.IsNaN(ret.RefundAmount) || math.IsInf(ret.RefundAmount, 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refund amount must be zero or positive"})
+		return
+	}
+	if ret.RefundAmount > 0 {
+		usable, err := paymentMethodUsable(DB, ret.RefundMethod)
+		if err != nil || !usable {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or disabled refund payment method: " + ret.RefundMethod})
+			return
+		}
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var orderStatus int
+	if err := tx.QueryRow("SELECT status FROM orders WHERE id = $1 FOR UPDATE", ret.OrderID).Scan(&orderStatus); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	// Возвращённый целиком заказ (ReturnOrder) держит мешки проданными до оприходования возврата.
+	if orderStatus != orderStatusShipped && orderStatus != orderStatusReturned {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Returns can only be created for shipped or returned orders"})
+		return
+	}
+	if err := checkShippedOrderPeriodOpen(tx, ret.OrderID); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	seen := make(map[int]struct{}, len(ret.Items))
+	for i := range ret.Items {
+		item := &ret.Items[i]
+		if _, dup := seen[item.ProductID]; dup {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("duplicate product in return: %d", item.ProductID)})
+			return
+		}
+		seen[item.ProductID] = struct{}{}
+
+		item.Disposition, err = normalizeReturnDisposition(item.Disposition)
+		if err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		if err := loadReturnItemProduct(tx, ret.OrderID, item); err != nil {
+			writeOrderError(c, err)
+			return
+		}
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO returns (order_id, status, reason, refund_amount, refund_method)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		RETURNING id
+	`, ret.OrderID, returnStatusCreated, ret.Reason, ret.RefundAmount, ret.RefundMethod).Scan(&ret.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create return: " + err.Error()})
+		return
+	}
+
+	for i := range ret.Items {
+		item := &ret.Items[i]
+		err := tx.QueryRow(`
+			INSERT INTO return_items (return_id, product_id, product_name, amount, weight, disposition)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		`, ret.ID, item.ProductID, item.ProductName, item.Amount, item.Weight, item.Disposition).Scan(&item.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product to return: " + err.Error()})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+
+	ret.Status = returnStatusCreated
+	c.JSON(http.StatusOK, ret)
+}
+
+// loadReturnItemProduct проверяет, что мешок отправлен в этом заказе и ещё не участвует
+// в другом открытом возврате, и запоминает его название, сумму и вес на момент возврата.
+func loadReturnItemProduct(tx *sql.Tx, orderID int, item *models.ReturnItem) error {
+	var status int
+	var name, amount, weight sql.NullString
+	err := tx.QueryRow(`
+		SELECT p.status, p.name, p.summaRubSoSkidkoj, p.weight
+		FROM products p
+		JOIN order_products op ON op.product_id = p.id
+		WHERE p.id = $1 AND op.order_id = $2
+	`, item.ProductID, orderID).Scan(&status, &name, &amount, &weight)
+	if err == sql.ErrNoRows {
+		return newBadRequestError(fmt.Sprintf("product %d is not part of order %d", item.ProductID, orderID))
+	}
+	if err != nil {
+		return err
+	}
+	if status != 3 {
+		return newBadRequestError(fmt.Sprintf("product %d is not sold and cannot be returned", item.ProductID))
+	}
+
+	var pending bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM return_items ri
+			JOIN returns r ON r.id = ri.return_id
+			WHERE ri.product_id = $1 AND r.status = $2
+		)
+	`, item.ProductID, returnStatusCreated).Scan(&pending)
+	if err != nil {
+		return err
+	}
+	if pending {
+		return newBadRequestError(fmt.Sprintf("product %d already has an open return", item.ProductID))
+	}
+
+	item.ProductName = name.String
+	item.Weight = weight.String
+	item.Amount, err = parseAmount(amount.String)
+	if err != nil {
+		log.Printf("Failed to parse product price for product %d: %v", item.ProductID, err)
+		item.Amount = 0
+	}
+	return nil
+}
+
+type receiveReturnRequest struct {
+	Items []models.ReturnItem `json:"items"` // необязательно: переопределить disposition по product_id
+}
+
+// ReceiveReturn оприходует возврат: мешки отвязываются от заказа и либо возвращаются в продажу,
+// либо разбираются в кг артикулов; сумма возврата проводится отрицательной оплатой по заказу.
+// Если в отгруженном заказе не осталось товаров, он переводится в статус Возврат.
+func ReceiveReturn(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+		return
+	}
+
+	var req receiveReturnRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	overrides := make(map[int]string, len(req.Items))
+	for _, item := range req.Items {
+		disposition, err := normalizeReturnDisposition(item.Disposition)
+		if err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		overrides[item.ProductID] = disposition
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to begin transaction: " + err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var ret models.Return
+	var refundMethod sql.NullString
+	err = tx.QueryRow(`
+		SELECT id, order_id, status, reason, refund_amount, refund_method
+		FROM returns
+		WHERE id = $1
+		FOR UPDATE
+	`, id).Scan(&ret.ID, &ret.OrderID, &ret.Status, &ret.Reason, &ret.RefundAmount, &refundMethod)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if ret.Status != returnStatusCreated {
+		c.JSON(http.StatusConflict, gin.H{"error": "Return is already received"})
+		return
+	}
+	ret.RefundMethod = refundMethod.String
+
+	if err := lockOrder(tx, ret.OrderID); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+	if err := checkShippedOrderPeriodOpen(tx, ret.OrderID); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	itemRows, err := tx.Query("SELECT id, product_id, disposition FROM return_items WHERE return_id = $1 ORDER BY id", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for itemRows.Next() {
+		var item models.ReturnItem
+		if err := itemRows.Scan(&item.ID, &item.ProductID, &item.Disposition); err != nil {
+			itemRows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if disposition, ok := overrides[item.ProductID]; ok {
+			item.Disposition = disposition
+		}
+		ret.Items = append(ret.Items, item)
+	}
+	itemRows.Close()
+
+	for _, item := range ret.Items {
+		if err := receiveReturnItem(tx, ret.OrderID, item); err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		if _, err := tx.Exec("UPDATE return_items SET disposition = $1 WHERE id = $2", item.Disposition, item.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var refundPaymentID sql.NullInt64
+	if ret.RefundAmount > 0 {
+		productID := refundProductID(ret.Items)
+		refund := models.Payment{Date: currentPaymentDateTime(), Method: ret.RefundMethod, Amount: -ret.RefundAmount}
+		if err := checkPeriodOpen(tx, refund.Date); err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		if err := applyBaseAmountCurrency(tx, &refund); err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		var paymentID int64
+		err := tx.QueryRow(
+			`INSERT INTO payments_monitoring (date, method, order_id, amount, comment, type, reason, product_id, currency, original_amount, rate)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+			refund.Date, refund.Method, ret.OrderID, refund.Amount, fmt.Sprintf("Возврат №%d: %s", ret.ID, ret.Reason),
+			paymentTypeRefund, ret.Reason, productID, refund.Currency, refund.OriginalAmount, refund.Rate,
+		).Scan(&paymentID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record refund: " + err.Error()})
+			return
+		}
+		// Возврат денег от того, кто не может подтверждать оплаты, ждёт проверки менеджером.
+		confirm, err := mayConfirmPayments(tx, c.GetInt("role_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record refund: " + err.Error()})
+			return
+		}
+		if confirm {
+			if err := markPaymentConfirmed(tx, int(paymentID), c.GetString("username")); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record refund: " + err.Error()})
+				return
+			}
+		}
+		refundPaymentID = sql.NullInt64{Int64: paymentID, Valid: true}
+	}
+
+	// Состав заказа изменился: открытая у кого-то форма заказа должна получить 409.
+	if _, err := tx.Exec("UPDATE orders SET version = version + 1 WHERE id = $1", ret.OrderID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := recalculateOrderDebt(tx, ret.OrderID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recalculate order debt: " + err.Error()})
+		return
+	}
+
+	var remaining, orderStatus int
+	var debt float64
+	if err := tx.QueryRow("SELECT quantity, COALESCE(debt, 0), status FROM orders WHERE id = $1", ret.OrderID).Scan(&remaining, &debt, &orderStatus); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Заказ, уже закрытый через ReturnOrder, сохраняет свои решение по оплатам и причину.
+	if remaining == 0 && orderStatus == orderStatusShipped {
+		resolution := ""
+		if debt < 0 {
+			resolution = paymentResolutionCredit
+		}
+		if _, err := tx.Exec(`
+			UPDATE orders
+			SET status = $1, payment_resolution = NULLIF($2, ''), close_reason = $3, closed_at = CURRENT_TIMESTAMP
+			WHERE id = $4
+		`, orderStatusReturned, resolution, ret.Reason, ret.OrderID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close returned order: " + err.Error()})
+			return
+		}
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE returns
+		SET status = $1, received_at = CURRENT_TIMESTAMP, refund_payment_id = $2
+		WHERE id = $3
+	`, returnStatusReceived, refundPaymentID, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction: " + err.Error()})
+		return
+	}
+	for _, item := range ret.Items {
+		if item.Disposition == returnDispositionBreakdown {
+			purgeEntityAttachments(AttachmentProduct, item.ProductID)
+		}
+	}
+
+	ret.Status = returnStatusReceived
+	c.JSON(http.StatusOK, ret)
+}
+
+// refundProductID — мешок, на который ссылается возврат денег. Возврат за один мешок ссылается на него,
+// за несколько — только на документ возврата в комментарии. Разобранный мешок к этому моменту
+// уже удалён из products, поэтому ссылки на него нет.
+func refundProductID(items []models.ReturnItem) *int {
+	if len(items) != 1 || items[0].Disposition == returnDispositionBreakdown {
+		return nil
+	}
+	return &items[0].ProductID
+}
+
+func receiveReturnItem(tx *sql.Tx, orderID int, item models.ReturnItem) error {
+	var status int
+	err := tx.QueryRow(`
+		SELECT p.status
+		FROM products p
+		JOIN order_products op ON op.product_id = p.id
+		WHERE p.id = $1 AND op.order_id = $2
+		FOR UPDATE OF p
+	`, item.ProductID, orderID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return newBadRequestError(fmt.Sprintf("product %d is no longer part of order %d", item.ProductID, orderID))
+	}
+	if err != nil {
+		return err
+	}
+	if status != 3 {
+		return newBadRequestError(fmt.Sprintf("product %d is not sold and cannot be returned", item.ProductID))
+	}
+
+	if _, err := tx.Exec("DELETE FROM order_products WHERE order_id = $1 AND product_id = $2", orderID, item.ProductID); err != nil {
+		return err
+	}
+
+	switch item.Disposition {
+	case returnDispositionRestock:
+		_, err := tx.Exec("UPDATE products SET version = version + 1, status = 1 WHERE id = $1", item.ProductID)
+		return err
+	case returnDispositionBreakdown:
+		weights, err := getReservedArticleWeightsByProductID(tx, item.ProductID)
+		if err != nil {
+			return err
+		}
+		if err := releaseArticleStock(tx, weights); err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM products WHERE id = $1", item.ProductID)
+		return err
+	}
+	return fmt.Errorf("unexpected disposition %q", item.Disposition)
+}
+
+func DeleteReturn(c *gin.Context) {
+	id := c.Param("id")
+
+	result, err := DB.Exec("DELETE FROM returns WHERE id = $1 AND status = $2", id, returnStatusCreated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if affected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Return not found or already received"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Return deleted successfully"})
+}
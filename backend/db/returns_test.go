@@ -0,0 +1,26 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/Talonmortem/SHM/internal/models"
+)
+
+func TestRefundProductID(t *testing.T) {
+	restock := models.ReturnItem{ProductID: 7, Disposition: returnDispositionRestock}
+	breakdown := models.ReturnItem{ProductID: 8, Disposition: returnDispositionBreakdown}
+
+	if got := refundProductID([]models.ReturnItem{restock}); got == nil || *got != 7 {
+		t.Errorf("restocked bag: product = %v, want 7", got)
+	}
+	// Разобранный мешок удаляется до записи возврата денег: ссылка на него нарушила бы внешний ключ.
+	if got := refundProductID([]models.ReturnItem{breakdown}); got != nil {
+		t.Errorf("broken down bag: product = %d, want none", *got)
+	}
+	if got := refundProductID([]models.ReturnItem{restock, breakdown}); got != nil {
+		t.Errorf("several bags: product = %d, want none", *got)
+	}
+	if got := refundProductID(nil); got != nil {
+		t.Errorf("no bags: product = %d, want none", *got)
+	}
+}
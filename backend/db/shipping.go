@@ -5,6 +5,7 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/Talonmortem/SHM/internal/models"
@@ -15,7 +16,7 @@ func CreateTablesShipping() {
 	_, err := DB.Exec(`
 		CREATE TABLE IF NOT EXISTS shipments (
 			id BIGSERIAL PRIMARY KEY,
-			ship_date TEXT NOT NULL,
+			ship_date DATE NOT NULL,
 			city TEXT,
 			full_name TEXT NOT NULL,
 			phone TEXT,
@@ -28,13 +29,13 @@ func CreateTablesShipping() {
 
 		CREATE TABLE IF NOT EXISTS shipment_notes (
 			id BIGSERIAL PRIMARY KEY,
-			ship_date TEXT NOT NULL,
+			ship_date DATE NOT NULL,
 			note TEXT,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		);
 
 		CREATE TABLE IF NOT EXISTS courier_daily_payments (
-			ship_date TEXT PRIMARY KEY,
+			ship_date DATE PRIMARY KEY,
 			amount DOUBLE PRECISION NOT NULL DEFAULT 0,
 			comment TEXT DEFAULT ''
 		);
@@ -62,12 +63,18 @@ func CreateShipment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Дата отправки обязательна"})
 		return
 	}
+	shipDate, err := normalizeDateInput(shipment.ShipDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректная дата"})
+		return
+	}
+	shipment.ShipDate = shipDate
 	if strings.TrimSpace(shipment.FullName) == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "ФИО обязательно"})
 		return
 	}
 
-	err := DB.QueryRow(`
+	err = DB.QueryRow(`
 		INSERT INTO shipments (ship_date, city, full_name, phone, passport_inn, tk, places, price, weight)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id
@@ -80,32 +87,56 @@ func CreateShipment(c *gin.Context) {
 	c.JSON(http.StatusOK, shipment)
 }
 
-func GetShipments(c *gin.Context) {
-	date := strings.TrimSpace(c.Query("date"))
-	dateFrom := strings.TrimSpace(c.Query("from"))
-	dateTo := strings.TrimSpace(c.Query("to"))
-
-	query := `
-		SELECT id, ship_date, city, full_name, phone, passport_inn, tk, places, price, weight
-		FROM shipments
-	`
+// shipmentsFilter строит условие на ship_date по ?date= или диапазону ?from=&to= (отгрузки, заметки к ним
+// и выплаты курьеру); пустая строка — без фильтра.
+func shipmentsFilter(c *gin.Context) (string, []any, error) {
 	args := []any{}
 	conditions := make([]string, 0, 2)
-	if date != "" {
-		args = append(args, date)
-		conditions = append(conditions, fmt.Sprintf("ship_date = $%d", len(args)))
-	} else {
-		if dateFrom != "" {
-			args = append(args, dateFrom)
-			conditions = append(conditions, fmt.Sprintf("ship_date >= $%d", len(args)))
+	bounds := []struct{ param, op string }{{"date", "="}}
+	if strings.TrimSpace(c.Query("date")) == "" {
+		bounds = []struct{ param, op string }{{"from", ">="}, {"to", "<="}}
+	}
+	for _, bound := range bounds {
+		raw := strings.TrimSpace(c.Query(bound.param))
+		if raw == "" {
+			continue
 		}
-		if dateTo != "" {
-			args = append(args, dateTo)
-			conditions = append(conditions, fmt.Sprintf("ship_date <= $%d", len(args)))
+		date, err := normalizeDateInput(raw)
+		if err != nil {
+			return "", nil, newBadRequestError("Некорректная дата: " + bound.param)
 		}
+		args = append(args, date)
+		conditions = append(conditions, fmt.Sprintf("ship_date %s $%d::date", bound.op, len(args)))
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+const shipmentColumns = "id, to_char(ship_date, 'YYYY-MM-DD'), city, full_name, phone, passport_inn, tk, places, price, weight"
+
+func scanShipment(row interface{ Scan(...any) error }, shipment *models.Shipment) error {
+	return row.Scan(
+		&shipment.ID,
+		&shipment.ShipDate,
+		&shipment.City,
+		&shipment.FullName,
+		&shipment.Phone,
+		&shipment.PassportInn,
+		&shipment.TK,
+		&shipment.Places,
+		&shipment.Price,
+		&shipment.Weight,
+	)
+}
+
+func GetShipments(c *gin.Context) {
+	query := "SELECT " + shipmentColumns + " FROM shipments"
+	where, args, err := shipmentsFilter(c)
+	if err != nil {
+		writeOrderError(c, err)
+		return
 	}
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+	if where != "" {
+		query += " WHERE " + where
 	}
 	query += " ORDER BY ship_date DESC, id DESC"
 
@@ -119,18 +150,7 @@ func GetShipments(c *gin.Context) {
 	shipments := make([]models.Shipment, 0)
 	for rows.Next() {
 		var shipment models.Shipment
-		if err := rows.Scan(
-			&shipment.ID,
-			&shipment.ShipDate,
-			&shipment.City,
-			&shipment.FullName,
-			&shipment.Phone,
-			&shipment.PassportInn,
-			&shipment.TK,
-			&shipment.Places,
-			&shipment.Price,
-			&shipment.Weight,
-		); err != nil {
+		if err := scanShipment(rows, &shipment); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -152,12 +172,18 @@ func UpdateShipment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Дата отправки обязательна"})
 		return
 	}
+	shipDate, err := normalizeDateInput(shipment.ShipDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректная дата"})
+		return
+	}
+	shipment.ShipDate = shipDate
 	if strings.TrimSpace(shipment.FullName) == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "ФИО обязательно"})
 		return
 	}
 
-	_, err := DB.Exec(`
+	_, err = DB.Exec(`
 		UPDATE shipments
 		SET ship_date = $1, city = $2, full_name = $3, phone = $4, passport_inn = $5, tk = $6, places = $7, price = $8, weight = $9
 		WHERE id = $10
@@ -171,13 +197,18 @@ func UpdateShipment(c *gin.Context) {
 }
 
 func DeleteShipment(c *gin.Context) {
-	id := c.Param("id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipment ID"})
+		return
+	}
 
-	_, err := DB.Exec(`DELETE FROM shipments WHERE id = $1`, id)
+	_, err = DB.Exec(`DELETE FROM shipments WHERE id = $1`, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	purgeEntityAttachments(AttachmentShipment, id)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Shipment deleted successfully"})
 }
@@ -193,12 +224,18 @@ func CreateShipmentNote(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Дата обязательна"})
 		return
 	}
+	shipDate, err := normalizeDateInput(note.ShipDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректная дата"})
+		return
+	}
+	note.ShipDate = shipDate
 	if strings.TrimSpace(note.Note) == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Заметка обязательна"})
 		return
 	}
 
-	err := DB.QueryRow(`
+	err = DB.QueryRow(`
 		INSERT INTO shipment_notes (ship_date, note)
 		VALUES ($1, $2)
 		RETURNING id
@@ -212,28 +249,14 @@ func CreateShipmentNote(c *gin.Context) {
 }
 
 func GetShipmentNotes(c *gin.Context) {
-	date := strings.TrimSpace(c.Query("date"))
-	dateFrom := strings.TrimSpace(c.Query("from"))
-	dateTo := strings.TrimSpace(c.Query("to"))
-
-	query := `SELECT id, ship_date, note FROM shipment_notes`
-	args := []any{}
-	conditions := make([]string, 0, 2)
-	if date != "" {
-		args = append(args, date)
-		conditions = append(conditions, fmt.Sprintf("ship_date = $%d", len(args)))
-	} else {
-		if dateFrom != "" {
-			args = append(args, dateFrom)
-			conditions = append(conditions, fmt.Sprintf("ship_date >= $%d", len(args)))
-		}
-		if dateTo != "" {
-			args = append(args, dateTo)
-			conditions = append(conditions, fmt.Sprintf("ship_date <= $%d", len(args)))
-		}
+	query := `SELECT id, to_char(ship_date, 'YYYY-MM-DD'), note FROM shipment_notes`
+	where, args, err := shipmentsFilter(c)
+	if err != nil {
+		writeOrderError(c, err)
+		return
 	}
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+	if where != "" {
+		query += " WHERE " + where
 	}
 	query += " ORDER BY ship_date DESC, id DESC"
 
@@ -269,12 +292,18 @@ func UpdateShipmentNote(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Дата обязательна"})
 		return
 	}
+	shipDate, err := normalizeDateInput(note.ShipDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректная дата"})
+		return
+	}
+	note.ShipDate = shipDate
 	if strings.TrimSpace(note.Note) == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Заметка обязательна"})
 		return
 	}
 
-	_, err := DB.Exec(`
+	_, err = DB.Exec(`
 		UPDATE shipment_notes
 		SET ship_date = $1, note = $2
 		WHERE id = $3
@@ -300,28 +329,14 @@ func DeleteShipmentNote(c *gin.Context) {
 }
 
 func GetCourierDailyPayments(c *gin.Context) {
-	date := strings.TrimSpace(c.Query("date"))
-	dateFrom := strings.TrimSpace(c.Query("from"))
-	dateTo := strings.TrimSpace(c.Query("to"))
-
-	query := `SELECT ship_date, amount, COALESCE(comment, '') FROM courier_daily_payments`
-	args := []any{}
-	conditions := make([]string, 0, 2)
-	if date != "" {
-		args = append(args, date)
-		conditions = append(conditions, fmt.Sprintf("ship_date = $%d", len(args)))
-	} else {
-		if dateFrom != "" {
-			args = append(args, dateFrom)
-			conditions = append(conditions, fmt.Sprintf("ship_date >= $%d", len(args)))
-		}
-		if dateTo != "" {
-			args = append(args, dateTo)
-			conditions = append(conditions, fmt.Sprintf("ship_date <= $%d", len(args)))
-		}
+	query := `SELECT to_char(ship_date, 'YYYY-MM-DD'), amount, COALESCE(comment, '') FROM courier_daily_payments`
+	where, args, err := shipmentsFilter(c)
+	if err != nil {
+		writeOrderError(c, err)
+		return
 	}
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+	if where != "" {
+		query += " WHERE " + where
 	}
 	query += " ORDER BY ship_date DESC"
 
@@ -357,6 +372,12 @@ func UpsertCourierDailyPayment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Дата обязательна"})
 		return
 	}
+	shipDate, err := normalizeDateInput(payload.ShipDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректная дата"})
+		return
+	}
+	payload.ShipDate = shipDate
 	amount := payload.Amount
 	if math.IsNaN(amount) || math.IsInf(amount, 0) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректная сумма"})
@@ -364,8 +385,12 @@ func UpsertCourierDailyPayment(c *gin.Context) {
 	}
 
 	payload.Comment = strings.TrimSpace(payload.Comment)
+	if err := checkPeriodOpen(DB, payload.ShipDate); err != nil {
+		writeOrderError(c, err)
+		return
+	}
 
-	_, err := DB.Exec(`
+	_, err = DB.Exec(`
 		INSERT INTO courier_daily_payments (ship_date, amount, comment)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (ship_date) DO UPDATE
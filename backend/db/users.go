@@ -2,6 +2,7 @@ package db
 
 import (
 	"log"
+	"strings"
 
 	"github.com/Talonmortem/SHM/internal/models"
 	"github.com/gin-gonic/gin"
@@ -44,6 +45,26 @@ func CreateTablesUsers() {
 	log.Println("Tables users created successfully!")
 }
 
+// denyRoutes запрещает ролям маршруты "METHOD /api/path", если правила для них ещё нет. Так запреты,
+// на которых держится проверка (подтверждение оплат, закрытие периода), есть и в базах, где cmd/seed
+// не запускали; правила, заданные вручную, не меняются. Роли, которой нет, пропускаются.
+func denyRoutes(roles []string, routes ...string) error {
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		for _, role := range roles {
+			_, err := DB.Exec(`
+				INSERT INTO role_permissions (role_id, method, path, allowed)
+				SELECT id, $2, $3, false FROM roles WHERE name = $1
+				ON CONFLICT (role_id, method, path) DO NOTHING
+			`, role, method, path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func CreateUser(c *gin.Context) {
 	var user models.User
 	if err := c.ShouldBindJSON(&user); err != nil {
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Оптимистическая блокировка: у заказов, товаров, клиентов и артикулов есть version,
+// который растёт при каждом изменении через API (правка, смена статуса, списание остатка).
+// GET отдаёт его в ETag, PUT обязан прислать If-Match с той версией, которую видел пользователь.
+
+// anyVersion — If-Match: *, правка без проверки версии.
+const anyVersion int64 = -1
+
+func CreateTablesVersions() {
+	_, err := DB.Exec(`
+		ALTER TABLE orders ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+		ALTER TABLE products ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+		ALTER TABLE clients ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+	`)
+	if err != nil {
+		log.Fatal("Failed to add version columns:", err)
+	}
+	log.Println("Version columns are ready")
+}
+
+func setETag(c *gin.Context, version int64) {
+	c.Header("ETag", fmt.Sprintf(`"%d"`, version))
+}
+
+// ifMatchVersion читает версию из If-Match. Без заголовка отвечает 428, при мусоре — 400.
+func ifMatchVersion(c *gin.Context) (int64, bool) {
+	raw := strings.TrimSpace(c.GetHeader("If-Match"))
+	if raw == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header with the current version is required"})
+		return 0, false
+	}
+	if raw == "*" {
+		return anyVersion, true
+	}
+
+	raw = strings.TrimPrefix(raw, "W/")
+	raw = strings.Trim(raw, `"`)
+	version, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return 0, false
+	}
+	return version, true
+}
+
+// lockVersion блокирует строку до конца транзакции и возвращает её текущую версию.
+// table и keyColumn — только константы из кода.
+func lockVersion(tx *sql.Tx, table, keyColumn string, id any) (int64, error) {
+	var version int64
+	err := tx.QueryRow(fmt.Sprintf("SELECT version FROM %s WHERE %s = $1 FOR UPDATE", table, keyColumn), id).Scan(&version)
+	return version, err
+}
+
+func versionMatches(expected, current int64) bool {
+	return expected == anyVersion || expected == current
+}
+
+// writeVersionConflict отвечает 409 и отдаёт актуальное состояние записи, чтобы клиент мог
+// показать пользователю чужие изменения и повторить правку поверх них.
+func writeVersionConflict(c *gin.Context, version int64, current any) {
+	setETag(c, version)
+	c.JSON(http.StatusConflict, gin.H{
+		"error":   "Record was changed by someone else, reload it and repeat the edit",
+		"version": version,
+		"current": current,
+	})
+}
@@ -0,0 +1,409 @@
+// Package accounting выгружает продажи и оплаты для загрузки в 1С: CommerceML 2 (XML)
+// и плоский CSV, по строке на позицию документа. Номера документов и коды клиентов
+// приходят готовыми: повторная выгрузка того же периода даёт те же документы.
+package accounting
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Talonmortem/SHM/internal/export"
+)
+
+const (
+	FormatCommerceML = "commerceml"
+	FormatCSV        = "csv"
+)
+
+// Client — контрагент. Ref — постоянный код, по которому 1С сопоставляет клиента.
+type Client struct {
+	Ref   string
+	Name  string
+	Phone string
+	INN   string // паспорт или ИНН, как записан в заказе
+	City  string
+}
+
+// Line — позиция реализации: товар (мешок) или услуга (доставка, упаковка).
+type Line struct {
+	Ref      string
+	Name     string
+	Service  bool
+	Quantity float64
+	Amount   float64 // сумма позиции в рублях со всеми скидками
+}
+
+// Sale — реализация: отгруженный заказ.
+type Sale struct {
+	Number  string
+	Date    string // YYYY-MM-DD
+	OrderID int
+	Order   string // название заказа
+	Client  Client
+	Lines   []Line
+	Total   float64
+}
+
+// Payment — поступление от клиента или возврат ему.
+type Payment struct {
+	ID             int
+	Number         string
+	Date           string // YYYY-MM-DD HH:MM:SS
+	Client         Client
+	OrderID        int // 0 — оплата без заказа (на счёт клиента)
+	Method         string
+	Cash           bool
+	Refund         bool
+	Amount         float64 // в рублях, всегда положительная
+	Currency       string
+	OriginalAmount float64 // в валюте оплаты
+	Comment        string
+}
+
+// Export — выгрузка за период.
+type Export struct {
+	From     string
+	To       string
+	Created  time.Time
+	Sales    []Sale
+	Payments []Payment
+}
+
+// ParseFormat нормализует ?format=; по умолчанию — CommerceML.
+func ParseFormat(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", FormatCommerceML, "xml":
+		return FormatCommerceML, nil
+	case FormatCSV:
+		return FormatCSV, nil
+	}
+	return "", fmt.Errorf("unsupported accounting format %q: expected commerceml or csv", raw)
+}
+
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return export.ContentType(export.FormatCSV)
+	}
+	return "application/xml; charset=utf-8"
+}
+
+func Extension(format string) string {
+	if format == FormatCSV {
+		return "csv"
+	}
+	return "xml"
+}
+
+func Write(w io.Writer, format string, e Export) error {
+	if format == FormatCSV {
+		return writeCSV(w, e)
+	}
+	return writeCommerceML(w, e)
+}
+
+// SpreadDiscount раскладывает скидку на весь заказ по товарным позициям пропорционально сумме:
+// 1С не принимает строки с отрицательной суммой. Копейки округления уходят в последнюю позицию.
+// Скидка больше суммы товаров обнуляет их, остаток так же раскладывается по услугам;
+// сверх суммы всех позиций скидка не применяется.
+func SpreadDiscount(lines []Line, discount float64) {
+	left := spreadDiscount(lines, round(discount), func(line Line) bool { return !line.Service })
+	if left > 0 {
+		spreadDiscount(lines, left, func(line Line) bool { return line.Service })
+	}
+}
+
+// spreadDiscount раскладывает скидку по выбранным позициям и возвращает часть, которая не уместилась.
+func spreadDiscount(lines []Line, discount float64, pick func(Line) bool) float64 {
+	base := 0.0
+	last := -1
+	for i, line := range lines {
+		if pick(line) && line.Amount > 0 {
+			base += line.Amount
+			last = i
+		}
+	}
+	if discount == 0 || base == 0 {
+		return discount
+	}
+	applied := discount
+	if applied > base {
+		applied = round(base)
+	}
+	left := applied
+	for i := range lines {
+		if !pick(lines[i]) || lines[i].Amount <= 0 {
+			continue
+		}
+		part := round(applied * lines[i].Amount / base)
+		if i == last {
+			part = round(left)
+		}
+		if part > lines[i].Amount {
+			part = lines[i].Amount
+		}
+		lines[i].Amount = round(lines[i].Amount - part)
+		left = round(left - part)
+	}
+	// Копейки, которые не влезли в последнюю позицию, снимаются с предыдущих.
+	for i := last; i >= 0 && left > 0; i-- {
+		if !pick(lines[i]) || lines[i].Amount <= 0 {
+			continue
+		}
+		part := left
+		if part > lines[i].Amount {
+			part = lines[i].Amount
+		}
+		lines[i].Amount = round(lines[i].Amount - part)
+		left = round(left - part)
+	}
+	return round(discount - applied + left)
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func money(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+// Хозяйственные операции CommerceML для 1С.
+const (
+	operationSale       = "Отпуск товара"
+	operationCashIn     = "Выплата наличных денег"
+	operationBankIn     = "Выплата безналичных денег"
+	operationCashRefund = "Возврат наличных денег"
+	operationBankRefund = "Возврат безналичных денег"
+)
+
+type cmlInfo struct {
+	XMLName   xml.Name      `xml:"КоммерческаяИнформация"`
+	Version   string        `xml:"ВерсияСхемы,attr"`
+	Created   string        `xml:"ДатаФормирования,attr"`
+	Documents []cmlDocument `xml:"Документ"`
+}
+
+type cmlDocument struct {
+	ID           string           `xml:"Ид"`
+	Number       string           `xml:"Номер"`
+	Date         string           `xml:"Дата"`
+	Operation    string           `xml:"ХозОперация"`
+	Role         string           `xml:"Роль"`
+	Currency     string           `xml:"Валюта"`
+	Rate         string           `xml:"Курс"`
+	Amount       string           `xml:"Сумма"`
+	Counterparts []cmlCounterpart `xml:"Контрагенты>Контрагент"`
+	Time         string           `xml:"Время,omitempty"`
+	Comment      string           `xml:"Комментарий,omitempty"`
+	Goods        *cmlGoods        `xml:"Товары"`
+	Requisites   []cmlRequisite   `xml:"ЗначенияРеквизитов>ЗначениеРеквизита,omitempty"`
+}
+
+type cmlCounterpart struct {
+	ID       string       `xml:"Ид"`
+	Name     string       `xml:"Наименование"`
+	Role     string       `xml:"Роль"`
+	FullName string       `xml:"ПолноеНаименование"`
+	INN      string       `xml:"ИНН,omitempty"`
+	Address  *cmlAddress  `xml:"Адрес"`
+	Contacts *cmlContacts `xml:"Контакты"`
+}
+
+// Вложенные элементы — указатели: xml пропускает nil, а пустой путь "a>b" всё равно пишет <a></a>.
+type cmlGoods struct {
+	Items []cmlGood `xml:"Товар"`
+}
+
+type cmlAddress struct {
+	Text string `xml:"Представление"`
+}
+
+type cmlContacts struct {
+	Items []cmlContact `xml:"Контакт"`
+}
+
+type cmlContact struct {
+	Type  string `xml:"Тип"`
+	Value string `xml:"Значение"`
+}
+
+type cmlUnit struct {
+	Code     string `xml:"Код,attr"`
+	FullName string `xml:"НаименованиеПолное,attr"`
+	Intl     string `xml:"МеждународноеСокращение,attr"`
+	Name     string `xml:",chardata"`
+}
+
+type cmlGood struct {
+	ID         string         `xml:"Ид"`
+	Name       string         `xml:"Наименование"`
+	Unit       cmlUnit        `xml:"БазоваяЕдиница"`
+	Price      string         `xml:"ЦенаЗаЕдиницу"`
+	Quantity   string         `xml:"Количество"`
+	Amount     string         `xml:"Сумма"`
+	Requisites []cmlRequisite `xml:"ЗначенияРеквизитов>ЗначениеРеквизита"`
+}
+
+type cmlRequisite struct {
+	Name  string `xml:"Наименование"`
+	Value string `xml:"Значение"`
+}
+
+var cmlPiece = cmlUnit{Code: "796", FullName: "Штука", Intl: "PCE", Name: "шт"}
+
+func cmlClient(c Client) []cmlCounterpart {
+	counterpart := cmlCounterpart{ID: c.Ref, Name: c.Name, Role: "Покупатель", FullName: c.Name, INN: c.INN}
+	if c.City != "" {
+		counterpart.Address = &cmlAddress{Text: c.City}
+	}
+	if c.Phone != "" {
+		counterpart.Contacts = &cmlContacts{Items: []cmlContact{{Type: "Телефон рабочий", Value: c.Phone}}}
+	}
+	return []cmlCounterpart{counterpart}
+}
+
+func writeCommerceML(w io.Writer, e Export) error {
+	info := cmlInfo{Version: "2.05", Created: e.Created.Format("2006-01-02T15:04:05")}
+	for _, s := range e.Sales {
+		doc := cmlDocument{
+			ID:           fmt.Sprintf("sale-%d", s.OrderID),
+			Number:       s.Number,
+			Date:         s.Date,
+			Operation:    operationSale,
+			Role:         "Продавец",
+			Currency:     "руб",
+			Rate:         "1",
+			Amount:       money(s.Total),
+			Counterparts: cmlClient(s.Client),
+			Comment:      s.Order,
+			Requisites:   []cmlRequisite{{Name: "Номер заказа", Value: fmt.Sprint(s.OrderID)}},
+		}
+		doc.Goods = &cmlGoods{}
+		for _, line := range s.Lines {
+			kind := "Товар"
+			if line.Service {
+				kind = "Услуга"
+			}
+			price := line.Amount
+			if line.Quantity != 0 {
+				price = round(line.Amount / line.Quantity)
+			}
+			doc.Goods.Items = append(doc.Goods.Items, cmlGood{
+				ID:         line.Ref,
+				Name:       line.Name,
+				Unit:       cmlPiece,
+				Price:      money(price),
+				Quantity:   fmt.Sprint(line.Quantity),
+				Amount:     money(line.Amount),
+				Requisites: []cmlRequisite{{Name: "ВидНоменклатуры", Value: kind}},
+			})
+		}
+		info.Documents = append(info.Documents, doc)
+	}
+	for _, p := range e.Payments {
+		date, clock, _ := strings.Cut(p.Date, " ")
+		doc := cmlDocument{
+			ID:           fmt.Sprintf("payment-%d", p.ID),
+			Number:       p.Number,
+			Date:         date,
+			Operation:    paymentOperation(p),
+			Role:         "Продавец",
+			Currency:     "руб",
+			Rate:         "1",
+			Amount:       money(p.Amount),
+			Counterparts: cmlClient(p.Client),
+			Time:         clock,
+			Comment:      p.Comment,
+			Requisites:   []cmlRequisite{{Name: "Способ оплаты", Value: p.Method}},
+		}
+		if p.OrderID != 0 {
+			doc.Requisites = append(doc.Requisites, cmlRequisite{Name: "Номер заказа", Value: fmt.Sprint(p.OrderID)})
+		}
+		if p.Currency != "" && p.Currency != "RUB" {
+			doc.Requisites = append(doc.Requisites,
+				cmlRequisite{Name: "Валюта оплаты", Value: p.Currency},
+				cmlRequisite{Name: "Сумма в валюте оплаты", Value: money(p.OriginalAmount)},
+			)
+		}
+		info.Documents = append(info.Documents, doc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(info); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func paymentOperation(p Payment) string {
+	switch {
+	case p.Refund && p.Cash:
+		return operationCashRefund
+	case p.Refund:
+		return operationBankRefund
+	case p.Cash:
+		return operationCashIn
+	default:
+		return operationBankIn
+	}
+}
+
+var csvColumns = []string{
+	"Вид документа", "Номер", "Дата", "Код клиента", "Клиент", "Телефон", "Паспорт/ИНН", "Город", "Заказ",
+	"Код номенклатуры", "Номенклатура", "Вид номенклатуры", "Количество", "Сумма, ₽", "Сумма документа, ₽",
+	"Способ оплаты", "Валюта", "Сумма в валюте", "Комментарий",
+}
+
+// writeCSV — одна строка на позицию реализации и одна на оплату; реквизиты документа повторяются.
+func writeCSV(w io.Writer, e Export) error {
+	rw, err := export.NewWriter(w, export.FormatCSV, "1С", csvColumns)
+	if err != nil {
+		return err
+	}
+	for _, s := range e.Sales {
+		for _, line := range s.Lines {
+			kind := "Товар"
+			if line.Service {
+				kind = "Услуга"
+			}
+			if err := rw.WriteRow([]any{
+				"Реализация", s.Number, s.Date, s.Client.Ref, s.Client.Name, s.Client.Phone, s.Client.INN, s.Client.City, s.OrderID,
+				line.Ref, line.Name, kind, line.Quantity, line.Amount, s.Total,
+				nil, "RUB", nil, s.Order,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, p := range e.Payments {
+		kind := "Оплата"
+		if p.Refund {
+			kind = "Возврат оплаты"
+		}
+		var order any
+		if p.OrderID != 0 {
+			order = p.OrderID
+		}
+		currency, original := "RUB", any(nil)
+		if p.Currency != "" && p.Currency != "RUB" {
+			currency, original = p.Currency, p.OriginalAmount
+		}
+		if err := rw.WriteRow([]any{
+			kind, p.Number, p.Date, p.Client.Ref, p.Client.Name, p.Client.Phone, p.Client.INN, p.Client.City, order,
+			nil, nil, nil, nil, p.Amount, p.Amount,
+			p.Method, currency, original, p.Comment,
+		}); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
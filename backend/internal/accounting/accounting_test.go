@@ -0,0 +1,236 @@
+package accounting
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func amounts(lines []Line) []float64 {
+	out := make([]float64, len(lines))
+	for i, line := range lines {
+		out[i] = line.Amount
+	}
+	return out
+}
+
+func repeat(line Line, n int) []Line {
+	lines := make([]Line, n)
+	for i := range lines {
+		lines[i] = line
+	}
+	return lines
+}
+
+func repeatAmount(amount float64, n int) []float64 {
+	return amounts(repeat(Line{Amount: amount}, n))
+}
+
+func TestSpreadDiscount(t *testing.T) {
+	product := func(amount float64) Line { return Line{Quantity: 1, Amount: amount} }
+	service := func(amount float64) Line { return Line{Service: true, Quantity: 1, Amount: amount} }
+
+	tests := []struct {
+		name     string
+		lines    []Line
+		discount float64
+		want     []float64
+	}{
+		{"proportional", []Line{product(1000), product(3000)}, 400, []float64{900, 2700}},
+		{"services are not discounted", []Line{product(1000), service(500), product(1000)}, 100, []float64{950, 500, 950}},
+		{"rounding remainder goes to the last product", []Line{product(100), product(100), product(100), service(300)}, 100, []float64{66.67, 66.67, 66.66, 300}},
+		{"zero and returned products are skipped", []Line{product(0), product(200), product(-50)}, 50, []float64{0, 150, -50}},
+		{"no discount", []Line{product(100)}, 0, []float64{100}},
+		{"discount equal to the products", []Line{product(0.01), product(0.01), product(0.01)}, 0.03, []float64{0, 0, 0}},
+		{"cents that do not fit the last product", append(repeat(product(1), 10), product(0.01)), 0.05, append(repeatAmount(1, 9), 0.96, 0)},
+		{"discount over the products moves to services", []Line{product(300), product(100), service(500)}, 600, []float64{0, 0, 300}},
+		{"discount over everything stops at zero", []Line{product(300), service(200)}, 1000, []float64{0, 0}},
+		{"only services", []Line{service(400), service(100)}, 50, []float64{360, 90}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := append([]Line(nil), tt.lines...)
+			SpreadDiscount(lines, tt.discount)
+			if got := amounts(lines); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("amounts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSpreadDiscountKeepsTotal: пока скидка не больше суммы строк, сумма уменьшается ровно на скидку
+// и ни одна строка не уходит в минус.
+func TestSpreadDiscountKeepsTotal(t *testing.T) {
+	for _, discount := range []float64{0.01, 0.05, 1, 33.33, 99.99, 123.45, 169.12, 199.98, 249.12} {
+		lines := []Line{{Amount: 12.34}, {Amount: 56.78}, {Amount: 0.01}, {Amount: 99.99}, {Service: true, Amount: 80}}
+		before := 0.0
+		for _, line := range lines {
+			before += line.Amount
+		}
+		SpreadDiscount(lines, discount)
+		after := 0.0
+		for _, line := range lines {
+			if line.Amount < 0 {
+				t.Errorf("discount %.2f: negative line %v", discount, amounts(lines))
+			}
+			after += line.Amount
+		}
+		if round(before-after) != discount {
+			t.Errorf("discount %.2f: lines total dropped by %.2f (%v)", discount, round(before-after), amounts(lines))
+		}
+	}
+}
+
+func testExport() Export {
+	client := Client{Ref: "client-7", Name: "Иванова Анна", Phone: "+79161234567", INN: "4510 123456", City: "Тверь"}
+	return Export{
+		From:    "2024-03-01",
+		To:      "2024-03-31",
+		Created: time.Date(2024, 4, 1, 9, 30, 0, 0, time.UTC),
+		Sales: []Sale{{
+			Number:  "ТН-15",
+			Date:    "2024-03-05",
+			OrderID: 42,
+			Order:   "Весна",
+			Client:  client,
+			Total:   2350.5,
+			Lines: []Line{
+				{Ref: "product-1", Name: "Мешок A", Quantity: 2, Amount: 2000.5},
+				{Ref: "service-delivery", Name: "Доставка", Service: true, Quantity: 1, Amount: 350},
+			},
+		}},
+		Payments: []Payment{
+			{ID: 10, Number: "П-1", Date: "2024-03-04 12:15:00", Client: client, OrderID: 42, Method: "Сбербанк", Amount: 1000, Currency: "RUB", OriginalAmount: 1000, Comment: "предоплата"},
+			{ID: 11, Number: "П-2", Date: "2024-03-06 10:00:00", Client: Client{Ref: "client-8", Name: "Петров"}, Method: "Наличные", Cash: true, Refund: true, Amount: 90, Currency: "USD", OriginalAmount: 1},
+		},
+	}
+}
+
+func TestWriteCommerceML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatCommerceML, testExport()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	out := buf.String()
+
+	if !strings.HasPrefix(out, xml.Header) {
+		t.Error("missing XML header")
+	}
+	if m := regexp.MustCompile(`<(\p{L}+)></\p{L}+>`).FindString(out); m != "" {
+		t.Errorf("empty element %s in output", m)
+	}
+
+	var doc struct {
+		Version   string `xml:"ВерсияСхемы,attr"`
+		Created   string `xml:"ДатаФормирования,attr"`
+		Documents []struct {
+			ID        string `xml:"Ид"`
+			Number    string `xml:"Номер"`
+			Date      string `xml:"Дата"`
+			Time      string `xml:"Время"`
+			Operation string `xml:"ХозОперация"`
+			Amount    string `xml:"Сумма"`
+			Client    string `xml:"Контрагенты>Контрагент>Ид"`
+			Goods     []struct {
+				ID       string `xml:"Ид"`
+				Price    string `xml:"ЦенаЗаЕдиницу"`
+				Quantity string `xml:"Количество"`
+				Amount   string `xml:"Сумма"`
+				Kind     string `xml:"ЗначенияРеквизитов>ЗначениеРеквизита>Значение"`
+			} `xml:"Товары>Товар"`
+			Requisites []cmlRequisite `xml:"ЗначенияРеквизитов>ЗначениеРеквизита"`
+		} `xml:"Документ"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+	if doc.Version != "2.05" || doc.Created != "2024-04-01T09:30:00" || len(doc.Documents) != 3 {
+		t.Fatalf("document header: version %q, created %q, %d documents", doc.Version, doc.Created, len(doc.Documents))
+	}
+
+	sale := doc.Documents[0]
+	if sale.ID != "sale-42" || sale.Number != "ТН-15" || sale.Operation != operationSale || sale.Amount != "2350.50" || sale.Client != "client-7" {
+		t.Errorf("sale = %+v", sale)
+	}
+	if len(sale.Goods) != 2 {
+		t.Fatalf("sale has %d goods, want 2", len(sale.Goods))
+	}
+	if g := sale.Goods[0]; g.ID != "product-1" || g.Price != "1000.25" || g.Quantity != "2" || g.Amount != "2000.50" || g.Kind != "Товар" {
+		t.Errorf("product line = %+v", g)
+	}
+	if g := sale.Goods[1]; g.Kind != "Услуга" || g.Amount != "350.00" {
+		t.Errorf("service line = %+v", g)
+	}
+
+	payment := doc.Documents[1]
+	if payment.ID != "payment-10" || payment.Date != "2024-03-04" || payment.Time != "12:15:00" || payment.Operation != operationBankIn || len(payment.Goods) != 0 {
+		t.Errorf("payment = %+v", payment)
+	}
+	wantRequisites := []cmlRequisite{{"Способ оплаты", "Сбербанк"}, {"Номер заказа", "42"}}
+	if !reflect.DeepEqual(payment.Requisites, wantRequisites) {
+		t.Errorf("payment requisites = %v, want %v", payment.Requisites, wantRequisites)
+	}
+
+	refund := doc.Documents[2]
+	if refund.Operation != operationCashRefund || refund.Amount != "90.00" {
+		t.Errorf("refund = %+v", refund)
+	}
+	wantRequisites = []cmlRequisite{{"Способ оплаты", "Наличные"}, {"Валюта оплаты", "USD"}, {"Сумма в валюте оплаты", "1.00"}}
+	if !reflect.DeepEqual(refund.Requisites, wantRequisites) {
+		t.Errorf("refund requisites = %v, want %v", refund.Requisites, wantRequisites)
+	}
+	// У клиента без города и телефона нет пустых <Адрес> и <Контакты>.
+	if strings.Count(out, "<Адрес>") != 2 || strings.Count(out, "<Контакты>") != 2 {
+		t.Errorf("address and contacts must be written only for the client that has them")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatCSV, testExport()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data, ok := bytes.CutPrefix(buf.Bytes(), []byte("\ufeff"))
+	if !ok {
+		t.Error("missing UTF-8 BOM")
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = ';'
+	rows, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("output is not valid CSV: %v", err)
+	}
+
+	want := [][]string{
+		csvColumns,
+		{"Реализация", "ТН-15", "2024-03-05", "client-7", "Иванова Анна", "+79161234567", "4510 123456", "Тверь", "42",
+			"product-1", "Мешок A", "Товар", "2", "2000,5", "2350,5", "", "RUB", "", "Весна"},
+		{"Реализация", "ТН-15", "2024-03-05", "client-7", "Иванова Анна", "+79161234567", "4510 123456", "Тверь", "42",
+			"service-delivery", "Доставка", "Услуга", "1", "350", "2350,5", "", "RUB", "", "Весна"},
+		{"Оплата", "П-1", "2024-03-04 12:15:00", "client-7", "Иванова Анна", "+79161234567", "4510 123456", "Тверь", "42",
+			"", "", "", "", "1000", "1000", "Сбербанк", "RUB", "", "предоплата"},
+		{"Возврат оплаты", "П-2", "2024-03-06 10:00:00", "client-8", "Петров", "", "", "", "",
+			"", "", "", "", "90", "90", "Наличные", "USD", "1", ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows:\n got %q\nwant %q", rows, want)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := map[string]string{"": FormatCommerceML, "XML": FormatCommerceML, "commerceml": FormatCommerceML, " csv ": FormatCSV}
+	for raw, want := range tests {
+		got, err := ParseFormat(raw)
+		if err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("xlsx"); err == nil {
+		t.Error("ParseFormat(xlsx): expected error")
+	}
+}
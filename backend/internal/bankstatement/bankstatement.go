@@ -0,0 +1,305 @@
+// Package bankstatement разбирает банковские выписки: обмен с 1С (1CClientBankExchange)
+// и CSV из интернет-банков. Возвращаются только поступления на наш счёт.
+package bankstatement
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	Format1C  = "1c"
+	FormatCSV = "csv"
+)
+
+// Transaction — входящий платёж из выписки.
+type Transaction struct {
+	Number       string
+	Date         time.Time
+	Amount       float64
+	PayerName    string
+	PayerINN     string
+	PayerAccount string
+	Purpose      string
+}
+
+// ParseFormat нормализует формат; пустое значение — определить по содержимому.
+func ParseFormat(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "":
+		return "", nil
+	case Format1C, "1cclientbankexchange", "txt":
+		return Format1C, nil
+	case FormatCSV:
+		return FormatCSV, nil
+	default:
+		return "", fmt.Errorf("unsupported statement format %q: expected 1c or csv", raw)
+	}
+}
+
+// Parse разбирает выписку. Файл может быть в UTF-8 или Windows-1251.
+func Parse(data []byte, format string) ([]Transaction, string, error) {
+	text := decode(data)
+	if format == "" {
+		format = FormatCSV
+		if strings.HasPrefix(strings.TrimSpace(text), "1CClientBankExchange") {
+			format = Format1C
+		}
+	}
+
+	var transactions []Transaction
+	var err error
+	if format == Format1C {
+		transactions, err = parse1C(text)
+	} else {
+		transactions, err = parseCSV(text)
+	}
+	return transactions, format, err
+}
+
+// cp1251 — символы Windows-1251 с кодами 0x80–0xBF; 0xC0–0xFF — это А–я подряд.
+var cp1251 = [64]rune{
+	'Ђ', 'Ѓ', '‚', 'ѓ', '„', '…', '†', '‡', '€', '‰', 'Љ', '‹', 'Њ', 'Ќ', 'Ћ', 'Џ',
+	'ђ', '‘', '’', '“', '”', '•', '–', '—', '\ufffd', '™', 'љ', '›', 'њ', 'ќ', 'ћ', 'џ',
+	'\u00a0', 'Ў', 'ў', 'Ј', '¤', 'Ґ', '¦', '§', 'Ё', '©', 'Є', '«', '¬', '\u00ad', '®', 'Ї',
+	'°', '±', 'І', 'і', 'ґ', 'µ', '¶', '·', 'ё', '№', 'є', '»', 'ј', 'Ѕ', 'ѕ', 'ї',
+}
+
+func decode(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	var b strings.Builder
+	b.Grow(len(data) * 2)
+	for _, c := range data {
+		switch {
+		case c < 0x80:
+			b.WriteByte(c)
+		case c < 0xC0:
+			b.WriteRune(cp1251[c-0x80])
+		default:
+			b.WriteRune(rune(c) - 0xC0 + 'А')
+		}
+	}
+	return b.String()
+}
+
+func parseAmount(raw string) (float64, error) {
+	value := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\u00a0' || r == '\u202f' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+	value = strings.TrimPrefix(value, "+")
+	value = strings.ReplaceAll(value, ",", ".")
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+var dateLayouts = []string{"02.01.2006", "02.01.2006 15:04:05", "02.01.2006 15:04", "2006-01-02", "2006-01-02 15:04:05", "2006-01-02T15:04:05", "02/01/2006"}
+
+func parseDate(raw string) (time.Time, error) {
+	value := strings.TrimSpace(raw)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported date %q", raw)
+}
+
+// В 1С поле Плательщик часто начинается с «ИНН 7701234567».
+var innPrefix = regexp.MustCompile(`^ИНН\s*\d+\s*`)
+
+// parse1C разбирает формат 1CClientBankExchange. Поступление — документ, где получатель —
+// один из наших счетов (РасчСчет в заголовке); если счета не указаны — документ с ДатаПоступило.
+func parse1C(text string) ([]Transaction, error) {
+	ownAccounts := make(map[string]bool)
+	var transactions []Transaction
+	var doc map[string]string
+
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if i == 0 {
+			if line != "1CClientBankExchange" {
+				return nil, fmt.Errorf("not a 1CClientBankExchange file")
+			}
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		value = strings.TrimSpace(value)
+		switch {
+		case key == "СекцияДокумент":
+			doc = make(map[string]string)
+		case key == "КонецДокумента":
+			if doc == nil {
+				continue
+			}
+			tx, incoming, err := documentTransaction(doc, ownAccounts)
+			if err != nil {
+				return nil, fmt.Errorf("document %s: %w", doc["Номер"], err)
+			}
+			if incoming {
+				transactions = append(transactions, tx)
+			}
+			doc = nil
+		case doc != nil:
+			doc[key] = value
+		case key == "РасчСчет":
+			ownAccounts[value] = true
+		}
+	}
+	return transactions, nil
+}
+
+func documentTransaction(doc map[string]string, ownAccounts map[string]bool) (Transaction, bool, error) {
+	incoming := doc["ДатаПоступило"] != ""
+	if len(ownAccounts) > 0 {
+		incoming = ownAccounts[doc["ПолучательСчет"]]
+	}
+	if !incoming {
+		return Transaction{}, false, nil
+	}
+
+	amount, err := parseAmount(doc["Сумма"])
+	if err != nil {
+		return Transaction{}, false, fmt.Errorf("invalid amount %q", doc["Сумма"])
+	}
+	rawDate := doc["ДатаПоступило"]
+	if rawDate == "" {
+		rawDate = doc["Дата"]
+	}
+	date, err := parseDate(rawDate)
+	if err != nil {
+		return Transaction{}, false, err
+	}
+
+	payer := doc["Плательщик1"]
+	if payer == "" {
+		payer = innPrefix.ReplaceAllString(doc["Плательщик"], "")
+	}
+	account := doc["ПлательщикСчет"]
+	if account == "" {
+		account = doc["ПлательщикРасчСчет"]
+	}
+	return Transaction{
+		Number:       doc["Номер"],
+		Date:         date,
+		Amount:       amount,
+		PayerName:    strings.TrimSpace(payer),
+		PayerINN:     doc["ПлательщикИНН"],
+		PayerAccount: account,
+		Purpose:      doc["НазначениеПлатежа"],
+	}, amount > 0, nil
+}
+
+// Заголовки CSV разных банков, приведённые к нижнему регистру.
+var csvColumns = map[string][]string{
+	"number":  {"номер", "номер документа", "№ документа", "номер операции", "number"},
+	"date":    {"дата", "дата операции", "дата проводки", "дата платежа", "date"},
+	"amount":  {"сумма", "сумма операции", "сумма в валюте счета", "amount"},
+	"credit":  {"приход", "кредит", "поступление", "зачисление", "credit"},
+	"payer":   {"плательщик", "контрагент", "отправитель", "наименование плательщика", "payer"},
+	"inn":     {"инн плательщика", "инн контрагента", "инн", "payer_inn"},
+	"account": {"счет плательщика", "счёт плательщика", "счет контрагента", "счёт контрагента", "payer_account"},
+	"purpose": {"назначение платежа", "назначение", "описание", "комментарий", "purpose", "description"},
+}
+
+func detectDelimiter(header string) rune {
+	best, count := ';', strings.Count(header, ";")
+	for _, d := range []rune{',', '\t'} {
+		if n := strings.Count(header, string(d)); n > count {
+			best, count = d, n
+		}
+	}
+	return best
+}
+
+// parseCSV разбирает выписку с заголовком. Если есть колонка «Приход», берётся она,
+// иначе «Сумма», и расходы (отрицательные суммы) пропускаются.
+func parseCSV(text string) ([]Transaction, error) {
+	header, _, _ := strings.Cut(text, "\n")
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = detectDelimiter(header)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("statement is empty")
+	}
+
+	index := make(map[string]int)
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for field, aliases := range csvColumns {
+			if _, found := index[field]; found {
+				continue
+			}
+			for _, alias := range aliases {
+				if name == alias {
+					index[field] = i
+					break
+				}
+			}
+		}
+	}
+	if _, ok := index["date"]; !ok {
+		return nil, fmt.Errorf("date column not found")
+	}
+	amountColumn, ok := index["credit"]
+	if !ok {
+		if amountColumn, ok = index["amount"]; !ok {
+			return nil, fmt.Errorf("amount column not found")
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := index[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var transactions []Transaction
+	for n, record := range records[1:] {
+		if amountColumn >= len(record) || strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		amount, err := parseAmount(record[amountColumn])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid amount %q", n+2, record[amountColumn])
+		}
+		if amount <= 0 {
+			continue
+		}
+		date, err := parseDate(field(record, "date"))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", n+2, err)
+		}
+		transactions = append(transactions, Transaction{
+			Number:       field(record, "number"),
+			Date:         date,
+			Amount:       amount,
+			PayerName:    field(record, "payer"),
+			PayerINN:     field(record, "inn"),
+			PayerAccount: field(record, "account"),
+			Purpose:      field(record, "purpose"),
+		})
+	}
+	return transactions, nil
+}
@@ -0,0 +1,251 @@
+package bankstatement
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04:05", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// toCP1251 кодирует ASCII, кириллицу и № в Windows-1251.
+func toCP1251(s string) []byte {
+	var b []byte
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			b = append(b, byte(r))
+		case r >= 'А' && r <= 'я':
+			b = append(b, byte(r-'А'+0xC0))
+		case r == 'Ё':
+			b = append(b, 0xA8)
+		case r == 'ё':
+			b = append(b, 0xB8)
+		case r == '№':
+			b = append(b, 0xB9)
+		default:
+			panic("unsupported rune " + string(r))
+		}
+	}
+	return b
+}
+
+const statement1C = `1CClientBankExchange
+ВерсияФормата=1.03
+Кодировка=Windows
+РасчСчет=40702810900000000001
+СекцияДокумент=Платежное поручение
+Номер=15
+Дата=01.03.2024
+Сумма=1500.50
+ПлательщикСчет=40817810100000000002
+Плательщик=ИНН 771234567890 Иванов Иван Иванович
+ПлательщикИНН=771234567890
+ПолучательСчет=40702810900000000001
+ДатаПоступило=02.03.2024
+НазначениеПлатежа=Оплата заказа №42
+КонецДокумента
+СекцияДокумент=Платежное поручение
+Номер=16
+Дата=02.03.2024
+Сумма=300
+ПлательщикСчет=40702810900000000001
+Плательщик=ООО Ромашка
+ПолучательСчет=40702810500000000003
+ДатаСписано=02.03.2024
+НазначениеПлатежа=Аренда
+КонецДокумента
+КонецФайла
+`
+
+const statement1CNoAccounts = `1CClientBankExchange
+ВерсияФормата=1.03
+СекцияДокумент=Платежное поручение
+Номер=7
+Дата=05.03.2024
+Сумма=2 000,00
+Плательщик1=Петрова Анна Сергеевна
+ПлательщикРасчСчет=40817810100000000004
+ДатаПоступило=05.03.2024
+НазначениеПлатежа=За мешок
+КонецДокумента
+СекцияДокумент=Платежное поручение
+Номер=8
+Дата=05.03.2024
+Сумма=100
+ДатаСписано=05.03.2024
+НазначениеПлатежа=Комиссия банка
+КонецДокумента
+КонецФайла
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		format     string
+		wantFormat string
+		want       []Transaction
+	}{
+		{
+			name:       "1C with own account keeps only incoming documents",
+			data:       []byte(statement1C),
+			format:     Format1C,
+			wantFormat: Format1C,
+			want: []Transaction{{
+				Number:       "15",
+				Date:         date("2024-03-02 00:00:00"),
+				Amount:       1500.50,
+				PayerName:    "Иванов Иван Иванович",
+				PayerINN:     "771234567890",
+				PayerAccount: "40817810100000000002",
+				Purpose:      "Оплата заказа №42",
+			}},
+		},
+		{
+			name:       "1C without own account uses ДатаПоступило",
+			data:       []byte(statement1CNoAccounts),
+			wantFormat: Format1C,
+			want: []Transaction{{
+				Number:       "7",
+				Date:         date("2024-03-05 00:00:00"),
+				Amount:       2000,
+				PayerName:    "Петрова Анна Сергеевна",
+				PayerAccount: "40817810100000000004",
+				Purpose:      "За мешок",
+			}},
+		},
+		{
+			name: "CSV with semicolons and credit column",
+			data: []byte("Дата;Номер;Приход;Расход;Плательщик;ИНН плательщика;Назначение платежа\n" +
+				"01.03.2024;101;1 200,00;;Иванов И.И.;771234567890;Заказ 42\n" +
+				"01.03.2024;102;;500,00;ООО Ромашка;;Аренда\n" +
+				";;;;;;\n"),
+			wantFormat: FormatCSV,
+			want: []Transaction{{
+				Number:    "101",
+				Date:      date("2024-03-01 00:00:00"),
+				Amount:    1200,
+				PayerName: "Иванов И.И.",
+				PayerINN:  "771234567890",
+				Purpose:   "Заказ 42",
+			}},
+		},
+		{
+			name: "CSV with commas and signed amount",
+			data: []byte("Date,Amount,Payer,Purpose\n" +
+				"2024-03-01 10:15:00,\"+350,25\",Petrov,Order 7\n" +
+				"2024-03-01 11:00:00,-100,Bank,Fee\n"),
+			format:     FormatCSV,
+			wantFormat: FormatCSV,
+			want: []Transaction{{
+				Date:      date("2024-03-01 10:15:00"),
+				Amount:    350.25,
+				PayerName: "Petrov",
+				Purpose:   "Order 7",
+			}},
+		},
+		{
+			name: "CSV with tabs",
+			data: []byte("Дата операции\tСумма операции\tКонтрагент\tОписание\n" +
+				"03.03.2024\t900\tСидоров\tПредоплата\n"),
+			wantFormat: FormatCSV,
+			want: []Transaction{{
+				Date:      date("2024-03-03 00:00:00"),
+				Amount:    900,
+				PayerName: "Сидоров",
+				Purpose:   "Предоплата",
+			}},
+		},
+		{
+			name: "CSV in Windows-1251",
+			data: toCP1251("Дата;Сумма;Плательщик;Назначение\n" +
+				"04.03.2024;750;Ёлкина Мария;Оплата по счёту №5\n"),
+			wantFormat: FormatCSV,
+			want: []Transaction{{
+				Date:      date("2024-03-04 00:00:00"),
+				Amount:    750,
+				PayerName: "Ёлкина Мария",
+				Purpose:   "Оплата по счёту №5",
+			}},
+		},
+		{
+			name:       "1C in Windows-1251",
+			data:       toCP1251(statement1CNoAccounts),
+			wantFormat: Format1C,
+			want: []Transaction{{
+				Number:       "7",
+				Date:         date("2024-03-05 00:00:00"),
+				Amount:       2000,
+				PayerName:    "Петрова Анна Сергеевна",
+				PayerAccount: "40817810100000000004",
+				Purpose:      "За мешок",
+			}},
+		},
+		{
+			name:       "UTF-8 BOM is ignored",
+			data:       []byte("\xef\xbb\xbfДата;Сумма\n01.03.2024;10\n"),
+			wantFormat: FormatCSV,
+			want:       []Transaction{{Date: date("2024-03-01 00:00:00"), Amount: 10}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, format, err := Parse(tt.data, tt.format)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("format = %q, want %q", format, tt.wantFormat)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("transactions:\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format string
+		want   string
+	}{
+		{"1C header missing", "Дата;Сумма\n01.03.2024;10\n", Format1C, "not a 1CClientBankExchange file"},
+		{"1C bad amount", "1CClientBankExchange\nСекцияДокумент=Платежное поручение\nНомер=1\nСумма=abc\nДатаПоступило=01.03.2024\nКонецДокумента\n", "", "document 1: invalid amount"},
+		{"CSV without date", "Сумма;Плательщик\n10;Иванов\n", FormatCSV, "date column not found"},
+		{"CSV without amount", "Дата;Плательщик\n01.03.2024;Иванов\n", FormatCSV, "amount column not found"},
+		{"CSV bad date", "Дата;Сумма\n2024/13/45;10\n", FormatCSV, "row 2: unsupported date"},
+		{"CSV bad amount", "Дата;Сумма\n01.03.2024;десять\n", FormatCSV, "row 2: invalid amount"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Parse([]byte(tt.data), tt.format)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := map[string]string{"": "", "1C": Format1C, "txt": Format1C, "1CClientBankExchange": Format1C, " csv ": FormatCSV}
+	for raw, want := range tests {
+		got, err := ParseFormat(raw)
+		if err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("xlsx"); err == nil {
+		t.Error("ParseFormat(xlsx): expected error")
+	}
+}
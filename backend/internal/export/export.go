@@ -0,0 +1,262 @@
+// Package export выгружает табличные данные в CSV и XLSX. CSV пишется с разделителем «;»,
+// десятичной запятой и BOM, чтобы русский Excel открывал его без мастера импорта.
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// Table — лист выгрузки. Значения строк: string, числа, bool, time.Time или nil.
+type Table struct {
+	Name    string
+	Columns []string
+	Rows    [][]any
+}
+
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// ParseFormat нормализует ?format=; пустое значение — не выгрузка.
+func ParseFormat(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "":
+		return "", nil
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatXLSX:
+		return FormatXLSX, nil
+	}
+	return "", fmt.Errorf("unsupported export format %q: expected csv or xlsx", raw)
+}
+
+// RowWriter пишет строки по одной, не держа выгрузку в памяти. Close обязателен: он дописывает файл.
+type RowWriter interface {
+	WriteRow(values []any) error
+	Close() error
+}
+
+// NewWriter начинает выгрузку: сразу пишет заголовок таблицы.
+func NewWriter(w io.Writer, format, sheetName string, columns []string) (RowWriter, error) {
+	if format == FormatXLSX {
+		return newXLSXWriter(w, sheetName, columns)
+	}
+	return newCSVWriter(w, columns)
+}
+
+// Write выгружает готовую таблицу целиком.
+func Write(w io.Writer, format string, t Table) error {
+	rw, err := NewWriter(w, format, t.Name, t.Columns)
+	if err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		if err := rw.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
+
+type csvWriter struct {
+	cw     *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer, columns []string) (*csvWriter, error) {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return nil, err
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = ';'
+	if err := cw.Write(columns); err != nil {
+		return nil, err
+	}
+	return &csvWriter{cw: cw, record: make([]string, len(columns))}, nil
+}
+
+func (w *csvWriter) WriteRow(values []any) error {
+	for i := range w.record {
+		w.record[i] = ""
+		if i < len(values) {
+			w.record[i] = csvValue(values[i])
+		}
+	}
+	return w.cw.Write(w.record)
+}
+
+func (w *csvWriter) Close() error {
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+func csvValue(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strings.Replace(strconv.FormatFloat(value, 'f', -1, 64), ".", ",", 1)
+	case float32:
+		return strings.Replace(strconv.FormatFloat(float64(value), 'f', -1, 32), ".", ",", 1)
+	case time.Time:
+		return value.Format("2006-01-02 15:04:05")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// xlsxWriter собирает минимальную книгу Office Open XML с одним листом. Zip пишется потоком,
+// лист — последним файлом архива. Строки пишутся как inline strings, числа — числами,
+// чтобы по ним работали формулы.
+type xlsxWriter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+func newXLSXWriter(w io.Writer, sheetName string, columns []string) (*xlsxWriter, error) {
+	if sheetName == "" {
+		sheetName = "Sheet1"
+	}
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		body string
+	}{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="` + escape(sheetTitle(sheetName)) + `" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`},
+		{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>
+</styleSheet>`},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(fw, f.body); err != nil {
+			return nil, err
+		}
+	}
+
+	fw, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	x := &xlsxWriter{zw: zw, sheet: bufio.NewWriter(fw), row: 1}
+	x.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	x.sheet.WriteString(`<row r="1">`)
+	for i, col := range columns {
+		fmt.Fprintf(x.sheet, `<c r="%s1" t="inlineStr" s="1"><is><t>%s</t></is></c>`, columnName(i), escape(col))
+	}
+	x.sheet.WriteString(`</row>`)
+	return x, nil
+}
+
+func (x *xlsxWriter) WriteRow(values []any) error {
+	x.row++
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.row)
+	for i, v := range values {
+		ref := columnName(i) + strconv.Itoa(x.row)
+		switch value := v.(type) {
+		case nil:
+			continue
+		case int, int32, int64:
+			fmt.Fprintf(x.sheet, `<c r="%s"><v>%d</v></c>`, ref, value)
+		case float32:
+			fmt.Fprintf(x.sheet, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(float64(value), 'f', -1, 32))
+		case float64:
+			fmt.Fprintf(x.sheet, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(value, 'f', -1, 64))
+		case bool:
+			flag := 0
+			if value {
+				flag = 1
+			}
+			fmt.Fprintf(x.sheet, `<c r="%s" t="b"><v>%d</v></c>`, ref, flag)
+		default:
+			fmt.Fprintf(x.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, escape(csvValue(value)))
+		}
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxWriter) Close() error {
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// columnName переводит индекс колонки с нуля в буквенное обозначение: 0 → A, 26 → AA.
+func columnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// sheetTitle убирает символы, запрещённые в имени листа, и обрезает до 31 символа.
+func sheetTitle(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	return name
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
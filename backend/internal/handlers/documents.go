@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Talonmortem/SHM/config"
+	"github.com/Talonmortem/SHM/db"
+	"github.com/Talonmortem/SHM/internal/models"
+	"github.com/Talonmortem/SHM/internal/pdf"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	docMarginLeft  = 40.0
+	docMarginRight = pdf.PageWidth - 40.0
+	docMarginTop   = 50.0
+	docBottom      = pdf.PageHeight - 50.0
+	docFontSize    = 10.0
+	docLineHeight  = 13.0
+)
+
+// docColumn — колонка таблицы документа: x — левый край, width — ширина, right — выравнивание вправо.
+type docColumn struct {
+	title string
+	x     float64
+	width float64
+	right bool
+}
+
+// docPage ведёт текущую позицию на странице и переносит вывод на новую страницу.
+type docPage struct {
+	doc *pdf.Document
+	y   float64
+}
+
+func newDocPage(doc *pdf.Document) *docPage {
+	doc.AddPage()
+	return &docPage{doc: doc, y: docMarginTop}
+}
+
+func (p *docPage) ensure(height float64) {
+	if p.y+height > docBottom {
+		p.doc.AddPage()
+		p.y = docMarginTop
+	}
+}
+
+func (p *docPage) line(size float64, text string) {
+	for _, part := range p.doc.Wrap(text, size, docMarginRight-docMarginLeft) {
+		p.ensure(size + 3)
+		p.y += size + 3
+		p.doc.Text(docMarginLeft, p.y, size, part)
+	}
+}
+
+func (p *docPage) gap(height float64) {
+	p.y += height
+}
+
+func (p *docPage) rule() {
+	p.ensure(4)
+	p.y += 4
+	p.doc.Line(docMarginLeft, p.y, docMarginRight, p.y)
+}
+
+func (p *docPage) header(columns []docColumn) {
+	p.ensure(docLineHeight * 2)
+	p.rule()
+	p.y += docLineHeight
+	for _, col := range columns {
+		p.cell(col, col.title)
+	}
+	p.rule()
+}
+
+// row печатает строку таблицы; длинные значения переносятся по словам внутри колонки.
+func (p *docPage) row(columns []docColumn, values []string) {
+	wrapped := make([][]string, len(columns))
+	lines := 1
+	for i, col := range columns {
+		wrapped[i] = p.doc.Wrap(values[i], docFontSize, col.width)
+		if len(wrapped[i]) > lines {
+			lines = len(wrapped[i])
+		}
+	}
+
+	height := float64(lines) * docLineHeight
+	if p.y+height > docBottom {
+		p.doc.AddPage()
+		p.y = docMarginTop
+		p.header(columns)
+	}
+
+	top := p.y
+	for i, col := range columns {
+		p.y = top
+		for _, part := range wrapped[i] {
+			p.y += docLineHeight
+			p.cell(col, part)
+		}
+	}
+	p.y = top + height
+}
+
+func (p *docPage) cell(col docColumn, text string) {
+	if col.right {
+		p.doc.TextRight(col.x+col.width, p.y, docFontSize, text)
+		return
+	}
+	p.doc.Text(col.x, p.y, docFontSize, text)
+}
+
+// total печатает итоговую строку с суммой, выровненной по правому полю.
+func (p *docPage) total(label, value string) {
+	p.ensure(docLineHeight)
+	p.y += docLineHeight
+	p.doc.TextRight(docMarginRight-110, p.y, docFontSize, label)
+	p.doc.TextRight(docMarginRight, p.y, docFontSize, value)
+}
+
+func formatMoney(value float64) string {
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	raw := strconv.FormatFloat(value, 'f', 2, 64)
+	intPart, frac := raw[:len(raw)-3], raw[len(raw)-2:]
+
+	var grouped strings.Builder
+	for i, digit := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			grouped.WriteRune(' ')
+		}
+		grouped.WriteRune(digit)
+	}
+	return sign + grouped.String() + "," + frac
+}
+
+func parseDocNumber(raw string) float64 {
+	value := strings.TrimSpace(raw)
+	value = strings.ReplaceAll(value, " ", "")
+	value = strings.ReplaceAll(value, "%", "")
+	value = strings.ReplaceAll(value, ",", ".")
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func formatWeight(value float64) string {
+	return strings.Replace(strconv.FormatFloat(value, 'f', 2, 64), ".", ",", 1)
+}
+
+// documentDate отрезает время от даты выдачи номера.
+func documentDate(createdAt string) string {
+	if len(createdAt) >= 10 {
+		return createdAt[8:10] + "." + createdAt[5:7] + "." + createdAt[0:4]
+	}
+	return createdAt
+}
+
+func adjustmentTitle(adj models.OrderAdjustment) string {
+	title := adj.Kind
+	switch adj.Kind {
+	case "discount_percent":
+		title = "Скидка " + strconv.FormatFloat(adj.Amount, 'f', -1, 64) + " %"
+	case "discount_fixed":
+		title = "Скидка"
+	case "delivery":
+		title = "Доставка"
+	case "packaging":
+		title = "Упаковка"
+	}
+	if adj.Comment != "" {
+		title += " (" + adj.Comment + ")"
+	}
+	return title
+}
+
+func writeDeliveryBlock(p *docPage, order models.Order, title string) {
+	p.line(docFontSize, title+": "+order.FullName)
+	if order.Phone != "" {
+		p.line(docFontSize, "Телефон: "+order.Phone)
+	}
+	if order.PassportInn != "" {
+		p.line(docFontSize, "Паспорт/ИНН: "+order.PassportInn)
+	}
+	if order.City != "" {
+		p.line(docFontSize, "Город: "+order.City)
+	}
+	if order.TK != "" {
+		p.line(docFontSize, "Транспортная компания: "+order.TK)
+	}
+}
+
+func renderInvoice(doc *pdf.Document, order models.Order, number models.OrderDocument, seller string) {
+	p := newDocPage(doc)
+	p.line(16, fmt.Sprintf("Счёт № %s от %s", number.Number, documentDate(number.CreatedAt)))
+	p.line(docFontSize, "Заказ: "+order.Name)
+	p.gap(6)
+	if seller != "" {
+		p.line(docFontSize, "Продавец: "+seller)
+	}
+	writeDeliveryBlock(p, order, "Покупатель")
+	p.gap(8)
+
+	columns := []docColumn{
+		{title: "№", x: docMarginLeft, width: 20},
+		{title: "Наименование", x: 65, width: 270},
+		{title: "Вес, кг", x: 340, width: 50, right: true},
+		{title: "Кол-во", x: 395, width: 40, right: true},
+		{title: "Скидка", x: 440, width: 40, right: true},
+		{title: "Сумма, ₽", x: 485, width: docMarginRight - 485, right: true},
+	}
+	p.header(columns)
+
+	for i, product := range order.Components {
+		sum := parseDocNumber(product.SummaRubSoSkidkoj)
+		discount := ""
+		if skidka := parseDocNumber(product.Skidka); skidka != 0 {
+			discount = strconv.FormatFloat(skidka, 'f', -1, 64) + " %"
+		}
+		p.row(columns, []string{
+			strconv.Itoa(i + 1),
+			product.Name,
+			formatWeight(parseDocNumber(product.Weight)),
+			strconv.Itoa(product.Count),
+			discount,
+			formatMoney(sum),
+		})
+	}
+	p.rule()
+
+	if len(order.Adjustments) > 0 {
+		p.total("Товары:", formatMoney(order.ProductsTotal))
+		for _, adj := range order.Adjustments {
+			p.total(adjustmentTitle(adj)+":", formatMoney(adj.Value))
+		}
+	}
+	// Оплачено считается как в долге заказа, поэтому Итого − Оплачено = К оплате.
+	p.total("Итого:", formatMoney(order.Total))
+	p.total("Оплачено:", formatMoney(db.OrderPaid(order)))
+	if order.PendingPaid != 0 {
+		p.total("в т. ч. не подтверждено:", formatMoney(order.PendingPaid))
+	}
+	p.total("К оплате:", formatMoney(order.Debt))
+
+	var paymentRows [][]string
+	for _, payment := range order.Payments {
+		if !db.PaymentCountsAsPaid(payment.Status) {
+			continue
+		}
+		comment := payment.Comment
+		if db.PaymentPending(payment.Status) {
+			comment = strings.TrimSpace(comment + " (не подтверждена)")
+		}
+		paymentRows = append(paymentRows, []string{payment.Date, payment.Method, comment, formatMoney(payment.Amount)})
+	}
+	for _, a := range order.Allocations {
+		comment := fmt.Sprintf("Зачёт оплаты клиента №%d", a.PaymentID)
+		if db.PaymentPending(a.PaymentStatus) {
+			comment += " (не подтверждена)"
+		}
+		paymentRows = append(paymentRows, []string{a.Date, a.Method, comment, formatMoney(a.Amount)})
+	}
+	if len(paymentRows) > 0 {
+		p.gap(10)
+		p.line(12, "Оплаты")
+		paymentColumns := []docColumn{
+			{title: "Дата", x: docMarginLeft, width: 110},
+			{title: "Способ", x: 155, width: 130},
+			{title: "Комментарий", x: 290, width: 190},
+			{title: "Сумма, ₽", x: 485, width: docMarginRight - 485, right: true},
+		}
+		p.header(paymentColumns)
+		for _, row := range paymentRows {
+			p.row(paymentColumns, row)
+		}
+		p.rule()
+	}
+}
+
+func renderPackingList(doc *pdf.Document, order models.Order, number models.OrderDocument) {
+	p := newDocPage(doc)
+	p.line(16, fmt.Sprintf("Упаковочный лист № %s от %s", number.Number, documentDate(number.CreatedAt)))
+	p.line(docFontSize, "Заказ: "+order.Name)
+	if order.ShipDate != "" {
+		p.line(docFontSize, "Дата отгрузки: "+order.ShipDate)
+	}
+	p.gap(6)
+	writeDeliveryBlock(p, order, "Получатель")
+	p.gap(8)
+
+	columns := []docColumn{
+		{title: "№", x: docMarginLeft, width: 20},
+		{title: "Наименование", x: 65, width: 380},
+		{title: "Вес, кг", x: 450, width: 50, right: true},
+		{title: "Кол-во", x: 505, width: docMarginRight - 505, right: true},
+	}
+	p.header(columns)
+
+	weight := 0.0
+	count := 0
+	for i, product := range order.Components {
+		productWeight := parseDocNumber(product.Weight)
+		weight += productWeight
+		count += product.Count
+		p.row(columns, []string{strconv.Itoa(i + 1), product.Name, formatWeight(productWeight), strconv.Itoa(product.Count)})
+	}
+	p.rule()
+
+	p.total("Мешков:", strconv.Itoa(len(order.Components)))
+	p.total("Вес, кг:", formatWeight(weight))
+	p.total("Вещей, шт:", strconv.Itoa(count))
+	if order.Places > 0 {
+		p.total("Мест:", strconv.Itoa(order.Places))
+	}
+}
+
+// OrderDocumentHandler отдаёт PDF счёта или упаковочного листа по заказу.
+// Номер документа выдаётся при первом запросе и сохраняется за заказом.
+func OrderDocumentHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+	docType := c.Param("doc")
+	if docType != db.DocumentInvoice && docType != db.DocumentPackingList {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown document type, expected invoice or packing-list"})
+		return
+	}
+
+	order, err := db.LoadOrder(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cfg := config.Load()
+	font, err := pdf.LoadFont(cfg.PDFFontPath)
+	if err != nil {
+		log.Printf("Failed to load PDF font: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load PDF font: " + err.Error()})
+		return
+	}
+
+	number, err := db.OrderDocumentNumber(id, docType)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	doc := pdf.New(font)
+	if docType == db.DocumentInvoice {
+		renderInvoice(doc, order, number, cfg.DocumentsSeller)
+	} else {
+		renderPackingList(doc, order, number)
+	}
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s-%d.pdf"`, docType, id))
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Talonmortem/SHM/db"
+	"github.com/gin-gonic/gin"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder копирует тело ответа, чтобы сохранить его для повторов.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency выполняет запрос с заголовком Idempotency-Key один раз: повтор с тем же ключом
+// получает сохранённый ответ с заголовком Idempotent-Replayed. Ответы 5xx не сохраняются,
+// такой запрос можно повторить с тем же ключом. Без заголовка запрос проходит как обычно.
+func Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		}
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		username := c.GetString("username")
+		record, owned, err := db.BeginIdempotentRequest(username, key, c.Request.Method, c.Request.URL.Path, requestHash)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check Idempotency-Key: " + err.Error()})
+			return
+		}
+		if !owned {
+			switch {
+			case record.RequestHash != requestHash:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case record.StatusCode == 0:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(record.StatusCode, record.ContentType, record.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if status := recorder.Status(); status >= http.StatusInternalServerError {
+			err = db.AbandonIdempotentRequest(username, key)
+		} else {
+			err = db.CompleteIdempotentRequest(username, key, status, recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("Failed to store Idempotency-Key %q: %v", key, err)
+		}
+	}
+}
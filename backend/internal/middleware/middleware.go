@@ -20,7 +20,8 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Username")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Username, If-Match, Idempotency-Key")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, Content-Disposition, Idempotent-Replayed")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusOK)
 			return
@@ -159,6 +160,7 @@ func RoleMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		c.Set("role_id", roleID)
 		c.Next()
 	}
 }
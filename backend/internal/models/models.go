@@ -26,6 +26,14 @@ type Product struct {
 	OnePrice          string             `json:"onePrice"`
 	Video             string             `json:"video"`
 	Description       string             `json:"description"`
+	Version           int64              `json:"version"`
+}
+
+type ProductCardTemplate struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Format string `json:"format"` // text, markdown или html
+	Body   string `json:"body"`
 }
 
 type ArticleInProduct struct {
@@ -49,6 +57,7 @@ type Article struct {
 	Colli       float64 `json:"colli"`
 	KG          float64 `json:"kg"`
 	Value       float64 `json:"value"`
+	Version     int64   `json:"version"`
 }
 
 func (a *Article) UnmarshalJSON(data []byte) error {
@@ -252,12 +261,105 @@ func normalizeNumericString(s string) string {
 }
 
 type Payment struct { // corresponds to payments_monitoring table
-	ID      int     `json:"id"`
-	Date    string  `json:"date"`
-	Method  string  `json:"method"`
-	OrderID int     `json:"order_id"`
+	ID             int     `json:"id"`
+	Date           string  `json:"date"`
+	Method         string  `json:"method"`
+	OrderID        int     `json:"order_id"`
+	Amount         float64 `json:"amount"` // в рублях; у возврата отрицательная
+	Comment        string  `json:"comment"`
+	Type           string  `json:"type"`            // payment или refund
+	Reason         string  `json:"reason"`          // причина возврата
+	ProductID      *int    `json:"product_id"`      // возвращённый товар, если возврат за него
+	Currency       string  `json:"currency"`        // валюта способа оплаты, ISO-код
+	OriginalAmount float64 `json:"original_amount"` // сумма в валюте оплаты
+	Rate           float64 `json:"rate"`            // рублей за единицу валюты на дату оплаты
+	Status         string  `json:"status"`          // pending, confirmed или rejected
+	StatusComment  string  `json:"status_comment"`  // причина отклонения
+	ConfirmedBy    string  `json:"confirmed_by"`    // кто подтвердил или отклонил
+	ConfirmedAt    string  `json:"confirmed_at"`
+}
+
+// ExchangeRate — курс валюты к рублю на дату.
+type ExchangeRate struct {
+	Currency string  `json:"currency"`
+	Date     string  `json:"date"`
+	Rate     float64 `json:"rate"`
+}
+
+// PaymentsSummary — итоги оплат за период: по способам, по дням/неделям/месяцам и сводная
+// таблица способ × день. Суммы в рублях, возвраты входят со знаком минус, отклонённые оплаты не входят.
+type PaymentsSummary struct {
+	Period   string               `json:"period"` // day, week или month — шаг by_period
+	Count    int                  `json:"count"`
+	Total    float64              `json:"total"`
+	Refunds  float64              `json:"refunds"`
+	Pending  float64              `json:"pending"` // из total — ещё не подтверждено
+	ByMethod []PaymentMethodTotal `json:"by_method"`
+	ByPeriod []PaymentPeriodTotal `json:"by_period"`
+	Days     []string             `json:"days"` // столбцы сводной таблицы
+	Pivot    []PaymentPivotRow    `json:"pivot"`
+}
+
+type PaymentMethodTotal struct {
+	Method         string  `json:"method"`
+	Currency       string  `json:"currency"`
+	Count          int     `json:"count"`
+	Amount         float64 `json:"amount"`
+	Refunds        float64 `json:"refunds"`
+	Pending        float64 `json:"pending"`
+	OriginalAmount float64 `json:"original_amount"` // в валюте способа
+}
+
+type PaymentPeriodTotal struct {
+	Period  string  `json:"period"` // первый день периода, YYYY-MM-DD
+	Count   int     `json:"count"`
 	Amount  float64 `json:"amount"`
-	Comment string  `json:"comment"`
+	Refunds float64 `json:"refunds"`
+}
+
+type PaymentPivotRow struct {
+	Method  string             `json:"method"`
+	Amounts map[string]float64 `json:"amounts"` // день → сумма
+	Total   float64            `json:"total"`
+}
+
+// PeriodLock — дата, по которую включительно закрыт финансовый период, и журнал её изменений.
+type PeriodLock struct {
+	ClosedThrough string            `json:"closed_through"` // пусто — закрытых периодов нет
+	Events        []PeriodLockEvent `json:"events"`
+}
+
+type PeriodLockEvent struct {
+	ID            int    `json:"id"`
+	Action        string `json:"action"` // close или reopen
+	ClosedThrough string `json:"closed_through"`
+	Previous      string `json:"previous"`
+	Reason        string `json:"reason"`
+	ChangedBy     string `json:"changed_by"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// Attachment — файл, приложенный к оплате, заказу, отправке или товару (скриншот перевода, скан).
+type Attachment struct {
+	ID          int    `json:"id"`
+	EntityType  string `json:"entity_type"` // payment, order, shipment, product
+	EntityID    int    `json:"entity_id"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	UploadedBy  string `json:"uploaded_by"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// PaymentMethod — способ оплаты (касса, карта, счёт, человек). Method — код, который пишется
+// в payments_monitoring.method; при переименовании оплаты переезжают вместе с ним.
+type PaymentMethod struct {
+	ID          int    `json:"id"`
+	Method      string `json:"method"`
+	DisplayName string `json:"display_name"` // пусто — показывать method
+	Type        string `json:"type"`         // cash, card, bank или person; пусто у старых записей
+	Currency    string `json:"currency"`     // ISO-код, пусто — рубли
+	Active      bool   `json:"active"`       // отключённый способ нельзя выбрать для новых оплат
 }
 
 type BalanceRow struct {
@@ -288,8 +390,78 @@ type Order struct {
 	PassportInn string    `json:"passport_inn"`
 	TK          string    `json:"tk"`
 	Places      int       `json:"places"`
-	Price       float64   `json:"price"`
+	Price       float64   `json:"price"` // стоимость доставки — сумма корректировок delivery, только для чтения
 	Weight      float64   `json:"weight"`
+	ClientID    int       `json:"client_id"` // 0 — заказ не привязан к справочнику клиентов
+
+	Adjustments   []OrderAdjustment   `json:"adjustments"`    // скидки, доставка и упаковка на весь заказ
+	Allocations   []PaymentAllocation `json:"allocations"`    // зачтённые в заказ части клиентских оплат
+	ProductsTotal float64             `json:"products_total"` // сумма товаров со скидками по мешкам
+	Total         float64             `json:"total"`          // к оплате с учётом корректировок
+	PendingPaid   float64             `json:"pending_paid"`   // часть оплаченного, ещё не подтверждённая менеджером
+
+	PaymentResolution string `json:"payment_resolution"` // refund или credit для отменённых/возвращённых заказов
+	CloseReason       string `json:"close_reason"`
+	ClosedAt          string `json:"closed_at"`
+
+	Version int64 `json:"version"` // для If-Match при сохранении
+}
+
+type OrderAdjustment struct {
+	ID      int     `json:"id"`
+	Kind    string  `json:"kind"`   // discount_percent, discount_fixed, delivery или packaging
+	Amount  float64 `json:"amount"` // процент для discount_percent, иначе рубли
+	Comment string  `json:"comment"`
+	Value   float64 `json:"value"` // итоговое влияние на сумму заказа в рублях, считается сервером
+}
+
+type ReservationExpiryEvent struct {
+	ID             int    `json:"id"`
+	OrderID        int    `json:"order_id"`
+	Action         string `json:"action"` // warn или release
+	OrderName      string `json:"order_name"`
+	FullName       string `json:"full_name"`
+	Phone          string `json:"phone"`
+	ProductIDs     []int  `json:"product_ids"`
+	OrderCreatedAt string `json:"order_created_at"`
+	CreatedAt      string `json:"created_at"`
+}
+
+type Return struct {
+	ID           int          `json:"id"`
+	OrderID      int          `json:"order_id"`
+	Status       string       `json:"status"` // created или received
+	Reason       string       `json:"reason"`
+	RefundAmount float64      `json:"refund_amount"`
+	RefundMethod string       `json:"refund_method"`
+	Items        []ReturnItem `json:"items"`
+	CreatedAt    string       `json:"created_at"`
+	ReceivedAt   string       `json:"received_at"`
+}
+
+type ReturnItem struct {
+	ID          int     `json:"id"`
+	ProductID   int     `json:"product_id"`
+	ProductName string  `json:"product_name"`
+	Amount      float64 `json:"amount"`
+	Weight      string  `json:"weight"`
+	Disposition string  `json:"disposition"` // restock или breakdown
+}
+
+type DocumentSequence struct {
+	DocType    string `json:"doc_type"` // invoice или packing-list
+	Prefix     string `json:"prefix"`
+	NextNumber int64  `json:"next_number"`
+	Padding    int    `json:"padding"` // сколько цифр дополнять нулями
+	Sample     string `json:"sample"`  // как будет выглядеть следующий номер
+}
+
+type OrderDocument struct {
+	ID        int    `json:"id"`
+	OrderID   int    `json:"order_id"`
+	DocType   string `json:"doc_type"`
+	Number    string `json:"number"`
+	CreatedAt string `json:"created_at"`
 }
 
 type Client struct {
@@ -300,6 +472,7 @@ type Client struct {
 	PassportNumber string `json:"passport_number"`
 	TK             string `json:"tk"`
 	Comment        string `json:"comment"`
+	Version        int64  `json:"version"`
 }
 
 type Shipment struct {
@@ -326,3 +499,177 @@ type CourierDailyPayment struct {
 	Amount   float64 `json:"amount"`
 	Comment  string  `json:"comment"`
 }
+
+// ReceivablesBuckets — дебиторка по возрасту долга в днях.
+type ReceivablesBuckets struct {
+	Days0to7   float64 `json:"days_0_7"`
+	Days8to30  float64 `json:"days_8_30"`
+	Days31to90 float64 `json:"days_31_90"`
+	Days90Plus float64 `json:"days_90_plus"`
+	Total      float64 `json:"total"`
+}
+
+type ReceivableOrder struct {
+	OrderID   int     `json:"order_id"`
+	Name      string  `json:"name"`
+	Status    int     `json:"status"`
+	CreatedAt string  `json:"created_at"`
+	ShipDate  string  `json:"ship_date"`
+	AgeDays   int     `json:"age_days"`
+	Debt      float64 `json:"debt"`
+}
+
+type ReceivableClient struct {
+	ClientID int                `json:"client_id"` // 0 — заказы без карточки клиента, сгруппированы по телефону или ФИО
+	FullName string             `json:"full_name"`
+	Phone    string             `json:"phone"`
+	Orders   []ReceivableOrder  `json:"orders"`
+	Buckets  ReceivablesBuckets `json:"buckets"`
+}
+
+type ReceivablesReport struct {
+	AsOf    string             `json:"as_of"`
+	Basis   string             `json:"basis"` // order — от даты заказа, ship — от даты отгрузки
+	Clients []ReceivableClient `json:"clients"`
+	Totals  ReceivablesBuckets `json:"totals"`
+}
+
+// PaymentAllocation — часть клиентской оплаты, зачтённая в заказ.
+type PaymentAllocation struct {
+	ID        int     `json:"id"`
+	PaymentID int     `json:"payment_id"`
+	OrderID   int     `json:"order_id"`
+	Amount    float64 `json:"amount"`
+	Date      string  `json:"date"`   // дата оплаты
+	Method    string  `json:"method"` // способ оплаты
+	CreatedAt string  `json:"created_at"`
+
+	PaymentStatus string `json:"payment_status"` // статус оплаты: pending или confirmed
+}
+
+// ClientPayment — оплата клиента без привязки к одному заказу (предоплата или оплата нескольких заказов).
+type ClientPayment struct {
+	Payment
+	ClientID    int                 `json:"client_id"`
+	Allocated   float64             `json:"allocated"`
+	Unallocated float64             `json:"unallocated"` // остаток на балансе клиента
+	Allocations []PaymentAllocation `json:"allocations"`
+}
+
+type ClientBalanceOrder struct {
+	OrderID int     `json:"order_id"`
+	Name    string  `json:"name"`
+	Status  int     `json:"status"`
+	Debt    float64 `json:"debt"` // отрицательный — переплата
+}
+
+// ClientBalance — лицевой счёт клиента. Balance > 0 — клиент переплатил, < 0 — должен.
+type ClientBalance struct {
+	ClientID       int                  `json:"client_id"`
+	Credit         float64              `json:"credit"`          // нераспределённые оплаты клиента
+	OrdersDebt     float64              `json:"orders_debt"`     // долг по открытым заказам
+	OrdersOverpaid float64              `json:"orders_overpaid"` // переплаты, оставшиеся на заказах
+	Balance        float64              `json:"balance"`
+	Payments       []ClientPayment      `json:"payments"`
+	Orders         []ClientBalanceOrder `json:"orders"`
+}
+
+// BankMatch — предложенный заказ для строки выписки.
+type BankMatch struct {
+	OrderID    int      `json:"order_id"`
+	OrderName  string   `json:"order_name"`
+	ClientID   int      `json:"client_id"`
+	ClientName string   `json:"client_name"`
+	Debt       float64  `json:"debt"`
+	Score      int      `json:"score"`
+	Reasons    []string `json:"reasons"` // amount, payer_name, phone, order_number
+}
+
+// BankStatementLine — входящий платёж из выписки. Status: new, matched (создана оплата) или ignored.
+type BankStatementLine struct {
+	ID                 int         `json:"id"`
+	StatementID        int         `json:"statement_id"`
+	DocNumber          string      `json:"doc_number"`
+	Date               string      `json:"date"`
+	Amount             float64     `json:"amount"`
+	PayerName          string      `json:"payer_name"`
+	PayerINN           string      `json:"payer_inn"`
+	PayerAccount       string      `json:"payer_account"`
+	Purpose            string      `json:"purpose"`
+	Status             string      `json:"status"`
+	PaymentID          *int        `json:"payment_id"`
+	Matches            []BankMatch `json:"matches"`
+	PossibleDuplicates []int       `json:"possible_duplicates"` // оплаты с тем же способом, днём и суммой, введённые вручную
+}
+
+type BankStatement struct {
+	ID         int                 `json:"id"`
+	Method     string              `json:"method"`
+	FileName   string              `json:"file_name"`
+	Format     string              `json:"format"`
+	ImportedBy string              `json:"imported_by"`
+	ImportedAt string              `json:"imported_at"`
+	Imported   int                 `json:"imported"` // новых строк при загрузке
+	Skipped    int                 `json:"skipped"`  // строк, загруженных раньше
+	Lines      []BankStatementLine `json:"lines"`
+}
+
+// CashMovement — движение денег по способу оплаты помимо оплат заказов.
+// Kind: opening (остаток на начало, может быть отрицательным), income, expense, handover, transfer (на ToMethod).
+type CashMovement struct {
+	ID        int     `json:"id"`
+	Date      string  `json:"date"` // YYYY-MM-DD
+	Method    string  `json:"method"`
+	ToMethod  string  `json:"to_method,omitempty"`
+	Kind      string  `json:"kind"`
+	Amount    float64 `json:"amount"`
+	Comment   string  `json:"comment"`
+	CreatedBy string  `json:"created_by"`
+	CreatedAt string  `json:"created_at"`
+}
+
+// CashBookEntry — строка кассовой книги. Amount со знаком: приход > 0, расход < 0.
+type CashBookEntry struct {
+	Date    string  `json:"date"`
+	Source  string  `json:"source"` // payment или movement
+	ID      int     `json:"id"`
+	Kind    string  `json:"kind"` // для оплат — payment или refund
+	OrderID *int    `json:"order_id,omitempty"`
+	Amount  float64 `json:"amount"`
+	Comment string  `json:"comment"`
+}
+
+type CashBookDay struct {
+	Date       string   `json:"date"`
+	Opening    float64  `json:"opening"`
+	Payments   float64  `json:"payments"` // оплаты из payments_monitoring
+	Refunds    float64  `json:"refunds"`  // возвраты денег клиентам
+	Inflow     float64  `json:"inflow"`   // прочий приход: остатки, поступления, переводы сюда
+	Outflow    float64  `json:"outflow"`  // расходы, сдача денег, переводы отсюда
+	Closing    float64  `json:"closing"`
+	Counted    *float64 `json:"counted"`    // пересчитано фактически
+	Difference *float64 `json:"difference"` // counted − closing
+}
+
+type CashBook struct {
+	Method   string          `json:"method"`
+	Currency string          `json:"currency"` // валюта способа: в ней все суммы книги
+	DateFrom string          `json:"date_from"`
+	DateTo   string          `json:"date_to"`
+	Opening  float64         `json:"opening"`
+	Closing  float64         `json:"closing"`
+	Days     []CashBookDay   `json:"days"`
+	Entries  []CashBookEntry `json:"entries"`
+}
+
+// CashCount — фактический пересчёт денег по способу оплаты на конец дня.
+type CashCount struct {
+	Method     string  `json:"method"`
+	Date       string  `json:"date"`
+	Counted    float64 `json:"counted"`
+	Computed   float64 `json:"computed"`
+	Difference float64 `json:"difference"`
+	Mismatch   bool    `json:"mismatch"`
+	Comment    string  `json:"comment"`
+	CountedBy  string  `json:"counted_by"`
+}
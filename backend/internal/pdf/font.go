@@ -0,0 +1,220 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Font — TrueType-шрифт, который целиком встраивается в PDF (Identity-H),
+// чтобы кириллица отображалась без шрифтов на стороне читателя.
+type Font struct {
+	Name       string
+	data       []byte
+	unitsPerEm int
+	ascent     int
+	descent    int
+	bbox       [4]int
+	widths     []uint16
+	glyphs     map[rune]uint16
+}
+
+var (
+	fontCacheMu sync.Mutex
+	fontCache   = map[string]*Font{}
+)
+
+// LoadFont читает и разбирает TTF-файл; разобранные шрифты кэшируются по пути.
+func LoadFont(path string) (*Font, error) {
+	fontCacheMu.Lock()
+	defer fontCacheMu.Unlock()
+
+	if f, ok := fontCache[path]; ok {
+		return f, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read font: %w", err)
+	}
+	f, err := parseFont(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse font %s: %w", path, err)
+	}
+	f.Name = fontName(path)
+	fontCache[path] = f
+	return f, nil
+}
+
+func fontName(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var b strings.Builder
+	for _, r := range base {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "Font"
+	}
+	return b.String()
+}
+
+func parseFont(data []byte) (*Font, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("file too short")
+	}
+
+	tables := make(map[string][]byte)
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		rec := 12 + i*16
+		if rec+16 > len(data) {
+			return nil, fmt.Errorf("truncated table directory")
+		}
+		tag := string(data[rec : rec+4])
+		offset := int(binary.BigEndian.Uint32(data[rec+8:]))
+		length := int(binary.BigEndian.Uint32(data[rec+12:]))
+		if offset+length > len(data) {
+			return nil, fmt.Errorf("table %s out of range", tag)
+		}
+		tables[tag] = data[offset : offset+length]
+	}
+
+	for _, tag := range []string{"head", "hhea", "maxp", "hmtx", "cmap"} {
+		if _, ok := tables[tag]; !ok {
+			return nil, fmt.Errorf("missing %s table", tag)
+		}
+	}
+
+	f := &Font{data: data}
+
+	head := tables["head"]
+	if len(head) < 54 {
+		return nil, fmt.Errorf("bad head table")
+	}
+	f.unitsPerEm = int(binary.BigEndian.Uint16(head[18:]))
+	for i := 0; i < 4; i++ {
+		f.bbox[i] = int(int16(binary.BigEndian.Uint16(head[36+i*2:])))
+	}
+
+	hhea := tables["hhea"]
+	if len(hhea) < 36 {
+		return nil, fmt.Errorf("bad hhea table")
+	}
+	f.ascent = int(int16(binary.BigEndian.Uint16(hhea[4:])))
+	f.descent = int(int16(binary.BigEndian.Uint16(hhea[6:])))
+	numHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+
+	maxp := tables["maxp"]
+	if len(maxp) < 6 {
+		return nil, fmt.Errorf("bad maxp table")
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+
+	hmtx := tables["hmtx"]
+	if numHMetrics == 0 || len(hmtx) < numHMetrics*4 {
+		return nil, fmt.Errorf("bad hmtx table")
+	}
+	f.widths = make([]uint16, numGlyphs)
+	for g := 0; g < numGlyphs; g++ {
+		if g < numHMetrics {
+			f.widths[g] = binary.BigEndian.Uint16(hmtx[g*4:])
+		} else {
+			f.widths[g] = f.widths[numHMetrics-1]
+		}
+	}
+
+	glyphs, err := parseCmap(tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	f.glyphs = glyphs
+	return f, nil
+}
+
+// parseCmap читает юникодную подтаблицу формата 4 (BMP).
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, fmt.Errorf("bad cmap table")
+	}
+
+	subtable := -1
+	numTables := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < numTables; i++ {
+		rec := 4 + i*8
+		if rec+8 > len(cmap) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(cmap[rec:])
+		encoding := binary.BigEndian.Uint16(cmap[rec+2:])
+		offset := int(binary.BigEndian.Uint32(cmap[rec+4:]))
+		if offset+2 > len(cmap) || binary.BigEndian.Uint16(cmap[offset:]) != 4 {
+			continue
+		}
+		if (platform == 3 && encoding == 1) || platform == 0 {
+			subtable = offset
+			break
+		}
+	}
+	if subtable < 0 {
+		return nil, fmt.Errorf("no unicode cmap format 4 subtable")
+	}
+
+	t := cmap[subtable:]
+	if len(t) < 14 {
+		return nil, fmt.Errorf("bad cmap subtable")
+	}
+	segCount := int(binary.BigEndian.Uint16(t[6:])) / 2
+	endCodes := 14
+	startCodes := endCodes + segCount*2 + 2
+	idDeltas := startCodes + segCount*2
+	idRangeOffsets := idDeltas + segCount*2
+	if idRangeOffsets+segCount*2 > len(t) {
+		return nil, fmt.Errorf("truncated cmap subtable")
+	}
+
+	glyphs := make(map[rune]uint16)
+	for i := 0; i < segCount; i++ {
+		end := int(binary.BigEndian.Uint16(t[endCodes+i*2:]))
+		start := int(binary.BigEndian.Uint16(t[startCodes+i*2:]))
+		delta := int(binary.BigEndian.Uint16(t[idDeltas+i*2:]))
+		rangeOffsetPos := idRangeOffsets + i*2
+		rangeOffset := int(binary.BigEndian.Uint16(t[rangeOffsetPos:]))
+
+		for code := start; code <= end && code < 0xFFFF; code++ {
+			var glyph int
+			if rangeOffset == 0 {
+				glyph = (code + delta) & 0xFFFF
+			} else {
+				addr := rangeOffsetPos + rangeOffset + 2*(code-start)
+				if addr+2 > len(t) {
+					continue
+				}
+				glyph = int(binary.BigEndian.Uint16(t[addr:]))
+				if glyph != 0 {
+					glyph = (glyph + delta) & 0xFFFF
+				}
+			}
+			if glyph != 0 {
+				glyphs[rune(code)] = uint16(glyph)
+			}
+		}
+	}
+	return glyphs, nil
+}
+
+// glyphWidth возвращает ширину глифа в единицах 1/1000 кегля.
+func (f *Font) glyphWidth(g uint16) int {
+	if int(g) >= len(f.widths) {
+		return 0
+	}
+	return int(f.widths[g]) * 1000 / f.unitsPerEm
+}
+
+func (f *Font) scale(v int) int {
+	return v * 1000 / f.unitsPerEm
+}
@@ -0,0 +1,220 @@
+// Package pdf — минимальный генератор PDF для печатных форм (счёт, упаковочный лист):
+// страницы A4, текст одним встроенным TrueType-шрифтом и линии.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+type Document struct {
+	font  *Font
+	pages []*bytes.Buffer
+	used  map[uint16]rune
+}
+
+func New(font *Font) *Document {
+	return &Document{font: font, used: make(map[uint16]rune)}
+}
+
+func (d *Document) AddPage() {
+	page := &bytes.Buffer{}
+	page.WriteString("0.5 w\n")
+	d.pages = append(d.pages, page)
+}
+
+func (d *Document) page() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	return d.pages[len(d.pages)-1]
+}
+
+// TextWidth — ширина строки в пунктах при заданном кегле.
+func (d *Document) TextWidth(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		total += d.font.glyphWidth(d.font.glyphs[r])
+	}
+	return float64(total) * size / 1000
+}
+
+// Text пишет строку; x, y — левый край и базовая линия, y отсчитывается от верха страницы.
+func (d *Document) Text(x, y, size float64, s string) {
+	if s == "" {
+		return
+	}
+	var hex strings.Builder
+	for _, r := range s {
+		g := d.font.glyphs[r]
+		if _, ok := d.used[g]; !ok {
+			d.used[g] = r
+		}
+		fmt.Fprintf(&hex, "%04X", g)
+	}
+	fmt.Fprintf(d.page(), "BT /F1 %.2f Tf %.2f %.2f Td <%s> Tj ET\n", size, x, PageHeight-y, hex.String())
+}
+
+// TextRight выравнивает строку по правому краю right.
+func (d *Document) TextRight(right, y, size float64, s string) {
+	d.Text(right-d.TextWidth(s, size), y, size, s)
+}
+
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page(), "%.2f %.2f m %.2f %.2f l S\n", x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// Wrap разбивает текст по словам на строки не шире width.
+func (d *Document) Wrap(s string, size, width float64) []string {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return []string{""}
+	}
+
+	var lines []string
+	current := ""
+	for _, word := range words {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if current != "" && d.TextWidth(candidate, size) > width {
+			lines = append(lines, current)
+			current = word
+			continue
+		}
+		current = candidate
+	}
+	return append(lines, current)
+}
+
+type writer struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+func (w *writer) object(n int, body string) {
+	for len(w.offsets) < n {
+		w.offsets = append(w.offsets, 0)
+	}
+	w.offsets[n-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", n, body)
+}
+
+func (w *writer) stream(n int, dict string, data []byte) {
+	for len(w.offsets) < n {
+		w.offsets = append(w.offsets, 0)
+	}
+	w.offsets[n-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", n, dict, len(data))
+	w.buf.Write(data)
+	w.buf.WriteString("\nendstream\nendobj\n")
+}
+
+func deflate(data []byte) []byte {
+	var out bytes.Buffer
+	zw := zlib.NewWriter(&out)
+	zw.Write(data)
+	zw.Close()
+	return out.Bytes()
+}
+
+// WriteTo собирает документ. Номера объектов: 1 — каталог, 2 — дерево страниц,
+// 3–7 — шрифт, дальше по паре (страница, содержимое) на каждую страницу.
+func (d *Document) WriteTo(out io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	w := &writer{}
+	w.buf.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+
+	const firstPageObj = 8
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPageObj+i*2)
+	}
+
+	w.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	w.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	f := d.font
+	w.object(3, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [4 0 R] /ToUnicode 7 0 R >>", f.Name))
+	w.object(4, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor 5 0 R /DW %d /W [%s] /CIDToGIDMap /Identity >>",
+		f.Name, f.glyphWidth(0), d.widthsArray()))
+	w.object(5, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 6 0 R >>",
+		f.Name, f.scale(f.bbox[0]), f.scale(f.bbox[1]), f.scale(f.bbox[2]), f.scale(f.bbox[3]), f.scale(f.ascent), f.scale(f.descent), f.scale(f.ascent)))
+	w.stream(6, fmt.Sprintf("/Filter /FlateDecode /Length1 %d", len(f.data)), deflate(f.data))
+	w.stream(7, "/Filter /FlateDecode", deflate(d.toUnicode()))
+
+	for i, page := range d.pages {
+		pageObj := firstPageObj + i*2
+		w.object(pageObj, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, pageObj+1))
+		w.stream(pageObj+1, "/Filter /FlateDecode", deflate(page.Bytes()))
+	}
+
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, xref)
+
+	return w.buf.WriteTo(out)
+}
+
+func (d *Document) usedGlyphs() []uint16 {
+	glyphs := make([]uint16, 0, len(d.used))
+	for g := range d.used {
+		glyphs = append(glyphs, g)
+	}
+	sort.Slice(glyphs, func(i, j int) bool { return glyphs[i] < glyphs[j] })
+	return glyphs
+}
+
+func (d *Document) widthsArray() string {
+	var b strings.Builder
+	for _, g := range d.usedGlyphs() {
+		fmt.Fprintf(&b, "%d [%d] ", g, d.font.glyphWidth(g))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func (d *Document) toUnicode() []byte {
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	b.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+
+	glyphs := d.usedGlyphs()
+	for start := 0; start < len(glyphs); start += 100 {
+		end := start + 100
+		if end > len(glyphs) {
+			end = len(glyphs)
+		}
+		fmt.Fprintf(&b, "%d beginbfchar\n", end-start)
+		for _, g := range glyphs[start:end] {
+			var unicode strings.Builder
+			for _, u := range utf16.Encode([]rune{d.used[g]}) {
+				fmt.Fprintf(&unicode, "%04X", u)
+			}
+			fmt.Fprintf(&b, "<%04X> <%s>\n", g, unicode.String())
+		}
+		b.WriteString("endbfchar\n")
+	}
+
+	b.WriteString("endcmap\nCMapName currentdict /CMapName exch defineresource pop\nend\nend\n")
+	return b.Bytes()
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// Memory держит файлы в памяти процесса: для тестов и локального запуска без диска.
+type Memory struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{files: make(map[string][]byte)}
+}
+
+func (s *Memory) Put(_ context.Context, key string, data []byte, _ string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[key] = bytes.Clone(data)
+	return nil
+}
+
+func (s *Memory) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.files[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *Memory) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, key)
+	return nil
+}
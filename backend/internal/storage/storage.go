@@ -0,0 +1,65 @@
+// Package storage хранит файлы вложений: на локальном диске, в S3-совместимом хранилище
+// или в памяти (подмена для тестов). Ключ — путь вида "payment/12/3f9a…", без ".." и "/" в начале.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	DriverLocal  = "local"
+	DriverS3     = "s3"
+	DriverMemory = "memory"
+)
+
+// ErrNotFound — файла с таким ключом нет.
+var ErrNotFound = errors.New("file not found")
+
+// Storage — хранилище файлов. Delete отсутствующего файла не считается ошибкой.
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Config — выбор и настройки хранилища.
+type Config struct {
+	Driver string // local (по умолчанию), s3 или memory
+	Dir    string // каталог для local
+
+	S3Endpoint  string // https://storage.yandexcloud.net, http://minio:9000 …
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+}
+
+// New создаёт хранилище по конфигурации.
+func New(cfg Config) (Storage, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Driver)) {
+	case "", DriverLocal:
+		return NewLocal(cfg.Dir)
+	case DriverS3:
+		return NewS3(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey)
+	case DriverMemory:
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown attachments storage %q: expected local, s3 or memory", cfg.Driver)
+	}
+}
+
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid storage key %q", key)
+		}
+	}
+	return nil
+}
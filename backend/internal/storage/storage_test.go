@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValidKey(t *testing.T) {
+	for _, key := range []string{"payment/12/3f9a", "a", "order/1/file.name-x_y"} {
+		if err := validKey(key); err != nil {
+			t.Errorf("validKey(%q) = %v, want nil", key, err)
+		}
+	}
+	for _, key := range []string{"", "/etc/passwd", "..", "../a", "a/../../b", "a/./b", "./a", "a//b", "a/", `a\b`, `..\a`} {
+		if err := validKey(key); err == nil {
+			t.Errorf("validKey(%q) = nil, want error", key)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Dir: dir}, "*storage.Local"},
+		{Config{Driver: " Local ", Dir: dir}, "*storage.Local"},
+		{Config{Driver: "memory"}, "*storage.Memory"},
+		{Config{Driver: "s3", S3Endpoint: "http://minio:9000", S3Bucket: "shm", S3AccessKey: "ak", S3SecretKey: "sk"}, "*storage.S3"},
+	}
+	for _, tt := range tests {
+		s, err := New(tt.cfg)
+		if err != nil {
+			t.Errorf("New(%+v): %v", tt.cfg, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", s); got != tt.want {
+			t.Errorf("New(%+v) = %s, want %s", tt.cfg, got, tt.want)
+		}
+	}
+
+	for _, cfg := range []Config{
+		{Driver: "ftp"},
+		{Driver: "local"},
+		{Driver: "s3", S3Bucket: "shm", S3AccessKey: "ak", S3SecretKey: "sk"},
+		{Driver: "s3", S3Endpoint: "minio:9000", S3Bucket: "shm", S3AccessKey: "ak", S3SecretKey: "sk"},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v): expected error", cfg)
+		}
+	}
+}
+
+// testStorage проверяет общий контракт Storage.
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+	const key = "payment/12/3f9a"
+
+	if _, err := s.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Open of a missing file: %v, want ErrNotFound", err)
+	}
+	if err := s.Put(ctx, key, []byte("first"), "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Put(ctx, key, []byte("second"), "image/png"); err != nil {
+		t.Fatalf("Put over an existing file: %v", err)
+	}
+	if got := readAll(t, s, key); got != "second" {
+		t.Errorf("Open = %q, want %q", got, "second")
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete: %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing file: %v", err)
+	}
+	if err := s.Put(ctx, "../outside", []byte("x"), ""); err == nil {
+		t.Error("Put with an invalid key: expected error")
+	}
+}
+
+func readAll(t *testing.T, s Storage, key string) string {
+	t.Helper()
+	f, err := s.Open(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Open(%q): %v", key, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %q: %v", key, err)
+	}
+	return string(data)
+}
+
+func TestMemory(t *testing.T) {
+	testStorage(t, NewMemory())
+}
+
+func TestLocal(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "attachments")
+	s, err := NewLocal(dir)
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	testStorage(t, s)
+
+	if err := s.Put(context.Background(), "order/1/abc", []byte("data"), ""); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "order", "1", "abc")); err != nil || string(data) != "data" {
+		t.Errorf("file on disk = %q, %v", data, err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "order", "1"))
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the file without temporaries", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(root, "outside")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("invalid key wrote outside the storage directory")
+	}
+}
+
+// fakeS3 — S3-сервер в памяти, который проверяет подпись каждого запроса.
+type fakeS3 struct {
+	t      *testing.T
+	signer *S3
+	mu     sync.Mutex
+	files  map[string][]byte
+	fail   bool
+}
+
+var authorizationRE = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=ak/\d{8}/ru-central1/s3/aws4_request, SignedHeaders=([a-z0-9;-]+), Signature=[0-9a-f]{64}$`)
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	auth := r.Header.Get("Authorization")
+	m := authorizationRE.FindStringSubmatch(auth)
+	if m == nil {
+		f.t.Errorf("%s %s: Authorization = %q", r.Method, r.URL.Path, auth)
+	} else if m[1] != "host;x-amz-content-sha256;x-amz-date" && m[1] != "content-type;host;x-amz-content-sha256;x-amz-date" {
+		f.t.Errorf("%s %s: SignedHeaders = %q", r.Method, r.URL.Path, m[1])
+	}
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != sha256Hex(body) {
+		f.t.Errorf("%s %s: X-Amz-Content-Sha256 = %q, want hash of the body", r.Method, r.URL.Path, got)
+	}
+	// Подпись должна сходиться с тем, что реально пришло на сервер: путь, хост и тело.
+	now, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		f.t.Errorf("%s %s: X-Amz-Date: %v", r.Method, r.URL.Path, err)
+	}
+	check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.EscapedPath(), nil)
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		check.Header.Set("Content-Type", ct)
+	}
+	f.signer.sign(check, r.URL.EscapedPath(), body, now)
+	if want := check.Header.Get("Authorization"); auth != want {
+		f.t.Errorf("%s %s: signature mismatch:\n got %s\nwant %s", r.Method, r.URL.Path, auth, want)
+	}
+
+	if f.fail {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/shm/")
+	if !ok {
+		f.t.Errorf("%s %s: path must start with the bucket", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		f.files[key] = body
+	case http.MethodGet:
+		data, ok := f.files[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.files, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newFakeS3(t *testing.T) (*S3, *fakeS3) {
+	fake := &fakeS3{t: t, files: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	s, err := NewS3(server.URL+"/", "ru-central1", "shm", "ak", "sk")
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	fake.signer = s
+	return s, fake
+}
+
+func TestS3(t *testing.T) {
+	s, fake := newFakeS3(t)
+	testStorage(t, s)
+
+	ctx := context.Background()
+	if err := s.Put(ctx, "product/7/фото 1.jpg", []byte("jpeg"), "image/jpeg"); err != nil {
+		t.Fatalf("Put with escaped key: %v", err)
+	}
+	if _, ok := fake.files["product/7/фото 1.jpg"]; !ok {
+		t.Errorf("escaped key was not stored as is: %v", fake.files)
+	}
+	if got := readAll(t, s, "product/7/фото 1.jpg"); got != "jpeg" {
+		t.Errorf("Open = %q, want %q", got, "jpeg")
+	}
+
+	fake.fail = true
+	err := s.Put(ctx, "order/1/abc", []byte("x"), "")
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Put on 403 = %v, want error with status and body", err)
+	}
+	if _, err := s.Open(ctx, "order/1/abc"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Open on 403 = %v, want S3 error", err)
+	}
+	if err := s.Delete(ctx, "order/1/abc"); err == nil {
+		t.Error("Delete on 403: expected error")
+	}
+}
+
+func TestNewS3DefaultRegion(t *testing.T) {
+	s, err := NewS3("https://storage.yandexcloud.net", "", "shm", "ak", "sk")
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	if s.region != "us-east-1" {
+		t.Errorf("region = %q, want us-east-1", s.region)
+	}
+}
@@ -99,14 +99,17 @@ export default function ArticleTable({ articles, setArticles, filter, token, use
 
     try {
       if (editingArticleServiceId) {
-        await axios.put(
+        const version = (articles || []).find((article) => (article.serviceId ?? article.id) === editingArticleServiceId)?.version;
+        const res = await axios.put(
           `/api/articles/${editingArticleServiceId}`,
           articleForm,
-          { headers }
+          { headers: { ...headers, "If-Match": `"${version}"` } }
         );
         setArticles((prev) =>
           (prev || []).map((article) =>
-            (article.serviceId ?? article.id) === editingArticleServiceId ? { ...article, ...articleForm, serviceId: editingArticleServiceId } : article
+            (article.serviceId ?? article.id) === editingArticleServiceId
+              ? { ...article, ...articleForm, serviceId: editingArticleServiceId, version: res.data.version }
+              : article
           )
         );
       } else {
@@ -0,0 +1,144 @@
+import React, { useCallback, useEffect, useState } from "react";
+import axios from "axios";
+
+function formatSize(bytes) {
+  if (bytes >= 1 << 20) return `${(bytes / (1 << 20)).toFixed(1)} MB`;
+  return `${Math.max(1, Math.round(bytes / 1024))} KB`;
+}
+
+// Files attached to a payment, order, shipment or product. `entity` is the API collection:
+// "payments", "orders", "shipments" or "products".
+export default function Attachments({ token, entity, id, title, onClose }) {
+  const [files, setFiles] = useState([]);
+  const [file, setFile] = useState(null);
+  const [error, setError] = useState("");
+  const [busy, setBusy] = useState(false);
+  const headers = { Authorization: token };
+  const base = `/api/${entity}/${id}/attachments`;
+
+  const load = useCallback(async () => {
+    try {
+      const res = await axios.get(base, { headers: { Authorization: token } });
+      setFiles(res.data || []);
+    } catch (err) {
+      setError(err.response?.data?.error || "Failed to load files");
+    }
+  }, [base, token]);
+
+  useEffect(() => {
+    load();
+  }, [load]);
+
+  const upload = async () => {
+    if (!file) return;
+    setBusy(true);
+    setError("");
+    try {
+      const data = new FormData();
+      data.append("file", file);
+      await axios.post(base, data, { headers });
+      setFile(null);
+      await load();
+    } catch (err) {
+      setError(err.response?.data?.error || "Failed to upload file");
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  // Downloads go through axios: the token is a header, so a plain link would be unauthorized.
+  const open = async (a) => {
+    try {
+      const res = await axios.get(`${base}/${a.id}`, { headers, responseType: "blob" });
+      const url = URL.createObjectURL(res.data);
+      if (a.content_type.startsWith("image/") || a.content_type === "application/pdf") {
+        window.open(url, "_blank");
+      } else {
+        const link = document.createElement("a");
+        link.href = url;
+        link.download = a.file_name;
+        link.click();
+      }
+      setTimeout(() => URL.revokeObjectURL(url), 60000);
+    } catch (err) {
+      setError(err.response?.status === 403 ? "Access denied" : "Failed to open file");
+    }
+  };
+
+  const remove = async (a) => {
+    if (!window.confirm(`Delete ${a.file_name}?`)) return;
+    try {
+      await axios.delete(`${base}/${a.id}`, { headers });
+      await load();
+    } catch (err) {
+      setError(err.response?.data?.error || "Failed to delete file");
+    }
+  };
+
+  return (
+    <div className="fixed inset-0 bg-slate-900/30 backdrop-blur-[2px] flex items-center justify-center z-50">
+      <div className="bg-white p-6 rounded-xl shadow-xl w-full max-w-2xl max-h-[90vh] overflow-auto">
+        <h3 className="text-lg font-bold mb-3">{title || "Files"}</h3>
+        {error && <p className="wm-error mb-3">{error}</p>}
+
+        <div className="wm-table-wrap mb-4">
+          <table className="wm-table">
+            <thead>
+              <tr>
+                <th className="wm-th">File</th>
+                <th className="wm-th text-right">Size</th>
+                <th className="wm-th">Uploaded</th>
+                <th className="wm-th"></th>
+              </tr>
+            </thead>
+            <tbody>
+              {files.length === 0 ? (
+                <tr>
+                  <td colSpan="4" className="wm-empty">
+                    No files
+                  </td>
+                </tr>
+              ) : (
+                files.map((a) => (
+                  <tr key={a.id}>
+                    <td className="wm-td">
+                      <button onClick={() => open(a)} className="text-blue-600 hover:underline text-left">
+                        {a.file_name}
+                      </button>
+                    </td>
+                    <td className="wm-td text-right">{formatSize(a.size)}</td>
+                    <td className="wm-td">
+                      {a.created_at}
+                      {a.uploaded_by && <div className="text-xs text-gray-500">{a.uploaded_by}</div>}
+                    </td>
+                    <td className="wm-td wm-action-cell">
+                      <button onClick={() => remove(a)} className="wm-btn wm-btn-danger">
+                        Delete
+                      </button>
+                    </td>
+                  </tr>
+                ))
+              )}
+            </tbody>
+          </table>
+        </div>
+
+        <div className="flex items-center gap-3">
+          <input
+            type="file"
+            accept="image/*,.heic,.pdf,.docx,.xlsx"
+            onChange={(e) => setFile(e.target.files[0] || null)}
+            className="wm-input flex-1"
+          />
+          <button onClick={upload} disabled={!file || busy} className="wm-btn wm-btn-primary">
+            Upload
+          </button>
+          <button onClick={onClose} className="wm-btn">
+            Close
+          </button>
+        </div>
+        <p className="text-sm text-gray-500 mt-2">Images, PDF, DOCX or XLSX.</p>
+      </div>
+    </div>
+  );
+}
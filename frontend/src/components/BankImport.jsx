@@ -0,0 +1,204 @@
+import React, { useState } from "react";
+import axios from "axios";
+
+const REASON_LABELS = {
+  amount: "amount",
+  partial_amount: "partial amount",
+  phone: "phone",
+  order_number: "order №",
+  payer_name: "payer",
+};
+
+function formatAmount(n) {
+  return Number(n || 0).toLocaleString(undefined, { maximumFractionDigits: 2 });
+}
+
+// A confident match is preselected; lines that look like a manually entered payment are left for review.
+function defaultChoice(line) {
+  const best = line.matches && line.matches[0];
+  if (best && best.score >= 50 && (!line.possible_duplicates || line.possible_duplicates.length === 0)) {
+    return `order:${best.order_id}`;
+  }
+  return "";
+}
+
+export default function BankImport({ token, methods, onClose, onConfirmed }) {
+  const [method, setMethod] = useState("");
+  const [file, setFile] = useState(null);
+  const [statement, setStatement] = useState(null);
+  const [choices, setChoices] = useState({});
+  const [busy, setBusy] = useState(false);
+  const [error, setError] = useState("");
+
+  const headers = { Authorization: token };
+
+  const applyStatement = (data) => {
+    setStatement(data);
+    const next = {};
+    for (const line of data.lines || []) {
+      if (line.status === "new") next[line.id] = defaultChoice(line);
+    }
+    setChoices(next);
+  };
+
+  const handleUpload = async () => {
+    if (!method || !file) {
+      setError("Select a payment method and a statement file");
+      return;
+    }
+    const body = new FormData();
+    body.append("method", method);
+    body.append("file", file);
+    try {
+      setBusy(true);
+      setError("");
+      const res = await axios.post("/api/bank_statements/import", body, { headers });
+      applyStatement(res.data);
+    } catch (err) {
+      setError(err.response?.data?.error || "Failed to import statement");
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  const handleConfirm = async () => {
+    const items = Object.entries(choices)
+      .filter(([, choice]) => choice)
+      .map(([lineId, choice]) => {
+        const [kind, id] = choice.split(":");
+        const item = { line_id: Number(lineId) };
+        if (kind === "order") item.order_id = Number(id);
+        if (kind === "ignore") item.ignore = true;
+        return item;
+      });
+    if (items.length === 0) {
+      setError("Nothing selected");
+      return;
+    }
+    try {
+      setBusy(true);
+      setError("");
+      const res = await axios.post(`/api/bank_statements/${statement.id}/confirm`, { items }, { headers });
+      applyStatement(res.data);
+      onConfirmed();
+    } catch (err) {
+      setError(err.response?.data?.error || "Failed to confirm payments");
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  const lines = statement ? statement.lines || [] : [];
+
+  return (
+    <div className="fixed inset-0 bg-slate-900/30 backdrop-blur-[2px] flex items-center justify-center z-50">
+      <div className="bg-white p-6 rounded-xl shadow-xl w-full max-w-5xl max-h-[90vh] overflow-auto">
+        <h3 className="text-lg font-bold mb-3">Import bank statement</h3>
+        {error && <p className="wm-error mb-3">{error}</p>}
+
+        {!statement ? (
+          <div className="space-y-3">
+            <select value={method} onChange={(e) => setMethod(e.target.value)} className="wm-select w-full">
+              <option value="">Select method</option>
+              {methods
+                .filter(({ active }) => active !== false)
+                .map(({ method: code, display_name }) => (
+                  <option key={code} value={code}>
+                    {display_name || code}
+                  </option>
+                ))}
+            </select>
+            <input
+              type="file"
+              accept=".txt,.csv"
+              onChange={(e) => setFile(e.target.files[0] || null)}
+              className="wm-input w-full"
+            />
+            <p className="text-sm text-gray-500">1C ClientBankExchange (.txt) or CSV with a header row.</p>
+          </div>
+        ) : (
+          <>
+            <p className="mb-3 text-sm">
+              {statement.file_name}: new lines {statement.imported ?? lines.length}
+              {statement.skipped ? `, already imported ${statement.skipped}` : ""}
+            </p>
+            <div className="wm-table-wrap">
+              <table className="wm-table">
+                <thead>
+                  <tr>
+                    <th className="wm-th">Date</th>
+                    <th className="wm-th">Amount</th>
+                    <th className="wm-th">Payer</th>
+                    <th className="wm-th">Purpose</th>
+                    <th className="wm-th">Order</th>
+                  </tr>
+                </thead>
+                <tbody>
+                  {lines.length === 0 ? (
+                    <tr>
+                      <td colSpan="5" className="wm-empty">
+                        No incoming payments
+                      </td>
+                    </tr>
+                  ) : (
+                    lines.map((line) => (
+                      <tr key={line.id}>
+                        <td className="wm-td">{(line.date || "").slice(0, 10)}</td>
+                        <td className="wm-td text-right">{formatAmount(line.amount)}</td>
+                        <td className="wm-td">{line.payer_name}</td>
+                        <td className="wm-td">
+                          {line.purpose}
+                          {line.possible_duplicates && line.possible_duplicates.length > 0 && (
+                            <div className="wm-error text-sm">
+                              Possible duplicate of payment #{line.possible_duplicates.join(", #")}
+                            </div>
+                          )}
+                        </td>
+                        <td className="wm-td">
+                          {line.status !== "new" ? (
+                            line.status
+                          ) : (
+                            <select
+                              value={choices[line.id] || ""}
+                              onChange={(e) => setChoices({ ...choices, [line.id]: e.target.value })}
+                              className="wm-select w-full"
+                            >
+                              <option value="">Decide later</option>
+                              {line.matches.map((m) => (
+                                <option key={m.order_id} value={`order:${m.order_id}`}>
+                                  {`${m.order_name || `#${m.order_id}`} — ${m.client_name}, debt ${formatAmount(m.debt)} (${m.reasons
+                                    .map((r) => REASON_LABELS[r] || r)
+                                    .join(", ")})`}
+                                </option>
+                              ))}
+                              <option value="ignore">Ignore</option>
+                            </select>
+                          )}
+                        </td>
+                      </tr>
+                    ))
+                  )}
+                </tbody>
+              </table>
+            </div>
+          </>
+        )}
+
+        <div className="flex justify-end gap-2 mt-5">
+          <button onClick={onClose} className="wm-btn">
+            Close
+          </button>
+          {!statement ? (
+            <button onClick={handleUpload} className="wm-btn wm-btn-primary" disabled={busy}>
+              Upload
+            </button>
+          ) : (
+            <button onClick={handleConfirm} className="wm-btn wm-btn-primary" disabled={busy}>
+              Confirm selected
+            </button>
+          )}
+        </div>
+      </div>
+    </div>
+  );
+}
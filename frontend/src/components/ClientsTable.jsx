@@ -86,10 +86,13 @@ export default function ClientsTable({ clients, setClients, filter, token, usern
 
     try {
       if (editingClientId) {
-        await axios.put(`/api/clients/${editingClientId}`, clientForm, { headers });
+        const version = (clients || []).find((client) => client.id === editingClientId)?.version;
+        const res = await axios.put(`/api/clients/${editingClientId}`, clientForm, {
+          headers: { ...headers, "If-Match": `"${version}"` },
+        });
         setClients((prev) =>
           (prev || []).map((client) =>
-            client.id === editingClientId ? { ...client, ...clientForm } : client
+            client.id === editingClientId ? { ...client, ...clientForm, version: res.data.version } : client
           )
         );
       } else {
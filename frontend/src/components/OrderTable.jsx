@@ -1,6 +1,7 @@
 import React, { useState, useEffect, useMemo, useCallback } from 'react';
 import axios from 'axios';
 import useResizableColumns from "./useResizableColumns";
+import useIdempotencyKey from "./useIdempotencyKey";
 
 const ORDER_COLUMNS_STORAGE_KEY = 'wm_orders_columns_v1';
 
@@ -70,6 +71,16 @@ function createEmptyOrder() {
   };
 }
 
+// The delivery field of the form is stored as the order's "delivery" adjustments; the other
+// adjustments are sent back unchanged. `order.price` is their sum, computed by the server.
+function withDeliveryAdjustment(adjustments = [], delivery) {
+  const rest = (adjustments || [])
+    .filter((a) => a.kind !== 'delivery')
+    .map((a) => ({ kind: a.kind, amount: a.amount, comment: a.comment || '' }));
+  const amount = normalizeNumber(delivery);
+  return amount > 0 ? [...rest, { kind: 'delivery', amount, comment: '' }] : rest;
+}
+
 function createShipmentDraftFromOrder(order = {}) {
   return {
     ship_date: order.ship_date || '',
@@ -79,7 +90,7 @@ function createShipmentDraftFromOrder(order = {}) {
     passport_inn: order.passport_inn || '',
     tk: order.tk || '',
     places: order.places ?? '',
-    price: order.price ?? '',
+    price: '',
     weight: order.weight ?? '',
   };
 }
@@ -106,9 +117,10 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
   const [orderShippingShowSuggestions, setOrderShippingShowSuggestions] = useState(false);
   const [orderShippingActiveField, setOrderShippingActiveField] = useState(null);
   const [orderPlacesManuallyEdited, setOrderPlacesManuallyEdited] = useState(false);
-  const [orderPriceManuallyEdited, setOrderPriceManuallyEdited] = useState(false);
   const [orderWeightManuallyEdited, setOrderWeightManuallyEdited] = useState(false);
   const { columnWidths, setColumnWidths, handleResizeStart } = useResizableColumns(ORDER_COLUMNS_STORAGE_KEY, DEFAULT_ORDER_COLUMN_WIDTHS);
+  const orderCreateKey = useIdempotencyKey();
+  const shipmentCreateKey = useIdempotencyKey();
 
   const statusOptions = [
     { value: 0, label: 'Новый' },
@@ -129,7 +141,7 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
       const response = await axios.get('/api/payment_methods', {
         headers: { Authorization: token },
       });
-      setPaymentMethods(response.data.map((method) => ({ value: method.method, label: method.method })));
+      setPaymentMethods(response.data.map((method) => ({ value: method.method, label: method.display_name || method.method, active: method.active !== false })));
     } catch (error) {
       setError(error.response?.data?.error || 'Failed to fetch payment methods');
     } finally {
@@ -200,8 +212,8 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
       places: order.places ?? '',
       price: order.price ?? '',
       weight: order.weight ?? '',
-      payments: order.payments?.length > 0
-        ? order.payments.map((p) => ({ id: p.id, method: p.method, amount: p.amount.toString(), comment: p.comment || '' }))
+      payments: order.payments?.some((p) => p.type !== 'refund')
+        ? order.payments.filter((p) => p.type !== 'refund').map((p) => ({ id: p.id, method: p.method, amount: String(p.original_amount ?? p.amount), comment: p.comment || '' }))
         : [{ method: '', amount: '', comment: '' }],
       debt: order.debt || 0,
     });
@@ -212,12 +224,34 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
     setOrderShippingShowSuggestions(false);
     setOrderShippingActiveField(null);
     setOrderPlacesManuallyEdited(Boolean(order?.places));
-    setOrderPriceManuallyEdited(Boolean(order?.price));
     setOrderWeightManuallyEdited(Boolean(order?.weight));
     setShowOrderModal(true);
     setShowAddForm(true);
   };
 
+  // Payments live in their own sub-resource so that saving the order never rewrites payment dates.
+  const syncOrderPayments = async (orderId, originalPayments, editedPayments) => {
+    const headers = { Authorization: token };
+    const editedIds = new Set(editedPayments.filter((p) => p.id).map((p) => p.id));
+    for (const payment of originalPayments) {
+      if (!editedIds.has(payment.id)) {
+        await axios.delete(`/api/orders/${orderId}/payments/${payment.id}`, { headers });
+      }
+    }
+    for (const payment of editedPayments) {
+      // Amounts are entered in the payment method's currency; the server converts them to RUB.
+      const body = { method: payment.method, amount: payment.amount, original_amount: payment.amount, comment: payment.comment || '' };
+      if (!payment.id) {
+        await axios.post(`/api/orders/${orderId}/payments`, body, { headers });
+        continue;
+      }
+      const original = originalPayments.find((p) => p.id === payment.id);
+      if (!original || original.method !== body.method || (original.original_amount ?? original.amount) !== body.amount || (original.comment || '') !== body.comment) {
+        await axios.put(`/api/orders/${orderId}/payments/${payment.id}`, body, { headers });
+      }
+    }
+  };
+
   const handleSaveOrder = async () => {
     if (!validateForm()) return;
     try {
@@ -239,16 +273,24 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
         passport_inn: newOrder.passport_inn || '',
         tk: newOrder.tk || '',
         places: Math.trunc(normalizeNumber(newOrder.places)),
-        price: normalizeNumber(newOrder.price),
+        adjustments: withDeliveryAdjustment(orders.find((o) => o.id === newOrder.id)?.adjustments, newOrder.price),
         weight: normalizeNumber(newOrder.weight),
-        payments: formattedPayments,
         debt: newOrder.debt,
       };
+      delete updatedOrder.payments;
+      delete updatedOrder.price;
+      // Refunds are managed separately and never touched by the edit form.
+      const originalPayments = (orders.find((o) => o.id === newOrder.id)?.payments || []).filter((p) => p.type !== 'refund');
+      const version = orders.find((o) => o.id === newOrder.id)?.version ?? newOrder.version;
       const response = await axios.put(`/api/orders/${newOrder.id}`, updatedOrder, {
-        headers: { Authorization: token },
+        headers: { Authorization: token, 'If-Match': `"${version}"` },
       });
       const serverOrder = response?.data?.order;
       setOrders(orders.map((o) => (o.id === newOrder.id ? (serverOrder || { ...o, ...updatedOrder }) : o)));
+      // Payments are synced only once the version check has passed, so a stale form never touches them.
+      await syncOrderPayments(newOrder.id, originalPayments, formattedPayments);
+      const refreshed = await axios.get(`/api/orders/${newOrder.id}`, { headers: { Authorization: token } });
+      setOrders((current) => current.map((o) => (o.id === newOrder.id ? refreshed.data : o)));
       resetForm();
     } catch (error) {
       setError(error.response?.data?.error || 'Failed to update order');
@@ -279,17 +321,19 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
         passport_inn: newOrder.passport_inn || '',
         tk: newOrder.tk || '',
         places: Math.trunc(normalizeNumber(newOrder.places)),
-        price: normalizeNumber(newOrder.price),
+        adjustments: withDeliveryAdjustment([], newOrder.price),
         weight: normalizeNumber(newOrder.weight),
         payments: formattedPayments,
         debt: newOrder.debt,
       };
       const response = await axios.post('/api/orders', orderData, {
-        headers: { Authorization: token },
+        headers: { Authorization: token, ...orderCreateKey.idempotencyHeaders() },
       });
+      orderCreateKey.settleIdempotencyKey();
       setOrders([...orders, response.data]);
       resetForm();
     } catch (error) {
+      orderCreateKey.settleIdempotencyKey(error);
       setError(error.response?.data?.error || 'Failed to add order');
     } finally {
       setSubmitting(false);
@@ -320,7 +364,7 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
       ...createShipmentDraftFromOrder(order),
       ship_date: defaultDate,
       places: order?.places ?? autoPlaces ?? '',
-      price: autoTotals.price > 0 ? autoTotals.price : (order?.price ?? ''),
+      price: autoTotals.price > 0 ? autoTotals.price : '',
       weight: autoTotals.weight > 0 ? autoTotals.weight : (order?.weight ?? ''),
     });
     setShipmentShowSuggestions(false);
@@ -358,13 +402,17 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
         weight: normalizeNumber(shipmentDraft.weight),
       };
 
-      const res = await axios.post('/api/shipments', payload, { headers });
+      const res = await axios.post('/api/shipments', payload, {
+        headers: { ...headers, ...shipmentCreateKey.idempotencyHeaders() },
+      });
+      shipmentCreateKey.settleIdempotencyKey();
       setShipments((prev) => [res.data, ...(prev || [])]);
       setShipmentModalOrder(null);
       setShipmentDraft(createShipmentDraftFromOrder());
       setShipmentShowSuggestions(false);
       setShipmentActiveField(null);
     } catch (error) {
+      shipmentCreateKey.settleIdempotencyKey(error);
       setError(error.response?.data?.error || 'Не удалось создать отправку из заказа');
     } finally {
       setSubmitting(false);
@@ -500,7 +548,6 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
     setOrderShippingShowSuggestions(false);
     setOrderShippingActiveField(null);
     setOrderPlacesManuallyEdited(false);
-    setOrderPriceManuallyEdited(false);
     setOrderWeightManuallyEdited(false);
   };
 
@@ -681,13 +728,6 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
     setNewOrder((prev) => ({ ...prev, places: effectiveSelectedCount > 0 ? String(effectiveSelectedCount) : '' }));
   }, [showOrderModal, newOrder.status, orderPlacesManuallyEdited, effectiveSelectedCount]);
 
-  useEffect(() => {
-    if (!showOrderModal || newOrder.status < 1 || orderPriceManuallyEdited) {
-      return;
-    }
-    setNewOrder((prev) => ({ ...prev, price: effectiveSelectedTotals.price > 0 ? String(effectiveSelectedTotals.price) : '' }));
-  }, [showOrderModal, newOrder.status, orderPriceManuallyEdited, effectiveSelectedTotals.price]);
-
   useEffect(() => {
     if (!showOrderModal || newOrder.status < 1 || orderWeightManuallyEdited) {
       return;
@@ -773,7 +813,6 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
               setOrderShippingShowSuggestions(false);
               setOrderShippingActiveField(null);
               setOrderPlacesManuallyEdited(false);
-              setOrderPriceManuallyEdited(false);
               setOrderWeightManuallyEdited(false);
             }}
             className="wm-btn wm-btn-primary"
@@ -1014,14 +1053,11 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
                         type="number"
                         step="0.01"
                         value={newOrder.price ?? ''}
-                        onChange={(e) => {
-                          setOrderPriceManuallyEdited(true);
-                          setNewOrder({ ...newOrder, price: e.target.value });
-                        }}
+                        onChange={(e) => setNewOrder({ ...newOrder, price: e.target.value })}
                         className="wm-input"
-                        placeholder="Цена отправки (руб.)"
+                        placeholder="Доставка до ТК (руб.)"
                       />
-                      <p className="text-xs text-gray-500 -mt-1">Авто: сумма `summaRubSoSkidkoj` всех товаров заказа.</p>
+                      <p className="text-xs text-gray-500 -mt-1">Входит в сумму заказа и долг клиента.</p>
                       <input
                         type="number"
                         step="0.01"
@@ -1058,7 +1094,7 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
                         disabled={submitting}
                       >
                         <option value="">Select Payment Method</option>
-                        {paymentMethods.map((option) => (
+                        {paymentMethods.filter((option) => option.active || option.value === payment.method).map((option) => (
                           <option key={option.value} value={option.value}>
                             {option.label}
                           </option>
@@ -1243,7 +1279,7 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
                         newOrder.passport_inn,
                         newOrder.tk,
                         newOrder.places !== '' ? `мест: ${newOrder.places}` : '',
-                        newOrder.price !== '' ? `цена: ${newOrder.price}` : '',
+                        newOrder.price !== '' ? `доставка: ${newOrder.price}` : '',
                         newOrder.weight !== '' ? `вес: ${newOrder.weight}` : '',
                       ].filter(Boolean).join(', ') || 'не заполнено'}
                     </p>
@@ -1534,7 +1570,7 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
                         {order.phone && <div>Тел: {order.phone}</div>}
                         {order.tk && <div>ТК: {order.tk}</div>}
                         {order.places ? <div>Мест: {order.places}</div> : null}
-                        {order.price ? <div>Цена: {order.price}</div> : null}
+                        {order.price ? <div>Доставка: {order.price}</div> : null}
                         {order.weight ? <div>Вес: {order.weight}</div> : null}
                       </>
                     ) : (
@@ -1548,10 +1584,21 @@ export default function OrderTable({ orders, setOrders, setShipments = () => {},
                   </td>
                   <td className="wm-td">
                     {order.payments?.map((p, index) => (
-                      <div key={index}>{p.method}: {p.amount}</div>
+                      <div key={index} title={p.reason || undefined}>
+                        {p.method}: {p.amount}
+                        {p.currency && p.currency !== 'RUB' && ` (${p.original_amount} ${p.currency})`}
+                        {p.type === 'refund' && ` (refund: ${p.reason})`}
+                        {p.status === 'pending' && ' (pending)'}
+                        {p.status === 'rejected' && ` (rejected: ${p.status_comment})`}
+                      </div>
                     )) || ''}
                   </td>
-                  <td className="wm-td">{order.debt || 0}</td>
+                  <td className="wm-td">
+                    {order.debt || 0}
+                    {order.pending_paid > 0 && (
+                      <div className="text-xs text-gray-500">unconfirmed: {order.pending_paid}</div>
+                    )}
+                  </td>
                   <td className="wm-td wm-action-cell">
                     <div className="wm-action-buttons">
                     <button
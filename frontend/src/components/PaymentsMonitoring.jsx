@@ -1,6 +1,10 @@
 import React, { useState, useEffect, useCallback, useMemo } from "react";
 import axios from "axios";
 import useResizableColumns from "./useResizableColumns";
+import useIdempotencyKey from "./useIdempotencyKey";
+import BankImport from "./BankImport";
+import PaymentsSummary from "./PaymentsSummary";
+import Attachments from "./Attachments";
 
 const PAYMENTS_COLUMNS_STORAGE_KEY = "wm_payments_columns_v1";
 const DEFAULT_PAYMENTS_COLUMN_WIDTHS = {
@@ -16,6 +20,56 @@ function formatAmount(n) {
   return Number(n || 0).toLocaleString(undefined, { maximumFractionDigits: 2 });
 }
 
+function isForeign(currency) {
+  return Boolean(currency) && currency !== "RUB";
+}
+
+// Sums original amounts per currency, e.g. { RUB: 1200, CNY: 350 }.
+function totalsByCurrency(rows) {
+  const totals = {};
+  for (const row of rows) {
+    const currency = row.currency || "RUB";
+    totals[currency] = (totals[currency] || 0) + Number(row.original_amount ?? row.amount ?? 0);
+  }
+  return totals;
+}
+
+function formatCurrencyTotals(totals) {
+  return Object.entries(totals)
+    .map(([currency, sum]) => `${formatAmount(sum)} ${currency}`)
+    .join(", ");
+}
+
+function AmountCell({ payment }) {
+  return (
+    <>
+      {formatAmount(payment.amount)}
+      {isForeign(payment.currency) && (
+        <div className="text-xs text-gray-500">
+          {formatAmount(payment.original_amount)} {payment.currency} × {payment.rate}
+        </div>
+      )}
+    </>
+  );
+}
+
+const STATUS_LABELS = {
+  pending: "Pending",
+  confirmed: "Confirmed",
+  rejected: "Rejected",
+};
+
+function StatusBadge({ payment }) {
+  const status = payment.status || "confirmed";
+  const color = status === "confirmed" ? "text-green-600" : status === "rejected" ? "text-red-600" : "text-amber-600";
+  const title = [payment.confirmed_by, payment.confirmed_at, payment.status_comment].filter(Boolean).join(" · ");
+  return (
+    <div className={`text-xs ${color}`} title={title}>
+      {STATUS_LABELS[status] || status}
+    </div>
+  );
+}
+
 function parseDateValue(value) {
   if (!value) return null;
   const normalized = value.includes("T") ? value : value.replace(" ", "T");
@@ -50,18 +104,23 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
   const [selectedByMethod, setSelectedByMethod] = useState({});
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState("");
-  const [viewMode, setViewMode] = useState("group"); // "group" or "list"
+  const [viewMode, setViewMode] = useState("group"); // "group", "list" or "summary"
   const [allPayments, setAllPayments] = useState([]); // For list view
   const [selectedPayments, setSelectedPayments] = useState(new Set()); // For list view
+  const [currency, setCurrency] = useState("");
+  const [status, setStatus] = useState("");
 
   // Add/Edit modal
   const [showModal, setShowModal] = useState(false);
+  const [showBankImport, setShowBankImport] = useState(false);
+  const [attachmentsFor, setAttachmentsFor] = useState(null);
   const [isEdit, setIsEdit] = useState(false);
   const [form, setForm] = useState({
       id: null,
       date: nowDateTimeLocal(),
       method: "",
       amount: "",
+      rate: "",
       comment: "",
   });
   const { columnWidths, handleResizeStart } = useResizableColumns(
@@ -70,6 +129,7 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
   );
 
   const headers = { Authorization: token };
+  const paymentCreateKey = useIdempotencyKey();
   const normalizedFilter = (filter || "").toLowerCase().trim();
   const columns = [
     { key: "select", label: "" },
@@ -96,6 +156,8 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
             headers,
             params: {
               method: m.method,
+              currency: currency || undefined,
+              status: status || undefined,
               date_from: dateFrom || undefined,
               date_to: dateTo || undefined,
             },
@@ -127,7 +189,7 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
     } finally {
       setLoading(false);
     }
-  }, [token, dateFrom, dateTo]);
+  }, [token, dateFrom, dateTo, currency, status]);
 
   useEffect(() => {
     reloadPayments();
@@ -221,10 +283,17 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
     return { sum, count, total: sum };
   }, [filteredAllPayments, selectedPayments]);
 
+  // Rejected payments are not money: they stay in the list but not in totals.
   const totalAmount = useCallback((rows) => {
-    return rows.reduce((sum, row) => sum + Number(row.amount ?? 0), 0);
+    return rows.reduce((sum, row) => (row.status === "rejected" ? sum : sum + Number(row.amount ?? 0)), 0);
   }, []);
 
+  const methodCurrency = (code) => methods.find((m) => m.method === code)?.currency || "RUB";
+  const currencies = useMemo(
+    () => [...new Set(["RUB", ...methods.map((m) => m.currency).filter(Boolean)])],
+    [methods]
+  );
+
   // Add/Edit handlers
   const openAddForm = (method = "") => {
     setIsEdit(false);
@@ -233,6 +302,7 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
       date: "",
       method: method,
       amount: "",
+      rate: "",
       comment: "",
     });
     setShowModal(true);
@@ -246,7 +316,8 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
       id: row.id,
       date: toDateTimeLocalValue(row.date),
       method: row.method,
-      amount: row.amount ?? "",
+      amount: (isForeign(row.currency) ? row.original_amount : row.amount) ?? "",
+      rate: isForeign(row.currency) ? row.rate : "",
       comment: row.comment || "",
     });
     setShowModal(true);
@@ -262,19 +333,78 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
         amount: Number(form.amount || 0),
         comment: form.comment || "",
       };
+      // For a foreign-currency method the amount is entered in that currency;
+      // without a rate the server takes the one for the payment date.
+      if (isForeign(methodCurrency(form.method))) {
+        payload.original_amount = payload.amount;
+        payload.rate = Number(form.rate || 0);
+      }
 
       if (isEdit && form.id != null) {
         await axios.put(`/api/payments/${form.id}`, payload, { headers });
       } else {
-        await axios.post("/api/payments", payload, { headers });
+        await axios.post("/api/payments", payload, {
+          headers: { ...headers, ...paymentCreateKey.idempotencyHeaders() },
+        });
+        paymentCreateKey.settleIdempotencyKey();
       }
       setShowModal(false);
       await reloadPayments();
     } catch (e) {
+      paymentCreateKey.settleIdempotencyKey(e);
       alert("Failed to save: " + (e?.response?.data?.error || e.message));
     }
   };
 
+  // Confirm/reject are allowed for manager and admin only; the server answers 403 to workers.
+  const handleConfirmPayments = async (ids) => {
+    if (ids.length === 0) return;
+    try {
+      if (ids.length === 1) {
+        await axios.post(`/api/payments/${ids[0]}/confirm`, {}, { headers });
+      } else {
+        await axios.post("/api/payments_monitoring/confirm", { ids }, { headers });
+      }
+      await reloadPayments();
+    } catch (e) {
+      alert("Failed to confirm: " + (e?.response?.data?.error || e.message));
+    }
+  };
+
+  const handleRejectPayment = async (id) => {
+    const comment = window.prompt("Rejection reason");
+    if (!comment) return;
+    try {
+      await axios.post(`/api/payments/${id}/reject`, { comment }, { headers });
+      await reloadPayments();
+    } catch (e) {
+      alert("Failed to reject: " + (e?.response?.data?.error || e.message));
+    }
+  };
+
+  // Shipped orders and confirmed payments for the accountant; the period comes from the page date filter.
+  const handleAccountingExport = async (format) => {
+    try {
+      const res = await axios.get("/api/accounting/export", {
+        headers,
+        params: { date_from: dateFrom, date_to: dateTo, format },
+        responseType: "blob",
+      });
+      const url = URL.createObjectURL(res.data);
+      const link = document.createElement("a");
+      link.href = url;
+      link.download = `1c-${dateFrom}-${dateTo}.${format === "csv" ? "csv" : "xml"}`;
+      link.click();
+      URL.revokeObjectURL(url);
+    } catch (e) {
+      const message = e?.response?.status === 403 ? "Access denied" : e.message;
+      alert("Failed to export for 1C: " + message);
+    }
+  };
+
+  const pendingIds = (rows, selected) =>
+    rows.filter((r) => selected.has(r.id) && r.status === "pending").map((r) => r.id);
+
   const handleDeletePayment = async (id) => {
     if (!window.confirm("Delete this payment?")) return;
     try {
@@ -305,19 +435,57 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
           >
             List
           </button>
+          <button
+            onClick={() => setViewMode("summary")}
+            className={`wm-btn ${viewMode === "summary" ? "wm-btn-primary" : ""}`}
+          >
+            Summary
+          </button>
+          <select value={currency} onChange={(e) => setCurrency(e.target.value)} className="wm-select">
+            <option value="">All currencies</option>
+            {currencies.map((code) => (
+              <option key={code} value={code}>
+                {code}
+              </option>
+            ))}
+          </select>
+          <select value={status} onChange={(e) => setStatus(e.target.value)} className="wm-select">
+            <option value="">All statuses</option>
+            {Object.entries(STATUS_LABELS).map(([value, label]) => (
+              <option key={value} value={value}>
+                {label}
+              </option>
+            ))}
+          </select>
+          <button onClick={() => setShowBankImport(true)} className="wm-btn">
+            Import statement
+          </button>
           <button
             onClick={() => exportToCSV(filteredAllPayments, "payments_all.csv")}
             className="wm-btn wm-btn-primary"
           >
             Export all CSV
           </button>
+          <button
+            onClick={() => handleAccountingExport("commerceml")}
+            disabled={!dateFrom || !dateTo}
+            title={dateFrom && dateTo ? "" : "Set both dates to export for 1C"}
+            className="wm-btn"
+          >
+            1C XML
+          </button>
+          <button onClick={() => handleAccountingExport("csv")} disabled={!dateFrom || !dateTo} className="wm-btn">
+            1C CSV
+          </button>
         </div>
       </div>
 
       {/* Date filters */}
       {error && <p className="wm-error mb-4">{error}</p>}
 
-      {viewMode === "group" ? (
+      {viewMode === "summary" ? (
+        <PaymentsSummary token={token} currency={currency} status={status} dateFrom={dateFrom} dateTo={dateTo} />
+      ) : viewMode === "group" ? (
         // Grouped view
         methods.map(({ id, method }) => {
           const rows = getRowsByMethod(method);
@@ -359,6 +527,14 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
                       Export selected
                     </button>
                   )}
+                  {pendingIds(rows, selected).length > 0 && (
+                    <button
+                      onClick={() => handleConfirmPayments(pendingIds(rows, selected))}
+                      className="wm-btn wm-btn-primary"
+                    >
+                      Confirm selected
+                    </button>
+                  )}
                   <button
                     onClick={() => openAddForm(method)}
                     className="wm-btn wm-btn-primary"
@@ -409,7 +585,8 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
                           <td className="wm-td" style={{ width: columnWidths.date }}>{formatDateTime(p.date)}</td>
                           <td className="wm-td" style={{ width: columnWidths.method }}>{p.method}</td>
                           <td className={`wm-td text-right ${p.amount > 0 ? "text-green-600" : "text-red-600"}`} style={{ width: columnWidths.amount }}>
-                            {formatAmount(p.amount)}
+                            <AmountCell payment={p} />
+                            <StatusBadge payment={p} />
                           </td>
                           <td className="wm-td" style={{ width: columnWidths.comment }}>{p.comment}</td>
                           <td className="wm-td wm-action-cell" style={{ width: columnWidths.actions }}>
@@ -417,6 +594,19 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
                               <button onClick={() => openEditForm(method, p.id)} className="wm-btn">
                                 Edit
                               </button>
+                              {p.status === "pending" && (
+                                <button onClick={() => handleConfirmPayments([p.id])} className="wm-btn wm-btn-primary">
+                                  Confirm
+                                </button>
+                              )}
+                              {p.status !== "rejected" && p.type !== "refund" && (
+                                <button onClick={() => handleRejectPayment(p.id)} className="wm-btn">
+                                  Reject
+                                </button>
+                              )}
+                              <button onClick={() => setAttachmentsFor(p.id)} className="wm-btn">
+                                Files
+                              </button>
                               <button onClick={() => handleDeletePayment(p.id)} className="wm-btn wm-btn-danger">
                                 Delete
                               </button>
@@ -434,6 +624,9 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
                     <td className="wm-td bg-gray-50 font-semibold text-right" style={{ width: columnWidths.method }}>Total:</td>
                     <td className="wm-td bg-gray-50 font-semibold text-right" style={{ width: columnWidths.amount }}>
                       {formatAmount(totalAmount(rows))}
+                      {isForeign(methodCurrency(method)) && (
+                        <div className="text-xs text-gray-500">{formatCurrencyTotals(totalsByCurrency(rows))}</div>
+                      )}
                     </td>
                     <td className="wm-td bg-gray-50 font-semibold" style={{ width: columnWidths.comment }}></td>
                     <td className="wm-td wm-action-cell bg-gray-50 font-semibold" style={{ width: columnWidths.actions }}></td>
@@ -485,6 +678,14 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
                   Export selected
                 </button>
               )}
+              {pendingIds(filteredAllPayments, selectedPayments).length > 0 && (
+                <button
+                  onClick={() => handleConfirmPayments(pendingIds(filteredAllPayments, selectedPayments))}
+                  className="wm-btn wm-btn-primary"
+                >
+                  Confirm selected
+                </button>
+              )}
               <button
                 onClick={() => openAddForm()}
                 className="wm-btn wm-btn-primary"
@@ -535,7 +736,8 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
                       <td className="wm-td" style={{ width: columnWidths.date }}>{formatDateTime(p.date)}</td>
                       <td className="wm-td" style={{ width: columnWidths.method }}>{p.method}</td>
                       <td className={`wm-td text-right ${p.amount > 0 ? "text-green-600" : "text-red-600"}`} style={{ width: columnWidths.amount }}>
-                        {formatAmount(p.amount)}
+                        <AmountCell payment={p} />
+                        <StatusBadge payment={p} />
                       </td>
                       <td className="wm-td" style={{ width: columnWidths.comment }}>{p.comment}</td>
                       <td className="wm-td wm-action-cell" style={{ width: columnWidths.actions }}>
@@ -543,6 +745,19 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
                           <button onClick={() => openEditForm(p.method, p.id)} className="wm-btn">
                             Edit
                           </button>
+                          {p.status === "pending" && (
+                            <button onClick={() => handleConfirmPayments([p.id])} className="wm-btn wm-btn-primary">
+                              Confirm
+                            </button>
+                          )}
+                          {p.status !== "rejected" && p.type !== "refund" && (
+                            <button onClick={() => handleRejectPayment(p.id)} className="wm-btn">
+                              Reject
+                            </button>
+                          )}
+                          <button onClick={() => setAttachmentsFor(p.id)} className="wm-btn">
+                            Files
+                          </button>
                           <button onClick={() => handleDeletePayment(p.id)} className="wm-btn wm-btn-danger">
                             Delete
                           </button>
@@ -560,6 +775,7 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
                 <td className="wm-td bg-gray-50 font-semibold text-right" style={{ width: columnWidths.method }}>Total:</td>
                 <td className="wm-td bg-gray-50 font-semibold text-right" style={{ width: columnWidths.amount }}>
                   {formatAmount(totalAmount(filteredAllPayments))}
+                  <div className="text-xs text-gray-500">{formatCurrencyTotals(totalsByCurrency(filteredAllPayments))}</div>
                 </td>
                 <td className="wm-td bg-gray-50 font-semibold" style={{ width: columnWidths.comment }}></td>
                 <td className="wm-td wm-action-cell bg-gray-50 font-semibold" style={{ width: columnWidths.actions }}></td>
@@ -576,6 +792,24 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
         </div>
       )}
 
+      {attachmentsFor && (
+        <Attachments
+          token={token}
+          entity="payments"
+          id={attachmentsFor}
+          title={`Payment #${attachmentsFor} files`}
+          onClose={() => setAttachmentsFor(null)}
+        />
+      )}
+      {showBankImport && (
+        <BankImport
+          token={token}
+          methods={methods}
+          onClose={() => setShowBankImport(false)}
+          onConfirmed={reloadPayments}
+        />
+      )}
+
       {showModal && (
         <div className="fixed inset-0 bg-slate-900/30 backdrop-blur-[2px] flex items-center justify-center z-50">
           <div className="bg-white p-6 rounded-xl shadow-xl w-full max-w-md">
@@ -593,19 +827,30 @@ export default function PaymentsMonitoring({ token, exportToCSV, filter = "", da
                 disabled={isEdit}
               >
                 <option value="">Select method</option>
-                {methods.map(({ method }) => (
-                  <option key={method} value={method}>
-                    {method}
-                  </option>
-                ))}
+                {methods
+                  .filter(({ method, active }) => active !== false || method === form.method)
+                  .map(({ method, display_name }) => (
+                    <option key={method} value={method}>
+                      {display_name || method}
+                    </option>
+                  ))}
               </select>
               <input
                 type="number"
                 value={form.amount}
                 onChange={(e) => setForm({ ...form, amount: e.target.value })}
                 className="wm-input w-full text-right"
-                placeholder="Amount"
+                placeholder={`Amount, ${methodCurrency(form.method)}`}
               />
+              {isForeign(methodCurrency(form.method)) && (
+                <input
+                  type="number"
+                  value={form.rate}
+                  onChange={(e) => setForm({ ...form, rate: e.target.value })}
+                  className="wm-input w-full text-right"
+                  placeholder="RUB rate (empty — rate on payment date)"
+                />
+              )}
               <textarea
                 value={form.comment}
                 onChange={(e) => setForm({ ...form, comment: e.target.value })}
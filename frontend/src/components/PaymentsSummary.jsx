@@ -0,0 +1,174 @@
+import React, { useEffect, useState } from "react";
+import axios from "axios";
+
+const PERIODS = [
+  { value: "day", label: "Day" },
+  { value: "week", label: "Week" },
+  { value: "month", label: "Month" },
+];
+
+function formatAmount(n) {
+  return Number(n || 0).toLocaleString(undefined, { maximumFractionDigits: 2 });
+}
+
+function isForeign(currency) {
+  return Boolean(currency) && currency !== "RUB";
+}
+
+// Totals come from /api/payments_monitoring/summary, so month-end figures match the server exactly.
+export default function PaymentsSummary({ token, currency = "", status = "", dateFrom = "", dateTo = "" }) {
+  const [period, setPeriod] = useState("day");
+  const [summary, setSummary] = useState(null);
+  const [loading, setLoading] = useState(true);
+  const [error, setError] = useState("");
+
+  useEffect(() => {
+    let cancelled = false;
+    setLoading(true);
+    setError("");
+    axios
+      .get("/api/payments_monitoring/summary", {
+        headers: { Authorization: token },
+        params: {
+          period,
+          currency: currency || undefined,
+          status: status || undefined,
+          date_from: dateFrom || undefined,
+          date_to: dateTo || undefined,
+        },
+      })
+      .then((res) => {
+        if (!cancelled) setSummary(res.data);
+      })
+      .catch((e) => {
+        if (!cancelled) setError(e?.response?.data?.error || e.message || "Failed to load summary");
+      })
+      .finally(() => {
+        if (!cancelled) setLoading(false);
+      });
+    return () => {
+      cancelled = true;
+    };
+  }, [token, period, currency, status, dateFrom, dateTo]);
+
+  if (loading) return <p>Loading…</p>;
+  if (error) return <p className="wm-error mb-4">{error}</p>;
+  if (!summary) return null;
+
+  return (
+    <div className="space-y-6">
+      <div className="flex items-center gap-6">
+        <div>Payments: {summary.count}</div>
+        <div className="font-semibold">Total: {formatAmount(summary.total)}</div>
+        {summary.refunds !== 0 && <div className="text-red-600">Refunds: {formatAmount(summary.refunds)}</div>}
+        {summary.pending !== 0 && <div className="text-amber-600">Unconfirmed: {formatAmount(summary.pending)}</div>}
+      </div>
+
+      <div className="wm-table-wrap">
+        <div className="px-4 py-3 bg-gray-50 font-semibold">By method</div>
+        <table className="wm-table">
+          <thead>
+            <tr>
+              <th className="wm-th">Method</th>
+              <th className="wm-th text-right">Count</th>
+              <th className="wm-th text-right">Refunds</th>
+              <th className="wm-th text-right">Unconfirmed</th>
+              <th className="wm-th text-right">Amount</th>
+            </tr>
+          </thead>
+          <tbody>
+            {summary.by_method.length === 0 ? (
+              <tr>
+                <td colSpan="5" className="wm-empty">
+                  No payments
+                </td>
+              </tr>
+            ) : (
+              summary.by_method.map((row) => (
+                <tr key={`${row.method}-${row.currency}`}>
+                  <td className="wm-td">{row.method}</td>
+                  <td className="wm-td text-right">{row.count}</td>
+                  <td className="wm-td text-right">{formatAmount(row.refunds)}</td>
+                  <td className="wm-td text-right">{formatAmount(row.pending)}</td>
+                  <td className="wm-td text-right">
+                    {formatAmount(row.amount)}
+                    {isForeign(row.currency) && (
+                      <div className="text-xs text-gray-500">
+                        {formatAmount(row.original_amount)} {row.currency}
+                      </div>
+                    )}
+                  </td>
+                </tr>
+              ))
+            )}
+          </tbody>
+        </table>
+      </div>
+
+      <div className="wm-table-wrap">
+        <div className="flex items-center justify-between px-4 py-3 bg-gray-50">
+          <div className="font-semibold">By period</div>
+          <select value={period} onChange={(e) => setPeriod(e.target.value)} className="wm-select">
+            {PERIODS.map(({ value, label }) => (
+              <option key={value} value={value}>
+                {label}
+              </option>
+            ))}
+          </select>
+        </div>
+        <table className="wm-table">
+          <thead>
+            <tr>
+              <th className="wm-th">Period</th>
+              <th className="wm-th text-right">Count</th>
+              <th className="wm-th text-right">Refunds</th>
+              <th className="wm-th text-right">Amount</th>
+            </tr>
+          </thead>
+          <tbody>
+            {summary.by_period.map((row) => (
+              <tr key={row.period}>
+                <td className="wm-td">{row.period}</td>
+                <td className="wm-td text-right">{row.count}</td>
+                <td className="wm-td text-right">{formatAmount(row.refunds)}</td>
+                <td className="wm-td text-right">{formatAmount(row.amount)}</td>
+              </tr>
+            ))}
+          </tbody>
+        </table>
+      </div>
+
+      {summary.days.length > 0 && (
+        <div className="wm-table-wrap overflow-x-auto">
+          <div className="px-4 py-3 bg-gray-50 font-semibold">Method × day</div>
+          <table className="wm-table">
+            <thead>
+              <tr>
+                <th className="wm-th">Method</th>
+                {summary.days.map((day) => (
+                  <th key={day} className="wm-th text-right">
+                    {day}
+                  </th>
+                ))}
+                <th className="wm-th text-right">Total</th>
+              </tr>
+            </thead>
+            <tbody>
+              {summary.pivot.map((row) => (
+                <tr key={row.method}>
+                  <td className="wm-td">{row.method}</td>
+                  {summary.days.map((day) => (
+                    <td key={day} className="wm-td text-right">
+                      {row.amounts[day] != null ? formatAmount(row.amounts[day]) : ""}
+                    </td>
+                  ))}
+                  <td className="wm-td text-right font-semibold">{formatAmount(row.total)}</td>
+                </tr>
+              ))}
+            </tbody>
+          </table>
+        </div>
+      )}
+    </div>
+  );
+}
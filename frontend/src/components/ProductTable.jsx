@@ -142,7 +142,10 @@ export default function ProductTable({ products, articles = [], setProducts, set
   const handleSaveProduct = useCallback(async () => {
     try {
       console.log("Saving product:", newProduct);
-      const response = await axios.put(`/api/products/${newProduct.id}`, newProduct, { headers });
+      const version = products.find((p) => p.id === newProduct.id)?.version ?? newProduct.version;
+      const response = await axios.put(`/api/products/${newProduct.id}`, newProduct, {
+        headers: { ...headers, "If-Match": `"${version}"` },
+      });
       setProducts(products.map((p) => (p.id === newProduct.id ? { ...response.data } : p)));
       if (typeof setOrders === "function") {
         const ordersResponse = await axios.get("/api/orders", { headers });
@@ -1,6 +1,7 @@
 import React, { useMemo, useState, useEffect } from "react";
 import axios from "axios";
 import useResizableColumns from "./useResizableColumns";
+import useIdempotencyKey from "./useIdempotencyKey";
 
 const SHIPPING_COLUMNS_STORAGE_KEY = "wm_shipping_columns_v1";
 const SHIPPING_NOTES_COLUMNS_STORAGE_KEY = "wm_shipping_notes_columns_v1";
@@ -68,6 +69,7 @@ export default function ShippingTable({ shipments, setShipments, filter, dateFro
     SHIPPING_NOTES_COLUMNS_STORAGE_KEY,
     DEFAULT_SHIPPING_NOTES_COLUMN_WIDTHS
   );
+  const shipmentCreateKey = useIdempotencyKey();
 
   const headers = {
     Authorization: token,
@@ -203,13 +205,17 @@ export default function ShippingTable({ shipments, setShipments, filter, dateFro
           )
         );
       } else {
-        const res = await axios.post("/api/shipments", payload, { headers });
+        const res = await axios.post("/api/shipments", payload, {
+          headers: { ...headers, ...shipmentCreateKey.idempotencyHeaders() },
+        });
+        shipmentCreateKey.settleIdempotencyKey();
         setShipments((prev) => [res.data, ...(prev || [])]);
       }
 
       setError("");
       resetForm();
     } catch (e) {
+      shipmentCreateKey.settleIdempotencyKey(e);
       const action = editingShipmentId ? "сохранения" : "создания";
       setError(`Ошибка ${action} отправки: ` + (e.response?.data?.error || e.message));
     }
@@ -0,0 +1,31 @@
+import { useCallback, useRef } from "react";
+
+function newKey() {
+  if (typeof crypto !== "undefined" && typeof crypto.randomUUID === "function") {
+    return crypto.randomUUID();
+  }
+  return `${Date.now().toString(36)}-${Math.random().toString(36).slice(2)}`;
+}
+
+// One Idempotency-Key per creation attempt: a double click or a retry after a dropped
+// connection reuses the key, so the server replays the first response instead of creating a duplicate.
+export default function useIdempotencyKey() {
+  const keyRef = useRef(null);
+
+  const idempotencyHeaders = useCallback(() => {
+    if (!keyRef.current) {
+      keyRef.current = newKey();
+    }
+    return { "Idempotency-Key": keyRef.current };
+  }, []);
+
+  // The server answered (success or validation error): the next submit is a new attempt.
+  // Without a response the request may still have been applied, so the key is kept for the retry.
+  const settleIdempotencyKey = useCallback((error) => {
+    if (!error || error.response) {
+      keyRef.current = null;
+    }
+  }, []);
+
+  return { idempotencyHeaders, settleIdempotencyKey };
+}